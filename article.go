@@ -7,6 +7,21 @@ func saveArticle(db *gorm.DB, article *Article) error {
 	return db.Save(article).Error
 }
 
+// GetArticleByID gets the Article with the given ID.
+func getArticleByID(db *gorm.DB, id uint, article *Article) error {
+	return db.First(article, id).Error
+}
+
+// CountArticlesByPageID counts the Articles on the given Page.
+func countArticlesByPageID(db *gorm.DB, pageID uint, count *int64) error {
+	return db.Table("articles").Where("page_id = ?", pageID).Count(count).Error
+}
+
+// CountArticlesByListID counts the Articles on all Pages of the given List.
+func countArticlesByListID(db *gorm.DB, listID uint, count *int64) error {
+	return db.Table("articles").Where("page_id IN (SELECT id FROM pages WHERE list_id = ?)", listID).Count(count).Error
+}
+
 func deleteArticlesByPageID(db *gorm.DB, pageID uint) error {
 	err := db.Where("page_id = ?", pageID).Delete(&Article{}).Error
 	if err != nil {
@@ -14,3 +29,24 @@ func deleteArticlesByPageID(db *gorm.DB, pageID uint) error {
 	}
 	return nil
 }
+
+// GetOldestArticleIDs gets the IDs of the first limit Articles on the Page.
+func getOldestArticleIDs(db *gorm.DB, pageID uint, limit int, ids *[]uint) error {
+	return db.Table("articles").Where("page_id = ?", pageID).Order("id").Limit(limit).Pluck("id", ids).Error
+}
+
+// GetLowestArticleIDs gets the IDs of the last articles of a Page of a
+// sorted List, which have the lowest scores, up to limit.
+func getLowestArticleIDs(db *gorm.DB, pageID uint, limit int, ids *[]uint) error {
+	return db.Table("articles").Where("page_id = ?", pageID).Order("score").Order("id DESC").Limit(limit).Pluck("id", ids).Error
+}
+
+// MoveArticles moves the Articles with the given IDs to the Page.
+func moveArticles(db *gorm.DB, ids []uint, pageID uint) error {
+	return db.Table("articles").Where("id IN ?", ids).UpdateColumn("page_id", pageID).Error
+}
+
+// DeleteArticlesByID deletes the Articles with the given IDs.
+func deleteArticlesByID(db *gorm.DB, ids []uint) error {
+	return db.Where("id IN ?", ids).Delete(&Article{}).Error
+}
@@ -96,3 +96,35 @@ func TestDeleteArticlesByPageID(t *testing.T) {
 		t.Errorf("expected 0 articles associated with page ID %d, but got %d", page.ID, count)
 	}
 }
+
+func TestCountArticlesByPageID(t *testing.T) {
+	// create an in-memory SQLite database for testing
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// create necessary tables for testing
+	err = db.AutoMigrate(&Article{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// create some test articles on two pages
+	for i := 0; i < 3; i++ {
+		if err := saveArticle(db, &Article{Title: "Test Article", PageID: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := saveArticle(db, &Article{Title: "Test Article", PageID: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	var count int64
+	if err := countArticlesByPageID(db, 1, &count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("expected 3 articles on page 1, but got %d", count)
+	}
+}
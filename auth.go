@@ -0,0 +1,695 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Scopes of API keys. The admin scope includes the others and gives access
+// to every List.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeAdmin = "admin"
+)
+
+// accessOwner is the access to a List that only its owner and admins have.
+const accessOwner = "owner"
+
+// apiKeyPrefix starts every API key, so that leaked keys are easy to find.
+const apiKeyPrefix = "kvl_"
+
+// authRequired makes every route of the HTTP API, except the ones the OpenAPI
+// document marks as public, require an API key.
+var authRequired = true
+
+var (
+	errUnauthorized = errors.New("missing or invalid API key or token")
+	errForbidden    = errors.New("forbidden")
+)
+
+// hashAPIKey hashes an API key for storage. Keys are random, so a plain
+// SHA-256 is enough to keep them safe.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseScopes checks the comma separated scopes of an API key.
+func parseScopes(s string) ([]string, error) {
+	var scopes []string
+	for _, scope := range strings.Split(s, ",") {
+		scope = strings.TrimSpace(scope)
+		switch scope {
+		case ScopeRead, ScopeWrite, ScopeAdmin:
+			scopes = append(scopes, scope)
+		default:
+			return nil, fmt.Errorf("unknown scope %q, expected read, write or admin", scope)
+		}
+	}
+	return scopes, nil
+}
+
+// CreateAPIKey generates a new API key with the given scopes and stores its
+// hash. The key itself is only returned here.
+func createAPIKey(db *gorm.DB, name string, scopes []string, apiKey *APIKey) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	*apiKey = APIKey{
+		Name:   name,
+		Prefix: key[:len(apiKeyPrefix)+6],
+		Hash:   hashAPIKey(key),
+		Scopes: strings.Join(scopes, ","),
+	}
+	if err := db.Create(apiKey).Error; err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+func getAPIKeyByHash(db *gorm.DB, hash string, apiKey *APIKey) error {
+	return db.Where("hash = ?", hash).First(apiKey).Error
+}
+
+func getAPIKeyByID(db *gorm.DB, id uint, apiKey *APIKey) error {
+	return db.First(apiKey, id).Error
+}
+
+// GetAPIKeyForUpdate gets the APIKey and locks it until the transaction ends.
+func getAPIKeyForUpdate(db *gorm.DB, id uint, apiKey *APIKey) error {
+	return db.Clauses(clause.Locking{Strength: "UPDATE"}).First(apiKey, id).Error
+}
+
+func getAPIKeys(db *gorm.DB, apiKeys *[]APIKey) error {
+	return db.Order("id").Find(apiKeys).Error
+}
+
+// RevokeAPIKey makes the API key unusable. Its grants are kept, so that the
+// key can be told apart from deleted ones in audits.
+func revokeAPIKey(db *gorm.DB, id uint) error {
+	res := db.Model(&APIKey{}).Where("id = ? AND revoked_at IS NULL", id).Update("revoked_at", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("API key %d not found or already revoked", id)
+	}
+	return nil
+}
+
+func getListGrant(db *gorm.DB, listID uint, apiKeyID uint, grant *ListGrant) error {
+	return db.Where("list_id = ? AND api_key_id = ?", listID, apiKeyID).First(grant).Error
+}
+
+func getListGrants(db *gorm.DB, listID uint, grants *[]ListGrant) error {
+	return db.Where("list_id = ?", listID).Order("api_key_id").Find(grants).Error
+}
+
+// SaveListGrant creates the grant, or changes the access of an existing one.
+func saveListGrant(db *gorm.DB, grant *ListGrant) error {
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "list_id"}, {Name: "api_key_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"access", "updated_at"}),
+	}).Create(grant).Error
+}
+
+func deleteListGrant(db *gorm.DB, listID uint, apiKeyID uint) (int64, error) {
+	res := db.Where("list_id = ? AND api_key_id = ?", listID, apiKeyID).Delete(&ListGrant{})
+	return res.RowsAffected, res.Error
+}
+
+// claimList creates the List owned by the API key, if it does not exist yet.
+func claimList(db *gorm.DB, listID uint, apiKeyID uint) error {
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&List{ID: listID, OwnerKeyID: apiKeyID}).Error
+}
+
+// canAccessList tells if the API key can read, write or own the List. Owners
+// can do anything with their Lists, and other keys need a grant. Lists that
+// do not exist are open to no key, until one creates and owns them.
+func canAccessList(db *gorm.DB, keyID uint, listID uint, access string) (bool, error) {
+	var list List
+	if err := getListByID(db, listID, &list); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	if list.OwnerKeyID == keyID {
+		return true, nil
+	}
+	if access == accessOwner {
+		return false, nil
+	}
+
+	var grant ListGrant
+	if err := getListGrant(db, listID, keyID, &grant); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return grant.Access == ScopeWrite || access == ScopeRead, nil
+}
+
+// principal is who a request is made by: an API key, or the subject of a
+// JWT.
+type principal struct {
+	// Subject names the principal: the ID of the API key or the subject of
+	// the JWT
+	Subject string
+	Scopes  []string
+	// Key is the API key of the principal, nil for a JWT that is not bound
+	// to one. The Lists the key owns or was granted are open to it.
+	Key *APIKey
+	// Lists are the IDs of the Lists a JWT opens, with the access of its
+	// scopes
+	Lists map[uint]bool
+}
+
+func apiKeyPrincipal(key *APIKey) *principal {
+	return &principal{Subject: fmt.Sprintf("API key %d", key.ID), Scopes: strings.Split(key.Scopes, ","), Key: key}
+}
+
+// hasScope tells if the principal has the scope, or the admin scope.
+func (p *principal) hasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// canAccessList tells if the principal can read, write or own the List.
+// Admins can access every List.
+func (p *principal) canAccessList(db *gorm.DB, listID uint, access string) (bool, error) {
+	if p.hasScope(ScopeAdmin) {
+		return true, nil
+	}
+	if access != accessOwner && p.Lists[listID] {
+		return true, nil
+	}
+	if p.Key == nil {
+		return false, nil
+	}
+	return canAccessList(db, p.Key.ID, listID, access)
+}
+
+// recheckListAccess checks again that the principal can access the List,
+// for operations that outlive the check of their request: the API key may
+// have been revoked since, or the List may have been created by another key.
+func recheckListAccess(p *principal, listID uint, access string) (bool, error) {
+	if p.Key != nil {
+		var key APIKey
+		if err := getAPIKeyByID(db, p.Key.ID, &key); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return false, nil
+			}
+			return false, err
+		}
+		if key.RevokedAt != nil {
+			return false, nil
+		}
+	}
+	return p.canAccessList(db, listID, access)
+}
+
+// unclaimedList tells if the List does not exist yet, so that an operation
+// that creates it can leave it to be claimed by the API key of the
+// principal.
+func unclaimedList(p *principal, listID uint, creates bool) (bool, error) {
+	if !creates || p.Key == nil {
+		return false, nil
+	}
+	var list List
+	err := getListByID(db, listID, &list)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return true, nil
+	}
+	return false, err
+}
+
+// authorizeLists checks that the principal has the access to every List,
+// for the operations that act on many Lists at once. When creates is set,
+// Lists that do not exist yet are left to be claimed. A nil principal, when
+// authentication is off, can access every List.
+func authorizeLists(p *principal, listIDs []uint, access string, creates bool) error {
+	if p == nil {
+		return nil
+	}
+	var denied []string
+	for _, listID := range listIDs {
+		unclaimed, err := unclaimedList(p, listID, creates)
+		if err != nil {
+			return err
+		}
+		if unclaimed {
+			continue
+		}
+		allowed, err := p.canAccessList(db, listID, access)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			denied = append(denied, strconv.FormatUint(uint64(listID), 10))
+		}
+	}
+	if len(denied) > 0 {
+		return fmt.Errorf("%w: %s has no %s access to lists %s", errForbidden, p.Subject, access, strings.Join(denied, ", "))
+	}
+	return nil
+}
+
+// listRule tells which List an operation of the API acts on.
+type listRule struct {
+	// list finds the List of the request. ok is false when the request is
+	// not about one List, which takes the admin scope.
+	list func(r *http.Request) (listID uint, ok bool, err error)
+	// owner restricts the operation to the owner of the List
+	owner bool
+	// creates is set for operations that create the List if it is missing,
+	// which makes the API key the owner of the new List
+	creates bool
+	// self is set for operations that are open to the principal without a
+	// List: about the principal itself, or about many Lists that the
+	// handler checks one by one
+	self bool
+}
+
+func listFromPath(name string) func(r *http.Request) (uint, bool, error) {
+	return func(r *http.Request) (uint, bool, error) {
+		id, err := strconv.Atoi(mux.Vars(r)[name])
+		return uint(id), true, err
+	}
+}
+
+func listFromQuery(name string) func(r *http.Request) (uint, bool, error) {
+	return func(r *http.Request) (uint, bool, error) {
+		s := r.URL.Query().Get(name)
+		if s == "" {
+			return 0, false, nil
+		}
+		id, err := strconv.Atoi(s)
+		return uint(id), true, err
+	}
+}
+
+// listOfPage finds the List of the page in the query parameter. Missing
+// pages are left to the handlers.
+func listOfPage(name string) func(r *http.Request) (uint, bool, error) {
+	return func(r *http.Request) (uint, bool, error) {
+		id, err := strconv.Atoi(r.URL.Query().Get(name))
+		if err != nil {
+			return 0, false, err
+		}
+		var page Page
+		if err := getPageByID(db, uint(id), &page); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, false, err
+		}
+		return page.ListID, true, nil
+	}
+}
+
+// listOfWebhook finds the List of the webhook in the path. Missing webhooks
+// are left to the handlers.
+func listOfWebhook(name string) func(r *http.Request) (uint, bool, error) {
+	return func(r *http.Request) (uint, bool, error) {
+		id, err := strconv.Atoi(mux.Vars(r)[name])
+		if err != nil {
+			return 0, false, err
+		}
+		var hook Webhook
+		if err := getWebhookByID(db, uint(id), &hook); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, false, err
+		}
+		return hook.ListID, true, nil
+	}
+}
+
+func firstList(r *http.Request) (uint, bool, error) {
+	return FirstListKey, true, nil
+}
+
+// listRules maps the operationId of the operations that act on a List to
+// their rule. Operations without a rule take the admin scope.
+var listRules = map[string]listRule{
+	"getListV1":             {list: listFromPath("id")},
+	"updateListV1":          {list: listFromPath("id"), creates: true},
+	"deleteListV1":          {list: listFromPath("id")},
+	"appendItemV1":          {list: listFromPath("id"), creates: true},
+	"getPageV1":             {list: listFromPath("id")},
+	"replacePageV1":         {list: listFromPath("id")},
+	"listGrants":            {list: listFromPath("id"), owner: true},
+	"saveGrant":             {list: listFromPath("id"), owner: true},
+	"removeGrant":           {list: listFromPath("id"), owner: true},
+	"getHead":               {list: listFromQuery("list_id")},
+	"streamListEvents":      {list: listFromPath("id")},
+	"queryList":             {list: listFromPath("id")},
+	"listWebhooks":          {list: listFromPath("id")},
+	"registerWebhook":       {list: listFromPath("id")},
+	"getChanges":            {list: listFromQuery("list_id")},
+	"search":                {list: listFromQuery("list_id")},
+	"removeWebhook":         {list: listOfWebhook("id")},
+	"getWebhookDeliveryLog": {list: listOfWebhook("id")},
+	"retryWebhookDelivery":  {list: listOfWebhook("id")},
+	"getPage":               {list: listOfPage("page_id")},
+	"set":                   {list: firstList},
+	"update":                {list: listOfPage("page_id")},
+	"deletePage":            {list: listFromQuery("list_id")},
+	"getListV2":             {list: listFromPath("id")},
+	"getListItemsV2":        {list: listFromPath("id")},
+	"getListRangeV2":        {list: listFromPath("id")},
+	"mergeListsV2":          {self: true},
+	"getUsage":              {self: true},
+	"publishV1":             {self: true},
+	"getPublishJob":         {self: true},
+	"retryPublishJob":       {self: true},
+	"getGroup":              {self: true},
+	"saveGroup":             {self: true},
+	"removeGroup":           {self: true},
+}
+
+type principalContextKey struct{}
+
+// principalFromContext returns who made the request, or nil if the request
+// was not authenticated.
+func principalFromContext(ctx context.Context) *principal {
+	p, _ := ctx.Value(principalContextKey{}).(*principal)
+	return p
+}
+
+// requiredScope is the scope the OpenAPI document asks for the operation,
+// or "" for a public operation.
+func requiredScope(op *openAPIOperation) string {
+	for _, requirement := range op.Security {
+		for _, scopes := range requirement {
+			if len(scopes) > 0 {
+				return scopes[0]
+			}
+		}
+	}
+	return ""
+}
+
+// authenticate is a middleware that answers 401 to requests without a valid
+// API key or JWT in their Authorization header, unless the operation is
+// public.
+func authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		op := apiSpec.operation(r)
+		if !authRequired || (op != nil && requiredScope(op) == "") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		p, err := authenticateRequest(r)
+		if err != nil {
+			if errors.Is(err, errUnauthorized) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="key-value-list"`)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+			} else {
+				log.Printf("Error in authenticate: %v\n", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalContextKey{}, p)))
+	})
+}
+
+// authenticateRequest finds who made the request from its bearer token.
+func authenticateRequest(r *http.Request) (*principal, error) {
+	return authenticateHeader(r.Header.Get("Authorization"))
+}
+
+// authenticateHeader finds the principal of an Authorization header, for the
+// HTTP API and the gRPC metadata.
+func authenticateHeader(header string) (*principal, error) {
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil, errUnauthorized
+	}
+	return authenticateToken(strings.TrimPrefix(header, "Bearer "))
+}
+
+// authenticateToken finds the principal of a token, which is a JWT if JWT
+// keys are configured and the token looks like one, and an API key
+// otherwise.
+func authenticateToken(token string) (*principal, error) {
+	if len(jwtKeys) > 0 && strings.Count(token, ".") == 2 {
+		return jwtPrincipal(token)
+	}
+
+	var key APIKey
+	if err := getAPIKeyByHash(db, hashAPIKey(token), &key); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errUnauthorized
+		}
+		return nil, err
+	}
+	if key.RevokedAt != nil {
+		return nil, errUnauthorized
+	}
+	return apiKeyPrincipal(&key), nil
+}
+
+// authorize is a middleware that answers 403 to requests whose principal lacks
+// the scope of the operation or access to its List. It runs after
+// validateRequest, so the parameters it reads are well formed.
+func authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := principalFromContext(r.Context())
+		op := apiSpec.operation(r)
+		if p == nil || op == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err := authorizeRequest(p, op, r); err != nil {
+			writeAuthorizeError(w, "authorize", err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// claimLists is a middleware that makes the API key the owner of the List
+// an operation creates, if it does not exist yet. It runs after
+// limitRequests, so requests that are turned away do not create Lists.
+func claimLists(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := principalFromContext(r.Context())
+		op := apiSpec.operation(r)
+		if p == nil || op == nil || p.Key == nil || !listRules[op.OperationID].creates {
+			next.ServeHTTP(w, r)
+			return
+		}
+		listID, ok, err := listRules[op.OperationID].list(r)
+		if err == nil && ok {
+			// Check the access again, the List may have been created since
+			err = authorizeListAccess(p, listID, requiredScope(op), true)
+		}
+		if err != nil {
+			writeAuthorizeError(w, "claimLists", err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeAuthorizeError(w http.ResponseWriter, name string, err error) {
+	if errors.Is(err, errForbidden) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+	} else if strings.Contains(err.Error(), "quota exceeded") {
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	} else {
+		log.Printf("Error in %s: %v\n", name, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func authorizeRequest(p *principal, op *openAPIOperation, r *http.Request) error {
+	scope := requiredScope(op)
+	if !p.hasScope(scope) {
+		return fmt.Errorf("%w: %s lacks the %s scope", errForbidden, p.Subject, scope)
+	}
+
+	rule, ok := listRules[op.OperationID]
+	if !ok {
+		if !p.hasScope(ScopeAdmin) {
+			return fmt.Errorf("%w: %s lacks the admin scope", errForbidden, p.Subject)
+		}
+		return nil
+	}
+	if rule.self {
+		return nil
+	}
+	listID, ok, err := rule.list(r)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if !p.hasScope(ScopeAdmin) {
+			return fmt.Errorf("%w: a list is required without the admin scope", errForbidden)
+		}
+		return nil
+	}
+
+	access := scope
+	if rule.owner {
+		access = accessOwner
+	}
+	// Lists that do not exist yet are claimed by claimLists
+	unclaimed, err := unclaimedList(p, listID, rule.creates)
+	if err != nil || unclaimed {
+		return err
+	}
+	return authorizeListAccess(p, listID, access, false)
+}
+
+// authorizeListAccess checks that the principal has the access to the List.
+// When creates is set, a missing List is created first and owned by the API
+// key of the principal.
+func authorizeListAccess(p *principal, listID uint, access string, creates bool) error {
+	if creates && p.Key != nil {
+		if err := claimListWithinQuota(listID, p.Key.ID); err != nil {
+			return err
+		}
+	}
+	allowed, err := p.canAccessList(db, listID, access)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return fmt.Errorf("%w: %s has no %s access to list %d", errForbidden, p.Subject, access, listID)
+	}
+	return nil
+}
+
+// authorizeList checks that the principal has the scope and its access to
+// the List, for the gRPC and RESP servers, which have no OpenAPI operations.
+// A nil principal, when authentication is off, can access every List.
+func authorizeList(p *principal, listID uint, scope string, creates bool) error {
+	if p == nil {
+		return nil
+	}
+	if !p.hasScope(scope) {
+		return fmt.Errorf("%w: %s lacks the %s scope", errForbidden, p.Subject, scope)
+	}
+	return authorizeListAccess(p, listID, scope, creates)
+}
+
+func grantResponse(grant *ListGrant) map[string]interface{} {
+	return map[string]interface{}{
+		"list_id":    grant.ListID,
+		"key_id":     grant.APIKeyID,
+		"access":     grant.Access,
+		"created_at": grant.CreatedAt,
+		"updated_at": grant.UpdatedAt,
+	}
+}
+
+func listGrants(w http.ResponseWriter, r *http.Request) error {
+	listID, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+
+	var grants []ListGrant
+	if err := getListGrants(db, listID, &grants); err != nil {
+		return fmt.Errorf("error fetching grants: %v", err)
+	}
+	grantData := []map[string]interface{}{}
+	for i := range grants {
+		grantData = append(grantData, grantResponse(&grants[i]))
+	}
+	return writeJSON(w, http.StatusOK, map[string]interface{}{"grants": grantData})
+}
+
+// saveGrant gives an API key read or write access to a List.
+func saveGrant(w http.ResponseWriter, r *http.Request) error {
+	listID, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+	keyID, err := pathID(r, "key_id")
+	if err != nil {
+		return err
+	}
+	var req struct {
+		Access string `json:"access"`
+	}
+	body, err := readBody(w, r)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return fmt.Errorf("invalid request body: %v", err)
+	}
+	if req.Access != ScopeRead && req.Access != ScopeWrite {
+		return &ValidationError{Fields: []FieldError{{"access", "must be one of read, write"}}}
+	}
+
+	var list List
+	if err := getListByID(db, listID, &list); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("list not found")
+		}
+		return fmt.Errorf("error fetching list: %v", err)
+	}
+	var key APIKey
+	if err := getAPIKeyByID(db, keyID, &key); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("API key not found")
+		}
+		return fmt.Errorf("error fetching API key: %v", err)
+	}
+
+	grant := ListGrant{ListID: listID, APIKeyID: keyID, Access: req.Access}
+	if err := saveListGrant(db, &grant); err != nil {
+		return fmt.Errorf("error saving grant: %v", err)
+	}
+	if err := getListGrant(db, listID, keyID, &grant); err != nil {
+		return fmt.Errorf("error fetching grant: %v", err)
+	}
+	return writeJSON(w, http.StatusOK, grantResponse(&grant))
+}
+
+func removeGrant(w http.ResponseWriter, r *http.Request) error {
+	listID, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+	keyID, err := pathID(r, "key_id")
+	if err != nil {
+		return err
+	}
+
+	deleted, err := deleteListGrant(db, listID, keyID)
+	if err != nil {
+		return fmt.Errorf("error deleting grant: %v", err)
+	}
+	if deleted == 0 {
+		return fmt.Errorf("grant not found")
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
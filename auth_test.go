@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useAuth turns authentication on for the duration of a test.
+func useAuth(t *testing.T) {
+	t.Helper()
+	authRequired = true
+	t.Cleanup(func() { authRequired = false })
+}
+
+func mintAPIKey(t *testing.T, name string, scopes ...string) (string, APIKey) {
+	t.Helper()
+	var apiKey APIKey
+	key, err := createAPIKey(db, name, scopes, &apiKey)
+	if err != nil {
+		t.Fatalf("Error creating API key: %v", err)
+	}
+	return key, apiKey
+}
+
+func TestOperationsDeclareSecurity(t *testing.T) {
+	for path, operations := range apiSpec.Paths {
+		for method, op := range operations {
+			if op.Security == nil {
+				t.Errorf("%s %s does not declare its security", method, path)
+				continue
+			}
+			scope := requiredScope(op)
+			if scope == "" {
+				continue
+			}
+			if _, err := parseScopes(scope); err != nil {
+				t.Errorf("%s %s: %v", method, path, err)
+			}
+			if _, ok := listRules[op.OperationID]; !ok && scope != ScopeAdmin {
+				t.Errorf("%s %s takes the %s scope but has no list rule", method, path, scope)
+			}
+		}
+	}
+	for operationID := range listRules {
+		found := false
+		for _, operations := range apiSpec.Paths {
+			for _, op := range operations {
+				found = found || op.OperationID == operationID
+			}
+		}
+		if !found {
+			t.Errorf("List rule for unknown operation %s", operationID)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	useTestDB(t)
+	useAuth(t)
+	router := newRouter()
+
+	key, _ := mintAPIKey(t, "admin", ScopeAdmin)
+	revoked, revokedKey := mintAPIKey(t, "revoked", ScopeAdmin)
+	if err := revokeAPIKey(db, revokedKey.ID); err != nil {
+		t.Fatalf("Error revoking API key: %v", err)
+	}
+
+	testCases := []struct {
+		name          string
+		url           string
+		authorization string
+		expectedCode  int
+	}{
+		{"Public route", "/openapi.json", "", http.StatusOK},
+		{"Missing key", "/v1/lists/1", "", http.StatusUnauthorized},
+		{"Not a bearer token", "/v1/lists/1", "Basic " + key, http.StatusUnauthorized},
+		{"Unknown key", "/v1/lists/1", "Bearer kvl_unknown", http.StatusUnauthorized},
+		{"Revoked key", "/v1/lists/1", "Bearer " + revoked, http.StatusUnauthorized},
+		{"Valid key", "/v1/lists/1", "Bearer " + key, http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.url, nil)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != tc.expectedCode {
+				t.Errorf("Expected status code %d but got %d", tc.expectedCode, rec.Code)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("Expected a WWW-Authenticate header")
+			}
+		})
+	}
+
+	var stored APIKey
+	if err := getAPIKeyByID(db, revokedKey.ID, &stored); err != nil {
+		t.Fatalf("Error fetching API key: %v", err)
+	}
+	if stored.Hash == revoked || strings.Contains(stored.Prefix+stored.Hash, revoked) {
+		t.Errorf("Expected only the hash of the key to be stored")
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	useTestDB(t)
+	useAuth(t)
+	router := newRouter()
+
+	admin, _ := mintAPIKey(t, "admin", ScopeAdmin)
+	owner, ownerKey := mintAPIKey(t, "owner", ScopeRead, ScopeWrite)
+	other, otherKey := mintAPIKey(t, "other", ScopeRead, ScopeWrite)
+	reader, readerKey := mintAPIKey(t, "reader", ScopeRead)
+
+	do := func(key, method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	expect := func(rec *httptest.ResponseRecorder, code int, what string) {
+		t.Helper()
+		if rec.Code != code {
+			t.Errorf("%s: expected status code %d but got %d: %s", what, code, rec.Code, rec.Body.String())
+		}
+	}
+	article := `{"title": "Title", "author": "Author", "content": "Content"}`
+
+	// Appending to a missing list creates it, owned by the key
+	rec := do(owner, "POST", "/v1/lists/5/items", article)
+	expect(rec, http.StatusCreated, "owner appends")
+	var list List
+	if err := getListByID(db, 5, &list); err != nil || list.OwnerKeyID != ownerKey.ID {
+		t.Fatalf("Expected list 5 to be owned by key %d, got %+v (%v)", ownerKey.ID, list, err)
+	}
+	var created struct {
+		PageID uint `json:"page_id"`
+	}
+	json.NewDecoder(rec.Body).Decode(&created)
+
+	// Other keys cannot touch the list without a grant
+	expect(do(other, "GET", "/v1/lists/5", ""), http.StatusForbidden, "other reads")
+	expect(do(other, "POST", "/v1/lists/5/items", article), http.StatusForbidden, "other appends")
+	expect(do(other, "DELETE", "/page/delete?list_id=5", ""), http.StatusForbidden, "other deletes")
+	expect(do(other, "GET", fmt.Sprintf("/page/get?page_id=%d", created.PageID), ""), http.StatusForbidden, "other reads a page")
+	expect(do(other, "GET", "/changes?list_id=5", ""), http.StatusForbidden, "other reads changes")
+	expect(do(other, "PUT", fmt.Sprintf("/v1/lists/5/grants/%d", otherKey.ID), `{"access": "write"}`), http.StatusForbidden, "other grants itself")
+
+	// Unowned lists and routes that are not about one list are for admins
+	expect(do(owner, "GET", "/list/get?list_id=1", ""), http.StatusForbidden, "owner reads the first list")
+	expect(do(owner, "GET", "/changes", ""), http.StatusForbidden, "owner reads every change")
+	expect(do(owner, "GET", "/metrics/cache", ""), http.StatusForbidden, "owner reads metrics")
+	expect(do(admin, "GET", "/changes", ""), http.StatusOK, "admin reads every change")
+	expect(do(admin, "GET", "/metrics/cache", ""), http.StatusOK, "admin reads metrics")
+	expect(do(admin, "GET", "/v1/lists/5", ""), http.StatusOK, "admin reads")
+
+	// A read grant lets the other key read but not write
+	expect(do(owner, "PUT", fmt.Sprintf("/v1/lists/5/grants/%d", otherKey.ID), `{"access": "read"}`), http.StatusOK, "owner grants read")
+	expect(do(other, "GET", "/v1/lists/5", ""), http.StatusOK, "other reads with a grant")
+	expect(do(other, "GET", fmt.Sprintf("/page/get?page_id=%d", created.PageID), ""), http.StatusOK, "other reads a page with a grant")
+	expect(do(other, "GET", "/changes?list_id=5", ""), http.StatusOK, "other reads changes with a grant")
+	expect(do(other, "POST", "/v1/lists/5/items", article), http.StatusForbidden, "other appends with a read grant")
+	expect(do(other, "GET", "/v1/lists/5/grants", ""), http.StatusForbidden, "other lists grants")
+
+	// A write grant lets it write, as long as the key has the write scope
+	expect(do(owner, "PUT", fmt.Sprintf("/v1/lists/5/grants/%d", otherKey.ID), `{"access": "write"}`), http.StatusOK, "owner grants write")
+	expect(do(other, "POST", "/v1/lists/5/items", article), http.StatusCreated, "other appends with a write grant")
+	expect(do(owner, "PUT", fmt.Sprintf("/v1/lists/5/grants/%d", readerKey.ID), `{"access": "write"}`), http.StatusOK, "owner grants write to a reader")
+	expect(do(reader, "POST", "/v1/lists/5/items", article), http.StatusForbidden, "reader appends")
+	expect(do(reader, "GET", "/v1/lists/5", ""), http.StatusOK, "reader reads")
+
+	rec = do(owner, "GET", "/v1/lists/5/grants", "")
+	expect(rec, http.StatusOK, "owner lists grants")
+	var grants struct {
+		Grants []struct {
+			KeyID  uint   `json:"key_id"`
+			Access string `json:"access"`
+		} `json:"grants"`
+	}
+	json.NewDecoder(rec.Body).Decode(&grants)
+	if len(grants.Grants) != 2 || grants.Grants[0].KeyID != otherKey.ID || grants.Grants[0].Access != ScopeWrite {
+		t.Errorf("Unexpected grants %+v", grants)
+	}
+
+	// Removing the grant takes the access back
+	expect(do(owner, "DELETE", fmt.Sprintf("/v1/lists/5/grants/%d", otherKey.ID), ""), http.StatusNoContent, "owner removes the grant")
+	expect(do(owner, "DELETE", fmt.Sprintf("/v1/lists/5/grants/%d", otherKey.ID), ""), http.StatusNotFound, "owner removes the grant again")
+	expect(do(other, "GET", "/v1/lists/5", ""), http.StatusForbidden, "other reads after the grant was removed")
+	expect(do(owner, "PUT", "/v1/lists/5/grants/999", `{"access": "read"}`), http.StatusNotFound, "owner grants a missing key")
+
+	// Deleting the list keeps its owner
+	expect(do(owner, "DELETE", "/v1/lists/5", ""), http.StatusNoContent, "owner deletes")
+	expect(do(other, "POST", "/v1/lists/5/items", article), http.StatusForbidden, "other appends after the delete")
+}
+
+func TestAuthorizeMissingLists(t *testing.T) {
+	useTestDB(t)
+	useAuth(t)
+	router := newRouter()
+
+	admin, _ := mintAPIKey(t, "admin", ScopeAdmin)
+	key, _ := mintAPIKey(t, "key", ScopeRead, ScopeWrite)
+
+	do := func(key, method, url, body string) int {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Only operations that create a list can name one nobody owns yet
+	testCases := []struct {
+		method string
+		url    string
+		body   string
+	}{
+		{"POST", "/lists/9/webhooks", `{"url": "http://example.com/hook"}`},
+		{"GET", "/lists/9/events", ""},
+		{"GET", "/changes?list_id=9", ""},
+		{"GET", "/search?q=title&list_id=9", ""},
+		{"GET", "/v1/lists/9", ""},
+	}
+	for _, tc := range testCases {
+		if code := do(key, tc.method, tc.url, tc.body); code != http.StatusForbidden {
+			t.Errorf("%s %s: expected status code %d but got %d", tc.method, tc.url, http.StatusForbidden, code)
+		}
+	}
+	if code := do(admin, "POST", "/lists/9/webhooks", `{"url": "http://example.com/hook"}`); code != http.StatusCreated {
+		t.Errorf("Expected an admin to register a webhook, got %d", code)
+	}
+}
+
+func TestAccessCheckedAgain(t *testing.T) {
+	useTestDB(t)
+	useAuth(t)
+	allowTestWebhooks(t)
+	savedHeartbeat := sseHeartbeat
+	sseHeartbeat = 10 * time.Millisecond
+	t.Cleanup(func() { sseHeartbeat = savedHeartbeat })
+
+	router := newRouter()
+	server := httptest.NewServer(router)
+	defer server.Close()
+	owner, _ := mintAPIKey(t, "owner", ScopeRead, ScopeWrite)
+	other, otherKey := mintAPIKey(t, "other", ScopeRead, ScopeWrite)
+
+	do := func(key, method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	article := `{"title": "Title", "author": "Author", "content": "Content"}`
+	grant := fmt.Sprintf("/v1/lists/5/grants/%d", otherKey.ID)
+
+	do(owner, "POST", "/v1/lists/5/items", article)
+	if rec := do(owner, "PUT", grant, `{"access": "write"}`); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d but got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	receiver := &webhookReceiver{}
+	hookServer := httptest.NewServer(receiver)
+	defer hookServer.Close()
+	rec := do(other, "POST", "/lists/5/webhooks", fmt.Sprintf(`{"url": %q}`, hookServer.URL))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d but got %d: %s", http.StatusCreated, rec.Code, rec.Body.String())
+	}
+
+	req, _ := http.NewRequest("GET", server.URL+"/lists/5/events", nil)
+	req.Header.Set("Authorization", "Bearer "+other)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the stream to open, got %v (%v)", res, err)
+	}
+	defer res.Body.Close()
+
+	// Once the grant is removed, the stream ends and deliveries stop
+	do(owner, "DELETE", grant, "")
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, res.Body)
+		done <- err
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expected the stream to end once the grant was removed")
+	}
+
+	do(owner, "POST", "/v1/lists/5/items", article)
+	if err := dispatchWebhooks(); err != nil {
+		t.Fatalf("Failed to dispatch webhooks: %v", err)
+	}
+	if len(receiver.requests) != 0 {
+		t.Errorf("Expected no delivery without access, got %d", len(receiver.requests))
+	}
+}
+
+func TestKeysCommand(t *testing.T) {
+	useTestDB(t)
+
+	var out bytes.Buffer
+	if err := runCommand([]string{"keys", "create", "-name", "ci", "-scopes", "read,write"}, &out); err != nil {
+		t.Fatalf("Error creating key: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	key := lines[len(lines)-1]
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		t.Fatalf("Expected the key on the last line, got %q", out.String())
+	}
+	var apiKey APIKey
+	if err := getAPIKeyByHash(db, hashAPIKey(key), &apiKey); err != nil {
+		t.Fatalf("Error fetching the created key: %v", err)
+	}
+	if p := apiKeyPrincipal(&apiKey); apiKey.Name != "ci" || !p.hasScope(ScopeWrite) || p.hasScope(ScopeAdmin) {
+		t.Errorf("Unexpected key %+v", apiKey)
+	}
+
+	out.Reset()
+	if err := runCommand([]string{"keys", "list"}, &out); err != nil {
+		t.Fatalf("Error listing keys: %v", err)
+	}
+	if !strings.Contains(out.String(), apiKey.Prefix) || strings.Contains(out.String(), key) {
+		t.Errorf("Expected the list to show the prefix but not the key, got %q", out.String())
+	}
+
+	out.Reset()
+	if err := runCommand([]string{"keys", "revoke", fmt.Sprint(apiKey.ID)}, &out); err != nil {
+		t.Fatalf("Error revoking key: %v", err)
+	}
+	if err := runCommand([]string{"keys", "revoke", fmt.Sprint(apiKey.ID)}, &out); err == nil {
+		t.Errorf("Expected revoking a revoked key to fail")
+	}
+	if err := getAPIKeyByID(db, apiKey.ID, &apiKey); err != nil || apiKey.RevokedAt == nil {
+		t.Errorf("Expected the key to be revoked, got %+v (%v)", apiKey, err)
+	}
+
+	for _, args := range [][]string{{"keys", "create", "-scopes", "root"}, {"keys"}, {"lists"}, {"keys", "revoke", "x"}} {
+		if err := runCommand(args, &out); err == nil {
+			t.Errorf("Expected %v to fail", args)
+		}
+	}
+}
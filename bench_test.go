@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// countQueries counts every statement run against the global database.
+func countQueries(b *testing.B) *int64 {
+	b.Helper()
+
+	var queries int64
+	count := func(*gorm.DB) { atomic.AddInt64(&queries, 1) }
+	callbacks := db.Callback()
+	for name, err := range map[string]error{
+		"create": callbacks.Create().After("gorm:create").Register("bench:count_create", count),
+		"query":  callbacks.Query().After("gorm:query").Register("bench:count_query", count),
+		"update": callbacks.Update().After("gorm:update").Register("bench:count_update", count),
+		"delete": callbacks.Delete().After("gorm:delete").Register("bench:count_delete", count),
+		"row":    callbacks.Row().After("gorm:row").Register("bench:count_row", count),
+		"raw":    callbacks.Raw().After("gorm:raw").Register("bench:count_raw", count),
+	} {
+		if err != nil {
+			b.Fatalf("Failed to register %s callback: %v", name, err)
+		}
+	}
+	return &queries
+}
+
+// reportQueries resets the timer and counter before the measured loop and
+// reports queries per operation after it.
+func reportQueries(b *testing.B, queries *int64, run func()) {
+	atomic.StoreInt64(queries, 0)
+	b.ResetTimer()
+	run()
+	b.StopTimer()
+	b.ReportMetric(float64(atomic.LoadInt64(queries))/float64(b.N), "queries/op")
+}
+
+// silenceLog drops the per-append log lines for the duration of a benchmark.
+func silenceLog(b *testing.B) {
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+}
+
+// legacyAddArticleToPage is the append path before it inserted only the new
+// article: it loads the whole last page and saves every article again.
+func legacyAddArticleToPage(newArticle Article) {
+	var list List
+	if err := getListByID(db, FirstListKey, &list); err != nil {
+		log.Fatal(err)
+	}
+	var page Page
+	var err error
+	if err = getLastPage(db, &page); err != nil {
+		if page, err = createNewPage(db, &list, nil); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err = preloadArticles(db, &page); err != nil {
+		log.Fatal(err)
+	}
+	if len(page.Articles) >= NumberOfArticleInOnePage {
+		lastPage := page
+		if page, err = createNewPage(db, &list, &lastPage); err != nil {
+			log.Fatal(err)
+		}
+	}
+	page.Articles = append(page.Articles, newArticle)
+	if err := savePage(db, &page); err != nil {
+		log.Fatal(err)
+	}
+	if err := incrementPageVersion(db, page.ID); err != nil {
+		log.Fatal(err)
+	}
+	if err := incrementListVersion(db, page.ListID); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func BenchmarkGetPage(b *testing.B) {
+	setup := func(b *testing.B) *int64 {
+		useTestDB(b)
+		silenceLog(b)
+		for i := 0; i < NumberOfArticleInOnePage; i++ {
+			addArticleToPage(Article{Title: fmt.Sprintf("Article %d", i), Author: "Author", Content: "Content"})
+		}
+		return countQueries(b)
+	}
+
+	b.Run("before", func(b *testing.B) {
+		queries := setup(b)
+		reportQueries(b, queries, func() {
+			for i := 0; i < b.N; i++ {
+				var page Page
+				if err := getPageByID(db, FirstPageKey, &page); err != nil {
+					b.Fatal(err)
+				}
+				if err := getArticlesByPageID(db, FirstPageKey, &page.Articles); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+
+	b.Run("after", func(b *testing.B) {
+		queries := setup(b)
+		reportQueries(b, queries, func() {
+			for i := 0; i < b.N; i++ {
+				var page Page
+				if err := getPageWithArticles(db, FirstPageKey, &page); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+}
+
+func BenchmarkAddArticleToPage(b *testing.B) {
+	article := Article{Title: "Article", Author: "Author", Content: "Content"}
+
+	b.Run("before", func(b *testing.B) {
+		useTestDB(b)
+		silenceLog(b)
+		queries := countQueries(b)
+		reportQueries(b, queries, func() {
+			for i := 0; i < b.N; i++ {
+				legacyAddArticleToPage(article)
+			}
+		})
+	})
+
+	b.Run("after", func(b *testing.B) {
+		useTestDB(b)
+		silenceLog(b)
+		queries := countQueries(b)
+		reportQueries(b, queries, func() {
+			for i := 0; i < b.N; i++ {
+				addArticleToPage(article)
+			}
+		})
+	})
+}
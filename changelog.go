@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// EventPageCreate is recorded in the change log when a page is added to a list.
+const EventPageCreate = "page_create"
+
+const (
+	defaultChangesLimit = 100
+	maxChangesLimit     = 1000
+)
+
+// changeLogLockID is the Postgres advisory lock that keeps readers of the
+// change log from getting ahead of its writers.
+const changeLogLockID = 4152
+
+// lockChangeLog marks the transaction as a writer to the change log until it
+// commits. Writers share the lock, so they do not wait for each other; it only
+// lets readers find the changes that have all committed (see
+// getChangeHorizon). SQLite already allows a single writer at a time.
+func lockChangeLog(tx *gorm.DB) error {
+	if tx.Dialector.Name() != "postgres" {
+		return nil
+	}
+	return tx.Exec("SELECT pg_advisory_xact_lock_shared(?)", changeLogLockID).Error
+}
+
+// GetChangeHorizon gets the sequence number up to which every change has
+// either committed or rolled back. Postgres hands out sequence numbers when
+// rows are inserted, not when they are committed, so a reader that went past
+// the horizon could see change N+1 before change N commits and skip N for
+// good. Finding the horizon waits for the writers of the moment to finish and
+// holds back new ones until then. It must not be called from a transaction
+// that records changes.
+func getChangeHorizon(db *gorm.DB, seq *uint64) error {
+	if db.Dialector.Name() != "postgres" {
+		return getLastChangeSeq(db, seq)
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", changeLogLockID).Error; err != nil {
+			return err
+		}
+		return getLastChangeSeq(tx, seq)
+	})
+}
+
+// RecordChange adds the change to the change log. It must be called with the
+// transaction that makes the change. data is stored as JSON.
+func recordChange(tx *gorm.DB, change *Change, data interface{}) error {
+	if data != nil {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		change.Data = string(b)
+	}
+	if err := lockChangeLog(tx); err != nil {
+		return err
+	}
+	return tx.Table("changes").Create(change).Error
+}
+
+// GetChangesSince gets up to limit changes with a sequence number greater than
+// since, in order, up to the horizon of the change log. A listID of 0 matches
+// every list.
+func getChangesSince(db *gorm.DB, since uint64, listID uint, limit int, changes *[]Change) error {
+	var horizon uint64
+	if err := getChangeHorizon(db, &horizon); err != nil {
+		return err
+	}
+	return getChangesBetween(db, since, horizon, listID, limit, changes)
+}
+
+// GetChangesBetween gets up to limit changes with a sequence number greater
+// than since and at most until, in order. A listID of 0 matches every list.
+func getChangesBetween(db *gorm.DB, since, until uint64, listID uint, limit int, changes *[]Change) error {
+	query := db.Table("changes").Where("seq > ? AND seq <= ?", since, until)
+	if listID != 0 {
+		query = query.Where("list_id = ?", listID)
+	}
+	return query.Order("seq").Limit(limit).Find(changes).Error
+}
+
+// GetLastChangeSeq gets the sequence number of the last change, or 0 if the
+// change log is empty.
+func getLastChangeSeq(db *gorm.DB, seq *uint64) error {
+	return db.Table("changes").Select("COALESCE(MAX(seq), 0)").Row().Scan(seq)
+}
+
+// changeResponse is a Change as sent to clients.
+type changeResponse struct {
+	Seq    uint64          `json:"seq"`
+	Time   time.Time       `json:"time"`
+	Type   string          `json:"type"`
+	ListID uint            `json:"list_id"`
+	PageID uint            `json:"page_id,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty"`
+}
+
+func toChangeResponse(change Change) changeResponse {
+	res := changeResponse{
+		Seq:    change.Seq,
+		Time:   change.CreatedAt,
+		Type:   change.Type,
+		ListID: change.ListID,
+		PageID: change.PageID,
+	}
+	if change.Data != "" {
+		res.Data = json.RawMessage(change.Data)
+	}
+	return res
+}
+
+func getChanges(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+
+	var since uint64
+	if sinceStr := query.Get("since"); sinceStr != "" {
+		var err error
+		since, err = strconv.ParseUint(sinceStr, 10, 64)
+		if err != nil {
+			return fmt.Errorf("since parameter is not a valid integer")
+		}
+	}
+
+	var listID int
+	if idStr := query.Get("list_id"); idStr != "" {
+		var err error
+		listID, err = strconv.Atoi(idStr)
+		if err != nil || listID < 0 {
+			return fmt.Errorf("list_id parameter is not a valid integer")
+		}
+	}
+
+	limit := defaultChangesLimit
+	if limitStr := query.Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			return fmt.Errorf("limit parameter is not a valid integer")
+		}
+		if limit > maxChangesLimit {
+			limit = maxChangesLimit
+		}
+	}
+
+	var changes []Change
+	if err := getChangesSince(db, since, uint(listID), limit, &changes); err != nil {
+		return fmt.Errorf("error fetching changes: %v", err)
+	}
+
+	// Clients pass next_since back to read the changes that follow
+	res := struct {
+		Changes   []changeResponse `json:"changes"`
+		NextSince uint64           `json:"next_since"`
+	}{Changes: []changeResponse{}, NextSince: since}
+	for _, change := range changes {
+		res.Changes = append(res.Changes, toChangeResponse(change))
+		res.NextSince = change.Seq
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		return fmt.Errorf("error encoding JSON response: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestChangeLogRecordsMutations(t *testing.T) {
+	useTestDB(t)
+
+	for i := 0; i < NumberOfArticleInOnePage+1; i++ {
+		if _, err := appendArticle(2, Article{Title: "Article"}); err != nil {
+			t.Fatalf("Failed to append article: %v", err)
+		}
+	}
+	var head List
+	getListByID(db, 2, &head)
+	if _, err := updatePage(head.NextPageID, []Article{{Title: "Updated"}}, nil); err != nil {
+		t.Fatalf("Failed to update page: %v", err)
+	}
+	if _, err := deleteList(2, nil); err != nil {
+		t.Fatalf("Failed to delete list: %v", err)
+	}
+
+	var changes []Change
+	if err := getChangesSince(db, 0, 2, maxChangesLimit, &changes); err != nil {
+		t.Fatalf("Failed to get changes: %v", err)
+	}
+
+	expected := []string{EventPageCreate, EventHeadSwap}
+	for i := 0; i < NumberOfArticleInOnePage; i++ {
+		expected = append(expected, EventAppend)
+	}
+	expected = append(expected, EventPageCreate, EventAppend, EventPageUpdate, EventDelete, EventHeadSwap)
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected %d changes but got %d: %+v", len(expected), len(changes), changes)
+	}
+	for i, change := range changes {
+		if change.Type != expected[i] {
+			t.Errorf("Expected change %d to be %s but got %s", i, expected[i], change.Type)
+		}
+		if i > 0 && change.Seq <= changes[i-1].Seq {
+			t.Errorf("Expected increasing sequence numbers, got %d after %d", change.Seq, changes[i-1].Seq)
+		}
+	}
+
+	var data struct {
+		ArticleID uint                   `json:"article_id"`
+		Article   map[string]interface{} `json:"article"`
+	}
+	if err := json.Unmarshal([]byte(changes[2].Data), &data); err != nil {
+		t.Fatalf("Failed to decode change data: %v", err)
+	}
+	if data.ArticleID == 0 || data.Article["title"] != "Article" {
+		t.Errorf("Unexpected append data %s", changes[2].Data)
+	}
+}
+
+func TestChangeLogRolledBack(t *testing.T) {
+	useTestDB(t)
+
+	appendArticle(2, Article{Title: "Article"})
+	var head List
+	getListByID(db, 2, &head)
+
+	// A vetoed update leaves no trace in the change log
+	_, err := updatePage(head.NextPageID, []Article{{Title: "Updated"}}, func(page *Page) error {
+		return errVersionConflict
+	})
+	if err == nil {
+		t.Fatal("Expected the update to fail")
+	}
+
+	var changes []Change
+	getChangesSince(db, 0, 0, maxChangesLimit, &changes)
+	for _, change := range changes {
+		if change.Type == EventPageUpdate {
+			t.Errorf("Expected no page update in the change log, got %+v", change)
+		}
+	}
+}
+
+func TestHandleGetChanges(t *testing.T) {
+	useTestDB(t)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/changes", handleGetChanges).Methods("GET")
+
+	for i := 0; i < 3; i++ {
+		appendArticle(5, Article{Title: "Article"})
+	}
+	appendArticle(6, Article{Title: "Other list"})
+
+	testCases := []struct {
+		name          string
+		url           string
+		expectedCode  int
+		expectedSeqs  []uint64
+		expectedSince uint64
+	}{
+		{"all", "/changes", http.StatusOK, []uint64{1, 2, 3, 4, 5, 6, 7, 8}, 8},
+		{"since", "/changes?since=5", http.StatusOK, []uint64{6, 7, 8}, 8},
+		{"limit", "/changes?since=1&limit=2", http.StatusOK, []uint64{2, 3}, 3},
+		{"list", "/changes?list_id=6", http.StatusOK, []uint64{6, 7, 8}, 8},
+		{"caught up", "/changes?since=8", http.StatusOK, []uint64{}, 8},
+		{"invalid since", "/changes?since=abc", http.StatusBadRequest, nil, 0},
+		{"invalid limit", "/changes?limit=0", http.StatusBadRequest, nil, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.url, nil)
+			res := httptest.NewRecorder()
+			router.ServeHTTP(res, req)
+
+			if res.Code != tc.expectedCode {
+				t.Fatalf("Expected status code %d but got %d", tc.expectedCode, res.Code)
+			}
+			if tc.expectedCode != http.StatusOK {
+				return
+			}
+
+			var body struct {
+				Changes []struct {
+					Seq uint64 `json:"seq"`
+				} `json:"changes"`
+				NextSince uint64 `json:"next_since"`
+			}
+			if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if len(body.Changes) != len(tc.expectedSeqs) {
+				t.Fatalf("Expected %d changes but got %s", len(tc.expectedSeqs), res.Body.String())
+			}
+			for i, change := range body.Changes {
+				if change.Seq != tc.expectedSeqs[i] {
+					t.Errorf("Expected change %d to have seq %d but got %d", i, tc.expectedSeqs[i], change.Seq)
+				}
+			}
+			if body.NextSince != tc.expectedSince {
+				t.Errorf("Expected next_since %d but got %d", tc.expectedSince, body.NextSince)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// flights deduplicates concurrent page and list lookups.
+var flights flightGroup
+
+// flightCall is a lookup in progress, shared by every caller asking for the
+// same key while it runs.
+type flightCall struct {
+	wg   sync.WaitGroup
+	val  interface{}
+	err  error
+	dups int
+	// panicked is what the call panicked with, passed on to every caller
+	panicked interface{}
+}
+
+// flightGroup coalesces identical in-flight calls, in the style of
+// golang.org/x/sync/singleflight.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// Do runs fn for the key, unless a call for the same key is already running,
+// in which case it waits for that call and returns its result instead. If fn
+// panics, every caller panics with the same value.
+func (g *flightGroup) do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if c, ok := g.calls[key]; ok {
+		c.dups++
+		g.mu.Unlock()
+		c.wg.Wait()
+		if c.panicked != nil {
+			panic(c.panicked)
+		}
+		return c.val, c.err
+	}
+	c := new(flightCall)
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	returned := false
+	defer func() {
+		if !returned {
+			// fn panicked, or its goroutine exited without a value
+			c.panicked = recover()
+			if c.panicked == nil {
+				c.err = fmt.Errorf("call for %s did not return", key)
+			}
+		}
+		c.wg.Done()
+
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+
+		if c.panicked != nil {
+			panic(c.panicked)
+		}
+	}()
+
+	c.val, c.err = fn()
+	returned = true
+	return c.val, c.err
+}
+
+// LoadPage reads the page and its articles from the database and caches
+// them. Concurrent loads of the same page share a single query.
+func loadPage(id uint, page *Page) error {
+	// Keying on the cache generation keeps callers arriving after a write
+	// from sharing a read that started before it
+	gen := cachedPages.generation()
+	v, err := flights.do(fmt.Sprintf("page:%d:%d", id, gen), func() (interface{}, error) {
+		var loaded Page
+		if err := getPageWithArticles(db, id, &loaded); err != nil {
+			return nil, err
+		}
+		cachedPages.add(&loaded, gen)
+		return &loaded, nil
+	})
+	if err != nil {
+		return err
+	}
+	copyPage(page, v.(*Page))
+	return nil
+}
+
+// LoadList reads the list from the database. Concurrent loads of the same
+// list share a single query.
+func loadList(id uint, list *List) error {
+	// As in loadPage, a caller arriving after a write does not share a read
+	// that started before it
+	gen := cachedPages.generation()
+	v, err := flights.do(fmt.Sprintf("list:%d:%d", id, gen), func() (interface{}, error) {
+		var loaded List
+		if err := getListByID(db, id, &loaded); err != nil {
+			return nil, err
+		}
+		return loaded, nil
+	})
+	if err != nil {
+		return err
+	}
+	*list = v.(List)
+	return nil
+}
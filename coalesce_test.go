@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestFlightGroupCoalesces(t *testing.T) {
+	var g flightGroup
+	release := make(chan struct{})
+	var calls int32
+
+	const callers = 10
+	var wg sync.WaitGroup
+	results := make([]interface{}, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = g.do("key", func() (interface{}, error) {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				return "value", nil
+			})
+		}(i)
+	}
+
+	waitForFlightWaiters(t, &g, "key", callers-1)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("Expected 1 call, got %d", calls)
+	}
+	for i, result := range results {
+		if result != "value" {
+			t.Errorf("Caller %d got %v, want %q", i, result, "value")
+		}
+	}
+}
+
+func TestFlightGroupPanic(t *testing.T) {
+	var g flightGroup
+	release := make(chan struct{})
+
+	const callers = 5
+	var wg sync.WaitGroup
+	recovered := make([]interface{}, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { recovered[i] = recover() }()
+			g.do("key", func() (interface{}, error) {
+				<-release
+				panic("boom")
+			})
+		}(i)
+	}
+
+	waitForFlightWaiters(t, &g, "key", callers-1)
+	close(release)
+	wg.Wait()
+
+	for i, r := range recovered {
+		if r != "boom" {
+			t.Errorf("Caller %d recovered %v, want %q", i, r, "boom")
+		}
+	}
+
+	// The key is free again once the panic is passed on
+	v, err := g.do("key", func() (interface{}, error) { return "value", nil })
+	if v != "value" || err != nil {
+		t.Errorf("Expected a new call to run, got %v, %v", v, err)
+	}
+}
+
+func TestHandleGetPageCoalesced(t *testing.T) {
+	useTestDB(t)
+	addArticleToPage(Article{Title: "Hot", Author: "Author", Content: "Content"})
+
+	// Serve every request from the database, as on an expired cache entry
+	cachedPages = newPageCache(0)
+
+	// Count the queries against each table and hold the first one until
+	// every request is waiting on it
+	var mu sync.Mutex
+	queries := map[string]int{}
+	release := make(chan struct{})
+	var once sync.Once
+	countQuery := func(tx *gorm.DB) {
+		mu.Lock()
+		queries[tx.Statement.Table]++
+		mu.Unlock()
+		once.Do(func() { <-release })
+	}
+	if err := db.Callback().Query().Before("gorm:query").Register("test:count_queries", countQuery); err != nil {
+		t.Fatalf("Failed to register query callback: %v", err)
+	}
+	if err := db.Callback().Row().Before("gorm:row").Register("test:count_rows", countQuery); err != nil {
+		t.Fatalf("Failed to register row callback: %v", err)
+	}
+
+	const requests = 100
+	var wg sync.WaitGroup
+	codes := make([]int, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			handleGetPage(rec, httptest.NewRequest("GET", "/page/get?page_id=1", nil))
+			codes[i] = rec.Code
+		}(i)
+	}
+
+	waitForFlightWaiters(t, &flights, fmt.Sprintf("page:%d:%d", 1, 0), requests-1)
+	close(release)
+	wg.Wait()
+
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("Request %d: expected status code %d but got %d", i, http.StatusOK, code)
+		}
+	}
+	if len(queries) != 1 || queries["pages"] != 1 {
+		t.Errorf("Expected a single page query, got %v", queries)
+	}
+}
+
+func TestLoadListAfterWrite(t *testing.T) {
+	useTestDB(t)
+	if _, err := appendArticle(7, Article{Title: "Title", Author: "Author", Content: "Content"}); err != nil {
+		t.Fatalf("Failed to append article: %v", err)
+	}
+
+	// Hold the first list query until the list has been changed
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var held int32
+	holdQuery := func(tx *gorm.DB) {
+		if tx.Statement.Table == "lists" && atomic.CompareAndSwapInt32(&held, 0, 1) {
+			close(started)
+			<-release
+		}
+	}
+	if err := db.Callback().Query().Before("gorm:query").Register("test:hold_lists", holdQuery); err != nil {
+		t.Fatalf("Failed to register query callback: %v", err)
+	}
+
+	stale := make(chan List)
+	go func() {
+		var list List
+		loadList(7, &list)
+		stale <- list
+	}()
+	<-started
+
+	if _, err := updateListSettings(7, ListSettings{MaxItems: newInt64(50)}, nil); err != nil {
+		t.Fatalf("Failed to update list: %v", err)
+	}
+	defer func() {
+		close(release)
+		<-stale
+	}()
+	fresh := make(chan List, 1)
+	go func() {
+		var list List
+		loadList(7, &list)
+		fresh <- list
+	}()
+	select {
+	case list := <-fresh:
+		if list.MaxItems != 50 {
+			t.Errorf("Expected a load after the write to see it, got max_items %d", list.MaxItems)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("A load after the write waited for the read that started before it")
+	}
+}
+
+// waitForFlightWaiters blocks until n callers are waiting on the in-flight
+// call for the key.
+func waitForFlightWaiters(t *testing.T, g *flightGroup, key string, n int) {
+	t.Helper()
+	for i := 0; ; i++ {
+		g.mu.Lock()
+		c, ok := g.calls[key]
+		done := ok && c.dups >= n
+		g.mu.Unlock()
+		if done {
+			return
+		}
+		if i > 5000 {
+			t.Fatalf("Timed out waiting for %d callers on %q", n, key)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// errVersionConflict is returned when a write is made against a version of a
+// Page or List that is no longer current.
+var errVersionConflict = errors.New("precondition failed: version does not match")
+
+// cacheControl is sent with every cacheable response.
+var cacheControl = "no-cache"
+
+// VersionETag formats a version counter as a strong entity tag. The creation
+// time keeps tags from repeating when a row is deleted and its ID reused.
+func versionETag(version uint, createdAt time.Time) string {
+	return fmt.Sprintf("\"%d-%x\"", version, createdAt.UnixNano())
+}
+
+func pageETag(page *Page) string {
+	return versionETag(page.Version, page.CreatedAt)
+}
+
+func listETag(list *List) string {
+	return versionETag(list.Version, list.CreatedAt)
+}
+
+// IfMatch reports whether the If-Match header of the request allows a write
+// to a resource whose current entity tag is etag. A missing header allows
+// any write. Weak tags never match, as If-Match uses strong comparison.
+func ifMatch(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// SetCacheHeaders sets the validators and caching policy for a response.
+func setCacheHeaders(w http.ResponseWriter, etag string, lastModified time.Time) {
+	w.Header().Set("ETag", etag)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	w.Header().Set("Cache-Control", cacheControl)
+}
+
+// NotModified reports whether the client's cached copy, as described by the
+// If-None-Match and If-Modified-Since headers, is still current. If-None-Match
+// takes precedence and uses weak comparison.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		for _, tag := range strings.Split(header, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if header := r.Header.Get("If-Modified-Since"); header != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(header)
+		if err != nil {
+			return false
+		}
+		// HTTP dates have a resolution of one second
+		return !lastModified.Truncate(time.Second).After(since)
+	}
+
+	return false
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIfMatch(t *testing.T) {
+	testCases := []struct {
+		name     string
+		header   string
+		etag     string
+		expected bool
+	}{
+		{name: "Missing header", header: "", etag: `"1"`, expected: true},
+		{name: "Wildcard", header: "*", etag: `"1"`, expected: true},
+		{name: "Matching tag", header: `"1"`, etag: `"1"`, expected: true},
+		{name: "Stale tag", header: `"1"`, etag: `"2"`, expected: false},
+		{name: "Tag in list", header: `"1", "2"`, etag: `"2"`, expected: true},
+		{name: "Weak tag", header: `W/"1"`, etag: `"1"`, expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/page/update", nil)
+			if tc.header != "" {
+				req.Header.Set("If-Match", tc.header)
+			}
+			if got := ifMatch(req, tc.etag); got != tc.expected {
+				t.Errorf("ifMatch(%q, %q) = %v, want %v", tc.header, tc.etag, got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestHandleUpdateIfMatch(t *testing.T) {
+	useTestDB(t)
+
+	addArticleToPage(Article{Title: "Original", Author: "Editor", Content: "Original content"})
+
+	// Read the page to learn its current version
+	rec := httptest.NewRecorder()
+	handleGetPage(rec, httptest.NewRequest("GET", "/page/get?page_id=1", nil))
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatalf("Expected an ETag header on /page/get")
+	}
+
+	update := func(ifMatch string, title string) *httptest.ResponseRecorder {
+		payload := fmt.Sprintf(`{"title": %q, "author": "Editor", "content": "Edited"}`, title)
+		req := httptest.NewRequest("POST", "/page/update?page_id=1", bytes.NewBufferString(payload))
+		req.Header.Set("If-Match", ifMatch)
+		rec := httptest.NewRecorder()
+		handleUpdate(rec, req)
+		return rec
+	}
+
+	// The first editor holds the current version and wins
+	rec = update(etag, "First editor")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d but got %d", http.StatusOK, rec.Code)
+	}
+	newETag := rec.Header().Get("ETag")
+	if newETag == "" || newETag == etag {
+		t.Errorf("Expected a new ETag after the update, got %q", newETag)
+	}
+
+	// The second editor still holds the old version and is rejected
+	rec = update(etag, "Second editor")
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected status code %d but got %d", http.StatusPreconditionFailed, rec.Code)
+	}
+
+	var articles []Article
+	if err := getArticlesByPageID(db, 1, &articles); err != nil {
+		t.Fatalf("Error getting articles: %v", err)
+	}
+	if len(articles) != 1 || articles[0].Title != "First editor" {
+		t.Errorf("Expected only the first editor's article, got %+v", articles)
+	}
+}
+
+func TestHandleDeletePageIfMatch(t *testing.T) {
+	useTestDB(t)
+
+	addArticleToPage(Article{Title: "Article", Author: "Author", Content: "Content"})
+
+	rec := httptest.NewRecorder()
+	handleGetHead(rec, httptest.NewRequest("GET", "/list/get?list_id=1", nil))
+	etag := rec.Header().Get("ETag")
+
+	// Another write changes the list version
+	addArticleToPage(Article{Title: "Article", Author: "Author", Content: "Content"})
+
+	req := httptest.NewRequest("DELETE", "/page/delete?list_id=1", nil)
+	req.Header.Set("If-Match", etag)
+	rec = httptest.NewRecorder()
+	handleDeletePage(rec, req)
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected status code %d but got %d", http.StatusPreconditionFailed, rec.Code)
+	}
+
+	var count int64
+	if err := db.Model(&Page{}).Where("list_id = ?", 1).Count(&count).Error; err != nil {
+		t.Fatalf("Failed to count pages: %v", err)
+	}
+	if count == 0 {
+		t.Errorf("Pages should not be deleted when If-Match does not match")
+	}
+
+	// With the current version the delete goes through
+	rec = httptest.NewRecorder()
+	handleGetHead(rec, httptest.NewRequest("GET", "/list/get?list_id=1", nil))
+	req = httptest.NewRequest("DELETE", "/page/delete?list_id=1", nil)
+	req.Header.Set("If-Match", rec.Header().Get("ETag"))
+	rec = httptest.NewRecorder()
+	handleDeletePage(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status code %d but got %d", http.StatusOK, rec.Code)
+	}
+}
+
+func TestHandleGetPageConditional(t *testing.T) {
+	useTestDB(t)
+
+	addArticleToPage(Article{Title: "Cached", Author: "Author", Content: "Content"})
+
+	get := func(header string, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/page/get?page_id=1", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rec := httptest.NewRecorder()
+		handleGetPage(rec, req)
+		return rec
+	}
+
+	rec := get("", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d but got %d", http.StatusOK, rec.Code)
+	}
+	etag := rec.Header().Get("ETag")
+	lastModified := rec.Header().Get("Last-Modified")
+	if etag == "" || lastModified == "" {
+		t.Fatalf("Expected ETag and Last-Modified headers, got %q and %q", etag, lastModified)
+	}
+	if got := rec.Header().Get("Cache-Control"); got != cacheControl {
+		t.Errorf("Expected Cache-Control %q, got %q", cacheControl, got)
+	}
+
+	// The client's copy is current
+	rec = get("If-None-Match", etag)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("Expected status code %d but got %d", http.StatusNotModified, rec.Code)
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("Expected an empty body with 304, got %q", rec.Body.String())
+	}
+	rec = get("If-None-Match", "W/"+etag)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("Expected weak If-None-Match to match, got status code %d", rec.Code)
+	}
+	rec = get("If-Modified-Since", lastModified)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("Expected status code %d but got %d", http.StatusNotModified, rec.Code)
+	}
+
+	// An old date or tag gets the full page
+	rec = get("If-Modified-Since", "Mon, 02 Jan 2006 15:04:05 GMT")
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status code %d but got %d", http.StatusOK, rec.Code)
+	}
+
+	addArticleToPage(Article{Title: "Another", Author: "Author", Content: "Content"})
+	rec = get("If-None-Match", etag)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status code %d after a change but got %d", http.StatusOK, rec.Code)
+	}
+	if rec.Header().Get("ETag") == etag {
+		t.Errorf("Expected the ETag to change after an append")
+	}
+}
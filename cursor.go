@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	errInvalidCursor = errors.New("invalid cursor")
+	errCursorExpired = errors.New("cursor expired")
+)
+
+// cursorSecret signs the cursors handed to clients. It is random unless set
+// with -cursorSecret, so by default cursors expire when the server restarts.
+var cursorSecret = randomCursorSecret()
+
+// cursorMaxAge is how long a cursor can be used after it was issued.
+var cursorMaxAge = 24 * time.Hour
+
+func randomCursorSecret() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return secret
+}
+
+// listCursor is a position in a List: after the given article of the given
+// page, in the given generation of the List. Sorted Lists are read by score,
+// so the cursor also has the score of the article. Clients only see it
+// signed and encoded by encodeCursor.
+type listCursor struct {
+	ListID     uint    `json:"l"`
+	Generation uint    `json:"g"`
+	PageID     uint    `json:"p"`
+	ArticleID  uint    `json:"a"`
+	Score      float64 `json:"s,omitempty"`
+	IssuedAt   int64   `json:"t"`
+}
+
+func signCursor(payload string) string {
+	mac := hmac.New(sha256.New, cursorSecret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// sealCursor signs the JSON of a cursor and encodes it as an opaque string.
+func sealCursor(v interface{}) string {
+	b, _ := json.Marshal(v)
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + signCursor(payload)
+}
+
+// openCursor checks the signature of an encoded cursor and decodes its JSON
+// into v.
+func openCursor(s string, v interface{}) error {
+	payload, signature, ok := strings.Cut(s, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(signCursor(payload))) {
+		return errInvalidCursor
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return errInvalidCursor
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return errInvalidCursor
+	}
+	return nil
+}
+
+// encodeCursor signs the cursor and encodes it as an opaque string.
+func encodeCursor(cursor listCursor) string {
+	cursor.IssuedAt = time.Now().Unix()
+	return sealCursor(cursor)
+}
+
+// decodeCursor checks the signature and age of an encoded cursor and decodes
+// it. It returns errInvalidCursor for a cursor that was not issued by this
+// server and errCursorExpired for one that is too old.
+func decodeCursor(s string, cursor *listCursor) error {
+	if err := openCursor(s, cursor); err != nil {
+		return err
+	}
+	if time.Since(time.Unix(cursor.IssuedAt, 0)) > cursorMaxAge {
+		return errCursorExpired
+	}
+	return nil
+}
+
+// resolveCursor decodes a cursor for reading the given List. The cursor must
+// have been issued for this List, and expires once the List has moved on to
+// another generation. An empty string is the start of the List.
+func resolveCursor(db *gorm.DB, listID uint, s string, cursor *listCursor) error {
+	*cursor = listCursor{ListID: listID}
+	var list List
+	err := getListByID(db, listID, &list)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if err == nil {
+		cursor.Generation = list.Generation
+	}
+	if s == "" {
+		return nil
+	}
+
+	var decoded listCursor
+	if err := decodeCursor(s, &decoded); err != nil {
+		return err
+	}
+	if decoded.ListID != listID {
+		return errInvalidCursor
+	}
+	if decoded.Generation != cursor.Generation {
+		return errCursorExpired
+	}
+	*cursor = decoded
+	return nil
+}
@@ -15,10 +15,13 @@ type Article struct {
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	DeletedAt *time.Time `sql:"index"`
-	Title     string     `json:"title"`
-	Author    string     `json:"author"`
-	Content   string     `json:"content"`
-	PageID    uint       // foreign key to Page.ID
+	Title     string     `json:"title" validate:"required,max=255"`
+	Author    string     `json:"author" validate:"required,max=255"`
+	Content   string     `json:"content" validate:"required"`
+	// Score orders the articles of sorted Lists, highest first. It is 0 in
+	// the other modes.
+	Score  float64 `json:"score" gorm:"not null;default:0;index"`
+	PageID uint    `gorm:"index"` // foreign key to Page.ID
 }
 
 // Define the Page model with a foreign key to the Article model
@@ -27,9 +30,10 @@ type Page struct {
 	CreatedAt  time.Time
 	UpdatedAt  time.Time
 	DeletedAt  *time.Time `sql:"index"`
-	ListID     uint
-	Articles   []Article `gorm:"ForeignKey:PageID"`
+	ListID     uint       `gorm:"index"`
+	Articles   []Article  `gorm:"ForeignKey:PageID"`
 	NextPageID uint
+	Version    uint `gorm:"not null;default:1"`
 }
 
 type List struct {
@@ -38,6 +42,176 @@ type List struct {
 	UpdatedAt  time.Time
 	DeletedAt  *time.Time `sql:"index"`
 	NextPageID uint
+	Version    uint `gorm:"not null;default:1"`
+	ExpiresAt  *time.Time
+	// Generation changes when the pages of the List are thrown away, which
+	// makes the cursors into the old pages expire.
+	Generation uint `gorm:"not null;default:1"`
+	// OwnerKeyID is the API key that created the List, 0 if there is none.
+	OwnerKeyID uint `gorm:"index"`
+	// MaxItems and MaxPages cap the List, 0 if uncapped. Appends past a cap
+	// trim the oldest pages from the head of the List.
+	MaxItems int64 `gorm:"not null;default:0"`
+	MaxPages int64 `gorm:"not null;default:0"`
+	// Mode is where appends go: ListModeAppend, ListModePrepend or
+	// ListModeSorted
+	Mode string `gorm:"not null;default:append"`
+}
+
+// Modes of a List.
+const (
+	// ListModeAppend adds articles to the tail of the List, oldest first
+	ListModeAppend = "append"
+	// ListModePrepend adds articles to the head of the List, so that the
+	// head page always holds the newest articles
+	ListModePrepend = "prepend"
+	// ListModeSorted keeps the articles ordered by their Score, highest
+	// first. Each article is inserted on the page it belongs to, and full
+	// pages are split in two.
+	ListModeSorted = "sorted"
+)
+
+// APIKey authenticates a client of the HTTP API. Only the SHA-256 hash of
+// the key is stored; Prefix keeps its first characters to tell keys apart.
+type APIKey struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	Name      string
+	Prefix    string
+	Hash      string `gorm:"uniqueIndex"`
+	// Scopes are comma separated, among read, write and admin
+	Scopes    string
+	RevokedAt *time.Time
+}
+
+// ListGrant lets an API key other than the owner of a List read or write it.
+type ListGrant struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	ListID    uint `gorm:"uniqueIndex:idx_list_grants_list_key"`
+	APIKeyID  uint `gorm:"uniqueIndex:idx_list_grants_list_key"`
+	// Access is read or write
+	Access string
+}
+
+// IdempotencyKey stores the response of a write request so that a retry
+// carrying the same Idempotency-Key header can be answered without
+// applying the write again.
+type IdempotencyKey struct {
+	// Subject is who sent the key, so that callers cannot see each other's
+	// responses. It is empty when authentication is off.
+	Subject     string `gorm:"primaryKey"`
+	Key         string `gorm:"primaryKey"`
+	CreatedAt   time.Time
+	Fingerprint string
+	StatusCode  int
+	ContentType string
+	ETag        string
+	Location    string
+	Body        []byte
+}
+
+// Change is an entry of the change log. It is written in the same transaction
+// as the change it describes, so the log holds every committed change and
+// nothing else. Seq orders the changes by commit.
+type Change struct {
+	Seq       uint64 `gorm:"primaryKey;autoIncrement"`
+	CreatedAt time.Time
+	Type      string
+	ListID    uint `gorm:"index"`
+	PageID    uint
+	Data      string `gorm:"type:text"`
+}
+
+// Webhook is a URL that is notified of the changes to a List. Only changes
+// after AfterSeq, the end of the change log when the Webhook was registered,
+// are delivered.
+type Webhook struct {
+	ID         uint `gorm:"primaryKey"`
+	CreatedAt  time.Time
+	ListID     uint `gorm:"index"`
+	URL        string
+	Secret     string
+	EventTypes string // comma separated, empty for every type
+	AfterSeq   uint64
+	// KeyID is the API key that registered the Webhook, 0 if none did. It
+	// must still be able to read the List for deliveries to be sent.
+	KeyID uint
+}
+
+// WebhookDelivery is the delivery of one change to one Webhook.
+type WebhookDelivery struct {
+	ID             uint `gorm:"primaryKey"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	WebhookID      uint `gorm:"index"`
+	ChangeSeq      uint64
+	EventType      string
+	Payload        string `gorm:"type:text"`
+	Status         string `gorm:"index"`
+	Attempts       int
+	NextAttemptAt  time.Time
+	LastStatusCode int
+	LastError      string
+}
+
+// PublishJob appends one article to many Lists in the background, like the
+// feeds of the followers of an author.
+type PublishJob struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	// Subject is the principal that published the article, "" without
+	// authentication. Only it and admins can see the job.
+	Subject string
+	Title   string
+	Author  string
+	Content string `gorm:"type:text"`
+	// Total is the number of targets. Status and how many of them Succeeded
+	// and Failed are counted from the targets when the job is read, so the
+	// workers appending to them never wait on the job.
+	Total     int
+	Status    string `gorm:"-"`
+	Succeeded int    `gorm:"-"`
+	Failed    int    `gorm:"-"`
+}
+
+// PublishTarget is the append of the article of a PublishJob to one List.
+type PublishTarget struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	JobID     uint `gorm:"index"`
+	ListID    uint
+	Status    string `gorm:"index"`
+	// PageID is the page the article was appended to
+	PageID uint
+	Error  string
+}
+
+// ListGroup is a named set of Lists to publish to, like the feeds of the
+// followers of an author.
+type ListGroup struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	// Subject is the principal that created the group, "" without
+	// authentication. Only it and admins can use the group.
+	Subject string
+}
+
+// ListGroupMember puts a List in a ListGroup.
+type ListGroupMember struct {
+	GroupID uint `gorm:"primaryKey"`
+	ListID  uint `gorm:"primaryKey"`
+}
+
+// DispatchCursor remembers the last change handed to a consumer of the
+// change log.
+type DispatchCursor struct {
+	Name string `gorm:"primaryKey"`
+	Seq  uint64
 }
 
 // ConnectToDB connects to the PostgreSQL server and returns a GORM DB object.
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Types of list events.
+const (
+	EventAppend     = "append"
+	EventPageUpdate = "page_update"
+	EventHeadSwap   = "head_swap"
+	EventDelete     = "delete"
+	// EventTrim is sent when the oldest articles of a capped list are
+	// deleted. PageID is the page they were on.
+	EventTrim = "trim"
+	// EventReset tells a client resuming from an event that is no longer
+	// retained to read the list again.
+	EventReset = "reset"
+)
+
+// sseHeartbeat is how often a comment is sent to keep idle streams open, and
+// how often the access of the client to the list is checked again.
+var sseHeartbeat = 15 * time.Second
+
+// listEvents retains recent list events and fans them out to subscribers.
+var listEvents = newEventBroker(1000)
+
+// ListEvent describes a committed change to a list. For head swaps PageID is
+// the new head of the list, which is 0 once the list has been deleted.
+type ListEvent struct {
+	ID     uint64    `json:"id"`
+	Type   string    `json:"type"`
+	ListID uint      `json:"list_id"`
+	PageID uint      `json:"page_id"`
+	Time   time.Time `json:"time"`
+}
+
+// eventBroker keeps a bounded log of events, so that clients can resume from
+// the last event they saw, and delivers new events to subscribers.
+type eventBroker struct {
+	mu       sync.Mutex
+	capacity int
+	nextID   uint64
+	log      []ListEvent
+	subs     map[uint]map[chan ListEvent]struct{}
+}
+
+func newEventBroker(capacity int) *eventBroker {
+	return &eventBroker{
+		capacity: capacity,
+		nextID:   1,
+		subs:     make(map[uint]map[chan ListEvent]struct{}),
+	}
+}
+
+// Publish assigns IDs to the events, retains them and sends them to the
+// subscribers of their list. A subscriber that has fallen behind is dropped
+// and has to reconnect.
+func (b *eventBroker) publish(events ...ListEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, event := range events {
+		event.ID = b.nextID
+		b.nextID++
+		if event.Time.IsZero() {
+			event.Time = time.Now()
+		}
+
+		b.log = append(b.log, event)
+		if len(b.log) > b.capacity {
+			b.log = b.log[len(b.log)-b.capacity:]
+		}
+
+		for ch := range b.subs[event.ListID] {
+			select {
+			case ch <- event:
+			default:
+				delete(b.subs[event.ListID], ch)
+				close(ch)
+			}
+		}
+	}
+}
+
+// Subscribe returns the retained events of the list after lastEventID and a
+// channel for the events that follow. The backlog starts with a reset event
+// if events after lastEventID are no longer retained. cancel must be called
+// once the subscriber is done.
+func (b *eventBroker) subscribe(listID uint, lastEventID uint64) (backlog []ListEvent, ch chan ListEvent, cancel func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if lastEventID != 0 {
+		// The event was evicted, or was sent before the server restarted
+		oldest := b.nextID - uint64(len(b.log))
+		evicted := oldest > lastEventID+1
+		if evicted || lastEventID >= b.nextID {
+			backlog = append(backlog, ListEvent{Type: EventReset, ListID: listID, Time: time.Now()})
+		}
+		for _, event := range b.log {
+			if event.ID > lastEventID && event.ListID == listID {
+				backlog = append(backlog, event)
+			}
+		}
+	}
+
+	ch = make(chan ListEvent, 64)
+	if b.subs[listID] == nil {
+		b.subs[listID] = make(map[chan ListEvent]struct{})
+	}
+	b.subs[listID][ch] = struct{}{}
+
+	cancel = func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[listID][ch]; ok {
+			delete(b.subs[listID], ch)
+			close(ch)
+		}
+	}
+	return backlog, ch, cancel
+}
+
+func writeSSEEvent(w http.ResponseWriter, event ListEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if event.ID != 0 {
+		fmt.Fprintf(w, "id: %d\n", event.ID)
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	return err
+}
+
+func streamListEvents(w http.ResponseWriter, r *http.Request) error {
+	// Validate the list ID parameter
+	listID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || listID <= 0 {
+		return fmt.Errorf("list id is not a valid integer")
+	}
+
+	// Browsers resend the last ID they saw in a header, polyfills may use
+	// the query string
+	lastIDStr := r.Header.Get("Last-Event-ID")
+	if lastIDStr == "" {
+		lastIDStr = r.URL.Query().Get("last_event_id")
+	}
+	var lastEventID uint64
+	if lastIDStr != "" {
+		lastEventID, err = strconv.ParseUint(lastIDStr, 10, 64)
+		if err != nil {
+			return fmt.Errorf("Last-Event-ID is not a valid integer")
+		}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return fmt.Errorf("streaming is not supported")
+	}
+
+	backlog, events, cancel := listEvents.subscribe(uint(listID), lastEventID)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for _, event := range backlog {
+		if err := writeSSEEvent(w, event); err != nil {
+			return nil
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case event, ok := <-events:
+			if !ok {
+				// Dropped for falling behind, the client resumes from its last ID
+				return nil
+			}
+			if err := writeSSEEvent(w, event); err != nil {
+				return nil
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			// The key may have been revoked, or lost its grant, since the
+			// stream was opened
+			if p := principalFromContext(r.Context()); p != nil {
+				allowed, err := recheckListAccess(p, uint(listID), ScopeRead)
+				if err != nil || !allowed {
+					return nil
+				}
+			}
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return nil
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// readSSEEvent reads the next event from an SSE stream, skipping comments.
+func readSSEEvent(t *testing.T, r *bufio.Reader) (id string, eventType string, event ListEvent) {
+	t.Helper()
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			if eventType != "" {
+				return id, eventType, event
+			}
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			eventType = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+				t.Fatalf("Failed to decode event data %q: %v", line, err)
+			}
+		}
+	}
+}
+
+func startEventsTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	useTestDB(t)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/lists/{id}/events", handleListEvents).Methods("GET")
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func openEventStream(t *testing.T, url string, lastEventID string) *bufio.Reader {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to open event stream: %v", err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code %d but got %d", http.StatusOK, res.StatusCode)
+	}
+	if got := res.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %q", got)
+	}
+	return bufio.NewReader(res.Body)
+}
+
+func TestListEventsStream(t *testing.T) {
+	server := startEventsTestServer(t)
+	stream := openEventStream(t, server.URL+"/lists/3/events", "")
+
+	// The first append creates the head page of the list
+	page, err := appendArticle(3, Article{Title: "Article"})
+	if err != nil {
+		t.Fatalf("Failed to append article: %v", err)
+	}
+	// Events of other lists are not sent
+	appendArticle(4, Article{Title: "Other list"})
+
+	_, eventType, event := readSSEEvent(t, stream)
+	if eventType != EventHeadSwap || event.PageID != page.ID {
+		t.Errorf("Expected a head swap to page %d, got %s %+v", page.ID, eventType, event)
+	}
+	_, eventType, event = readSSEEvent(t, stream)
+	if eventType != EventAppend || event.ListID != 3 || event.PageID != page.ID {
+		t.Errorf("Expected an append to page %d, got %s %+v", page.ID, eventType, event)
+	}
+
+	if _, err := updatePage(page.ID, []Article{{Title: "Updated"}}, nil); err != nil {
+		t.Fatalf("Failed to update page: %v", err)
+	}
+	if _, eventType, _ = readSSEEvent(t, stream); eventType != EventPageUpdate {
+		t.Errorf("Expected a page update, got %s", eventType)
+	}
+
+	if _, err := deleteList(3, nil); err != nil {
+		t.Fatalf("Failed to delete list: %v", err)
+	}
+	if _, eventType, _ = readSSEEvent(t, stream); eventType != EventDelete {
+		t.Errorf("Expected a delete, got %s", eventType)
+	}
+	if _, eventType, event = readSSEEvent(t, stream); eventType != EventHeadSwap || event.PageID != 0 {
+		t.Errorf("Expected a head swap to 0, got %s %+v", eventType, event)
+	}
+}
+
+func TestListEventsResume(t *testing.T) {
+	server := startEventsTestServer(t)
+
+	for i := 0; i < 3; i++ {
+		appendArticle(8, Article{Title: "Article"})
+	}
+
+	// Events 1 and 2 are the head swap and the first append
+	stream := openEventStream(t, server.URL+"/lists/8/events", "2")
+	for _, want := range []string{"3", "4"} {
+		id, eventType, _ := readSSEEvent(t, stream)
+		if id != want || eventType != EventAppend {
+			t.Errorf("Expected append with ID %s, got %s with ID %s", want, eventType, id)
+		}
+	}
+
+	// Resuming from an event that is no longer retained asks for a reset
+	listEvents = newEventBroker(2)
+	for i := 0; i < 4; i++ {
+		appendArticle(8, Article{Title: "Article"})
+	}
+	stream = openEventStream(t, server.URL+"/lists/8/events", "1")
+	if _, eventType, _ := readSSEEvent(t, stream); eventType != EventReset {
+		t.Errorf("Expected a reset event, got %s", eventType)
+	}
+	if id, _, _ := readSSEEvent(t, stream); id != "3" {
+		t.Errorf("Expected the oldest retained event 3, got %s", id)
+	}
+}
+
+func TestListEventsInvalidID(t *testing.T) {
+	server := startEventsTestServer(t)
+
+	res, err := http.Get(server.URL + "/lists/abc/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status code %d but got %d", http.StatusBadRequest, res.StatusCode)
+	}
+}
+
+func TestEventBrokerWithoutLog(t *testing.T) {
+	broker := newEventBroker(0)
+	broker.publish(ListEvent{Type: EventAppend, ListID: 8}, ListEvent{Type: EventAppend, ListID: 8})
+
+	// Event 2 was never retained, so resuming after event 1 asks for a reset
+	backlog, _, cancel := broker.subscribe(8, 1)
+	defer cancel()
+	if len(backlog) != 1 || backlog[0].Type != EventReset {
+		t.Errorf("Expected a reset event, got %+v", backlog)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/ericlinsechs/key-value-list/kvlistpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// grpcServer serves the KeyValueList service from the same store as the
+// HTTP handlers.
+type grpcServer struct {
+	kvlistpb.UnimplementedKeyValueListServer
+}
+
+// newGRPCServer creates a gRPC server with the KeyValueList service
+// registered.
+func newGRPCServer() *grpc.Server {
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(authenticateGRPC),
+		grpc.StreamInterceptor(authenticateGRPCStream),
+	)
+	kvlistpb.RegisterKeyValueListServer(s, &grpcServer{})
+	return s
+}
+
+// grpcPrincipal finds who made a call from the API key or JWT in its
+// "authorization" metadata, "Bearer <token>" as in the HTTP API, and adds it
+// to the context.
+func grpcPrincipal(ctx context.Context) (context.Context, error) {
+	if !authRequired {
+		return ctx, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	var header string
+	if values := md.Get("authorization"); len(values) > 0 {
+		header = values[0]
+	}
+	p, err := authenticateHeader(header)
+	if err != nil {
+		if errors.Is(err, errUnauthorized) {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		return nil, grpcError(err)
+	}
+	return context.WithValue(ctx, principalContextKey{}, p), nil
+}
+
+// authenticateGRPC is an interceptor that rejects unary calls without a
+// valid API key or JWT.
+func authenticateGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := grpcPrincipal(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// authenticatedStream is a server stream with the principal in its context.
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context { return s.ctx }
+
+// authenticateGRPCStream is an interceptor that rejects streaming calls
+// without a valid API key or JWT.
+func authenticateGRPCStream(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := grpcPrincipal(stream.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authenticatedStream{ServerStream: stream, ctx: ctx})
+}
+
+// authorizeGRPC checks that the caller has the scope and its access to the
+// List.
+func authorizeGRPC(ctx context.Context, listID uint, scope string, creates bool) error {
+	if err := authorizeList(principalFromContext(ctx), listID, scope, creates); err != nil {
+		if errors.Is(err, errForbidden) {
+			return status.Error(codes.PermissionDenied, err.Error())
+		}
+		return grpcError(err)
+	}
+	return nil
+}
+
+// authorizeGRPCPage checks the access of the caller to the List of the page.
+// Missing pages are left to the methods.
+func authorizeGRPCPage(ctx context.Context, pageID uint, scope string) error {
+	var page Page
+	if err := getPageByID(db, pageID, &page); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return grpcError(err)
+	}
+	return authorizeGRPC(ctx, page.ListID, scope, false)
+}
+
+// grpcError maps a store error to a gRPC status.
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound), strings.Contains(err.Error(), "not found"):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, errVersionConflict), errors.Is(err, errSortedPage):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case strings.Contains(err.Error(), "quota exceeded"):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+func toProtoPage(page *Page) *kvlistpb.Page {
+	res := &kvlistpb.Page{
+		Id:         uint64(page.ID),
+		ListId:     uint64(page.ListID),
+		NextPageId: uint64(page.NextPageID),
+		Version:    uint64(page.Version),
+	}
+	for _, article := range page.Articles {
+		res.Articles = append(res.Articles, &kvlistpb.Article{
+			Title:   article.Title,
+			Author:  article.Author,
+			Content: article.Content,
+		})
+	}
+	return res
+}
+
+func fromProtoArticle(article *kvlistpb.Article) Article {
+	return Article{
+		Title:   article.GetTitle(),
+		Author:  article.GetAuthor(),
+		Content: article.GetContent(),
+	}
+}
+
+func (s *grpcServer) GetHead(ctx context.Context, req *kvlistpb.GetHeadRequest) (*kvlistpb.GetHeadResponse, error) {
+	if err := authorizeGRPC(ctx, uint(req.GetListId()), ScopeRead, false); err != nil {
+		return nil, err
+	}
+	var list List
+	if err := loadList(uint(req.GetListId()), &list); err != nil {
+		return nil, grpcError(err)
+	}
+	return &kvlistpb.GetHeadResponse{
+		NextPageId: uint64(list.NextPageID),
+		Version:    uint64(list.Version),
+	}, nil
+}
+
+func (s *grpcServer) GetPage(ctx context.Context, req *kvlistpb.GetPageRequest) (*kvlistpb.Page, error) {
+	if err := authorizeGRPCPage(ctx, uint(req.GetPageId()), ScopeRead); err != nil {
+		return nil, err
+	}
+	var page Page
+	if !cachedPages.get(uint(req.GetPageId()), &page) {
+		if err := loadPage(uint(req.GetPageId()), &page); err != nil {
+			return nil, grpcError(err)
+		}
+	}
+	return toProtoPage(&page), nil
+}
+
+func (s *grpcServer) Append(ctx context.Context, req *kvlistpb.AppendRequest) (*kvlistpb.AppendResponse, error) {
+	if req.GetListId() == 0 {
+		return nil, status.Error(codes.InvalidArgument, "list_id is required")
+	}
+	if req.GetArticle() == nil {
+		return nil, status.Error(codes.InvalidArgument, "article is required")
+	}
+
+	article := fromProtoArticle(req.GetArticle())
+	if err := validateArticles([]Article{article}, "article"); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := authorizeGRPC(ctx, uint(req.GetListId()), ScopeWrite, true); err != nil {
+		return nil, err
+	}
+
+	page, err := appendArticle(uint(req.GetListId()), article)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &kvlistpb.AppendResponse{PageId: uint64(page.ID)}, nil
+}
+
+func (s *grpcServer) UpdatePage(ctx context.Context, req *kvlistpb.UpdatePageRequest) (*kvlistpb.Page, error) {
+	var articles []Article
+	for _, article := range req.GetArticles() {
+		articles = append(articles, fromProtoArticle(article))
+	}
+	if err := validateArticles(articles, "articles[]"); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := authorizeGRPCPage(ctx, uint(req.GetPageId()), ScopeWrite); err != nil {
+		return nil, err
+	}
+
+	page, err := updatePage(uint(req.GetPageId()), articles, func(page *Page) error {
+		if req.GetExpectedVersion() != 0 && req.GetExpectedVersion() != uint64(page.Version) {
+			return errVersionConflict
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toProtoPage(&page), nil
+}
+
+func (s *grpcServer) DeleteList(ctx context.Context, req *kvlistpb.DeleteListRequest) (*kvlistpb.DeleteListResponse, error) {
+	if err := authorizeGRPC(ctx, uint(req.GetListId()), ScopeWrite, false); err != nil {
+		return nil, err
+	}
+	deleted, err := deleteList(uint(req.GetListId()), func(list *List) error {
+		if req.GetExpectedVersion() == 0 {
+			return nil
+		}
+		if list == nil || req.GetExpectedVersion() != uint64(list.Version) {
+			return errVersionConflict
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &kvlistpb.DeleteListResponse{DeletedPages: deleted}, nil
+}
+
+func (s *grpcServer) TraverseList(req *kvlistpb.TraverseListRequest, stream kvlistpb.KeyValueList_TraverseListServer) error {
+	if err := authorizeGRPC(stream.Context(), uint(req.GetListId()), ScopeRead, false); err != nil {
+		return err
+	}
+	var sendErr error
+	err := walkList(uint(req.GetListId()), func(page *Page) bool {
+		if err := stream.Context().Err(); err != nil {
+			sendErr = status.FromContextError(err).Err()
+			return false
+		}
+		sendErr = stream.Send(toProtoPage(page))
+		return sendErr == nil
+	})
+	if err != nil {
+		return grpcError(err)
+	}
+	return sendErr
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/ericlinsechs/key-value-list/kvlistpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// startGRPCTestServer serves the gRPC API over an in-process connection and
+// returns a client for it.
+func startGRPCTestServer(t *testing.T) kvlistpb.KeyValueListClient {
+	t.Helper()
+	useTestDB(t)
+
+	ln := bufconn.Listen(1024 * 1024)
+	server := newGRPCServer()
+	go server.Serve(ln)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return ln.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to dial bufnet: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return kvlistpb.NewKeyValueListClient(conn)
+}
+
+func TestGRPCAppendAndRead(t *testing.T) {
+	client := startGRPCTestServer(t)
+	ctx := context.Background()
+
+	// Append enough articles to fill two pages
+	for i := 0; i < NumberOfArticleInOnePage+2; i++ {
+		_, err := client.Append(ctx, &kvlistpb.AppendRequest{
+			ListId:  5,
+			Article: &kvlistpb.Article{Title: "Title", Author: "Author", Content: "Content"},
+		})
+		if err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	head, err := client.GetHead(ctx, &kvlistpb.GetHeadRequest{ListId: 5})
+	if err != nil {
+		t.Fatalf("GetHead failed: %v", err)
+	}
+	page, err := client.GetPage(ctx, &kvlistpb.GetPageRequest{PageId: head.GetNextPageId()})
+	if err != nil {
+		t.Fatalf("GetPage failed: %v", err)
+	}
+	if len(page.GetArticles()) != NumberOfArticleInOnePage || page.GetNextPageId() == 0 {
+		t.Errorf("Unexpected first page: %v", page)
+	}
+
+	// Traversal streams both pages in order
+	stream, err := client.TraverseList(ctx, &kvlistpb.TraverseListRequest{ListId: 5})
+	if err != nil {
+		t.Fatalf("TraverseList failed: %v", err)
+	}
+	var pages []*kvlistpb.Page
+	for {
+		page, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("TraverseList stream failed: %v", err)
+		}
+		pages = append(pages, page)
+	}
+	if len(pages) != 2 || pages[0].GetNextPageId() != pages[1].GetId() || len(pages[1].GetArticles()) != 2 {
+		t.Errorf("Unexpected traversal: %v", pages)
+	}
+
+	_, err = client.GetPage(ctx, &kvlistpb.GetPageRequest{PageId: 999})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound for a missing page, got %v", err)
+	}
+}
+
+func TestGRPCUpdateAndDelete(t *testing.T) {
+	client := startGRPCTestServer(t)
+	ctx := context.Background()
+
+	res, err := client.Append(ctx, &kvlistpb.AppendRequest{
+		ListId:  1,
+		Article: &kvlistpb.Article{Title: "Original", Author: "Author", Content: "Content"},
+	})
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	page, err := client.GetPage(ctx, &kvlistpb.GetPageRequest{PageId: res.GetPageId()})
+	if err != nil {
+		t.Fatalf("GetPage failed: %v", err)
+	}
+
+	updated, err := client.UpdatePage(ctx, &kvlistpb.UpdatePageRequest{
+		PageId:          page.GetId(),
+		Articles:        []*kvlistpb.Article{{Title: "Updated", Author: "Author", Content: "Content"}},
+		ExpectedVersion: page.GetVersion(),
+	})
+	if err != nil {
+		t.Fatalf("UpdatePage failed: %v", err)
+	}
+	if len(updated.GetArticles()) != 1 || updated.GetArticles()[0].GetTitle() != "Updated" || updated.GetVersion() == page.GetVersion() {
+		t.Errorf("Unexpected updated page: %v", updated)
+	}
+
+	// An update against the old version is rejected
+	_, err = client.UpdatePage(ctx, &kvlistpb.UpdatePageRequest{
+		PageId:          page.GetId(),
+		Articles:        []*kvlistpb.Article{{Title: "Stale", Author: "Author", Content: "Content"}},
+		ExpectedVersion: page.GetVersion(),
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition, got %v", err)
+	}
+
+	deleted, err := client.DeleteList(ctx, &kvlistpb.DeleteListRequest{ListId: 1})
+	if err != nil {
+		t.Fatalf("DeleteList failed: %v", err)
+	}
+	if deleted.GetDeletedPages() != 1 {
+		t.Errorf("Expected 1 deleted page, got %d", deleted.GetDeletedPages())
+	}
+	head, err := client.GetHead(ctx, &kvlistpb.GetHeadRequest{ListId: 1})
+	if err != nil {
+		t.Fatalf("GetHead failed: %v", err)
+	}
+	if head.GetNextPageId() != 0 {
+		t.Errorf("Expected an empty list after delete, got head %d", head.GetNextPageId())
+	}
+}
+
+func TestGRPCAuthentication(t *testing.T) {
+	client := startGRPCTestServer(t)
+	useAuth(t)
+	owner, _ := mintAPIKey(t, "owner", ScopeRead, ScopeWrite)
+	other, _ := mintAPIKey(t, "other", ScopeRead, ScopeWrite)
+	withKey := func(key string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+key)
+	}
+	article := &kvlistpb.Article{Title: "Title", Author: "Author", Content: "Content"}
+
+	_, err := client.Append(context.Background(), &kvlistpb.AppendRequest{ListId: 5, Article: article})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected Unauthenticated without a key, got %v", err)
+	}
+	_, err = client.DeleteList(withKey("kvl_wrong"), &kvlistpb.DeleteListRequest{ListId: 5})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected Unauthenticated with a wrong key, got %v", err)
+	}
+
+	// The first append claims the list for the key
+	res, err := client.Append(withKey(owner), &kvlistpb.AppendRequest{ListId: 5, Article: article})
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	_, err = client.DeleteList(withKey(other), &kvlistpb.DeleteListRequest{ListId: 5})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected PermissionDenied for another key, got %v", err)
+	}
+	_, err = client.UpdatePage(withKey(other), &kvlistpb.UpdatePageRequest{PageId: res.GetPageId(), Articles: []*kvlistpb.Article{article}})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected PermissionDenied for another key, got %v", err)
+	}
+	stream, err := client.TraverseList(withKey(other), &kvlistpb.TraverseListRequest{ListId: 5})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected PermissionDenied for another key, got %v", err)
+	}
+	if _, err := client.GetPage(withKey(owner), &kvlistpb.GetPageRequest{PageId: res.GetPageId()}); err != nil {
+		t.Errorf("GetPage failed for the owner: %v", err)
+	}
+}
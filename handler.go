@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -9,16 +8,8 @@ import (
 )
 
 func getHead(w http.ResponseWriter, r *http.Request) error {
-	// Extract the value of the "list_id" query parameter
-	idStr := r.URL.Query().Get("list_id")
-	if idStr == "" {
-		return fmt.Errorf("list_id parameter is missing")
-	}
-	// Validate the list ID parameter
-	listID, err := strconv.Atoi(idStr)
-	if err != nil {
-		return fmt.Errorf("list_id parameter is not a valid integer")
-	}
+	// The "list_id" query parameter is validated against the OpenAPI spec
+	listID, _ := strconv.Atoi(r.URL.Query().Get("list_id"))
 
 	if db == nil { // check if db is nil
 		return fmt.Errorf("database connection is nil")
@@ -26,10 +17,17 @@ func getHead(w http.ResponseWriter, r *http.Request) error {
 
 	// Fetch the list from the database
 	var list List
-	if err := getListByID(db, uint(listID), &list); err != nil {
+	if err := loadList(uint(listID), &list); err != nil {
 		return fmt.Errorf("error fetching list: %v", err)
 	}
 
+	// Answer with 304 if the client already has this version of the list
+	setCacheHeaders(w, listETag(&list), list.UpdatedAt)
+	if notModified(r, listETag(&list), list.UpdatedAt) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
 	// Return the list's next page ID as JSON
 	res := map[string]interface{}{
 		"next_page_id": list.NextPageID,
@@ -43,42 +41,32 @@ func getHead(w http.ResponseWriter, r *http.Request) error {
 }
 
 func getPage(w http.ResponseWriter, r *http.Request) error {
-	// Extract the value of the "page_id" query parameter
-	idStr := r.URL.Query().Get("page_id")
-	if idStr == "" {
-		return fmt.Errorf("page_id parameter is missing")
-	}
-	// Validate the list ID parameter
-	pageID, err := strconv.Atoi(idStr)
-	if err != nil {
-		return fmt.Errorf("page_id parameter is not a valid integer")
-	}
+	// The "page_id" query parameter is validated against the OpenAPI spec
+	pageID, _ := strconv.Atoi(r.URL.Query().Get("page_id"))
 
 	if db == nil { // check if db is nil
 		return fmt.Errorf("database connection is nil")
 	}
 
+	// Serve the page from the cache if possible
 	var page Page
-	// where "id" is the ID of the page you want to retrieve
-	err = getPageByID(db, uint(pageID), &page)
-	if err != nil {
-		return fmt.Errorf("error getting page from database: %v", err)
+	if !cachedPages.get(uint(pageID), &page) {
+		if err := loadPage(uint(pageID), &page); err != nil {
+			return fmt.Errorf("error getting page from database: %v", err)
+		}
 	}
 
-	// Query the database to get the articles associated with the specified page ID
-	var articles []Article
-	err = getArticlesByPageID(db, uint(pageID), &articles)
-	if err != nil {
-		return fmt.Errorf("error getting articles from database: %v", err)
+	// Answer with 304 if the client already has this version of the page
+	setCacheHeaders(w, pageETag(&page), page.UpdatedAt)
+	if notModified(r, pageETag(&page), page.UpdatedAt) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
 	}
+	articles := page.Articles
 
-	var articleData []map[string]string
+	var articleData []map[string]interface{}
 	for _, article := range articles {
-		articleData = append(articleData, map[string]string{
-			"title":   article.Title,
-			"author":  article.Author,
-			"content": article.Content,
-		})
+		articleData = append(articleData, articleFields(article))
 	}
 	res := map[string]interface{}{
 		"articles":     articleData,
@@ -93,105 +81,114 @@ func getPage(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+// articleFields returns the fields of an article that are sent to clients.
+func articleFields(article Article) map[string]interface{} {
+	return map[string]interface{}{
+		"title":   article.Title,
+		"author":  article.Author,
+		"content": article.Content,
+		"score":   article.Score,
+	}
+}
+
 func set(w http.ResponseWriter, r *http.Request) error {
-	var err error
-	var article Article
 	// Parse the request body to get the article data
-	err = json.NewDecoder(r.Body).Decode(&article)
+	article, err := decodeArticle(w, r)
 	if err != nil {
-		return fmt.Errorf("invalid request body: %v", err)
+		return err
 	}
 
-	addArticleToPage(article)
+	if err := addArticleToPage(article); err != nil {
+		return fmt.Errorf("failed to add article: %v", err)
+	}
 
 	w.WriteHeader(http.StatusOK)
 	return nil
 }
 
 func update(w http.ResponseWriter, r *http.Request) error {
-	// Extract the value of the "page_id" query parameter
-	idStr := r.URL.Query().Get("page_id")
-	if idStr == "" {
-		return fmt.Errorf("page_id parameter is missing")
-	}
-	// Validate the list ID parameter
-	pageID, err := strconv.Atoi(idStr)
+	// The "page_id" query parameter is validated against the OpenAPI spec
+	pageID, _ := strconv.Atoi(r.URL.Query().Get("page_id"))
+
+	articles, err := decodeArticles(w, r)
 	if err != nil {
-		return fmt.Errorf("page_id parameter is not a valid integer")
+		return err
 	}
 
-	// Read the request body into a byte buffer
-	buf := new(bytes.Buffer)
-	buf.ReadFrom(r.Body)
-
-	// Attempt to decode the request body as a slice of articles
-	var articles []Article
-	if err := json.Unmarshal(buf.Bytes(), &articles); err == nil {
-		// Delete the existing articles associated with the page
-		if err := deleteArticlesByPageID(db, uint(pageID)); err != nil {
-			return fmt.Errorf("failed to delete articles: %v", err)
+	page, err := updatePage(uint(pageID), articles, func(page *Page) error {
+		if !ifMatch(r, pageETag(page)) {
+			return errVersionConflict
 		}
-
-		// Get the corresponding page
-		var page Page
-		if err := getPageByID(db, uint(pageID), &page); err != nil {
-			return fmt.Errorf("page not found: %v", err)
-		}
-
-		// Update the page's articles
-		page.Articles = articles
-
-		if err := savePage(db, &page); err != nil {
-			return fmt.Errorf("failed to update page: %v", err)
-		}
-
-		w.WriteHeader(http.StatusOK)
 		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	// Attempt to decode the request body as a single article
+	w.Header().Set("ETag", pageETag(&page))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// decodeArticle decodes and validates a request body holding an article.
+func decodeArticle(w http.ResponseWriter, r *http.Request) (Article, error) {
 	var article Article
-	if err := json.Unmarshal(buf.Bytes(), &article); err != nil {
-		return fmt.Errorf("invalid request body: %v", err)
+	body, err := readBody(w, r)
+	if err != nil {
+		return article, err
 	}
-
-	// Delete the existing articles associated with the page
-	if err := deleteArticlesByPageID(db, uint(pageID)); err != nil {
-		return fmt.Errorf("failed to delete articles: %v", err)
+	if err := json.Unmarshal(body, &article); err != nil {
+		return article, fmt.Errorf("invalid request body: %v", err)
 	}
+	return article, validateArticles([]Article{article}, "")
+}
 
-	// Get the corresponding page
-	var page Page
-	if err := getPageByID(db, uint(pageID), &page); err != nil {
-		return fmt.Errorf("page not found: %v", err)
+// decodeArticles decodes and validates a request body holding either an
+// array of articles or a single article.
+func decodeArticles(w http.ResponseWriter, r *http.Request) ([]Article, error) {
+	body, err := readBody(w, r)
+	if err != nil {
+		return nil, err
 	}
 
-	// Update the page's articles
-	page.Articles = []Article{article}
-
-	if err := savePage(db, &page); err != nil {
-		return fmt.Errorf("failed to update page: %v", err)
+	// Attempt to decode the request body as a slice of articles
+	var articles []Article
+	if err := json.Unmarshal(body, &articles); err != nil {
+		// Attempt to decode the request body as a single article
+		var article Article
+		if err := json.Unmarshal(body, &article); err != nil {
+			return nil, fmt.Errorf("invalid request body: %v", err)
+		}
+		return []Article{article}, validateArticles([]Article{article}, "")
 	}
+	return articles, validateArticles(articles, "[]")
+}
 
-	w.WriteHeader(http.StatusOK)
+// listIfMatch checks the If-Match header of the request against the list,
+// which is nil if there is no such list.
+func listIfMatch(r *http.Request, list *List) error {
+	// A missing list has no version that If-Match could match
+	if list == nil {
+		if r.Header.Get("If-Match") != "" {
+			return errVersionConflict
+		}
+		return nil
+	}
+	if !ifMatch(r, listETag(list)) {
+		return errVersionConflict
+	}
 	return nil
 }
 
 func deletePage(w http.ResponseWriter, r *http.Request) error {
-	// Extract the value of the "list_id" query parameter
-	idStr := r.URL.Query().Get("list_id")
-	if idStr == "" {
-		return fmt.Errorf("list_id parameter is missing")
-	}
-	// Validate the list ID parameter
-	listID, err := strconv.Atoi(idStr)
-	if err != nil {
-		return fmt.Errorf("list_id parameter is not a valid integer")
-	}
+	// The "list_id" query parameter is validated against the OpenAPI spec
+	listID, _ := strconv.Atoi(r.URL.Query().Get("list_id"))
 
-	err = deletePagesByListID(db, uint(listID))
+	_, err := deleteList(uint(listID), func(list *List) error {
+		return listIfMatch(r, list)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to delete articles: %v", err)
+		return err
 	}
 
 	fmt.Fprintf(w, "Successfully deleted all pages and articles with list ID %d\n", listID)
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// idempotencyWindow is how long a stored response is replayed for retries.
+var idempotencyWindow = 24 * time.Hour
+
+func getIdempotencyKey(db *gorm.DB, subject string, key string, record *IdempotencyKey) error {
+	return db.Table("idempotency_keys").Where("subject = ? AND key = ?", subject, key).First(record).Error
+}
+
+// CreateIdempotencyKey reserves the given key. It fails if the key already exists.
+func createIdempotencyKey(db *gorm.DB, record *IdempotencyKey) error {
+	return db.Table("idempotency_keys").Create(record).Error
+}
+
+// SaveIdempotencyKey stores the response recorded for the key.
+func saveIdempotencyKey(db *gorm.DB, record *IdempotencyKey) error {
+	return db.Table("idempotency_keys").Where("subject = ? AND key = ?", record.Subject, record.Key).
+		Select("*").Updates(record).Error
+}
+
+func deleteIdempotencyKey(db *gorm.DB, subject string, key string) error {
+	return db.Where("subject = ? AND key = ?", subject, key).Delete(&IdempotencyKey{}).Error
+}
+
+// DeleteExpiredIdempotencyKeys removes every key created before the given time.
+func deleteExpiredIdempotencyKeys(db *gorm.DB, before time.Time) error {
+	return db.Where("created_at < ?", before).Delete(&IdempotencyKey{}).Error
+}
+
+// responseRecorder passes a response through to the client while keeping a
+// copy of its status code and body.
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(statusCode int) {
+	if rec.statusCode == 0 {
+		rec.statusCode = statusCode
+	}
+	rec.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.statusCode == 0 {
+		rec.statusCode = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// requestFingerprint identifies a request by its method, URL and body so that
+// a key reused for a different request can be rejected.
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// withIdempotency wraps a write handler so that requests carrying an
+// Idempotency-Key header are applied at most once within idempotencyWindow.
+// Keys are scoped to the principal that sent them. Retries get the original
+// status code, body and headers back. Responses with a 5xx status are not
+// stored, so the client may retry them.
+func withIdempotency(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > 255 {
+			http.Error(w, "Idempotency-Key header is too long", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+		if err != nil {
+			err = bodyReadError(err)
+			if !writeRequestError(w, err) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		fingerprint := requestFingerprint(r, body)
+		subject := subjectOf(principalFromContext(r.Context()))
+
+		record := IdempotencyKey{
+			Subject:     subject,
+			Key:         key,
+			CreatedAt:   time.Now(),
+			Fingerprint: fingerprint,
+		}
+		if err := createIdempotencyKey(db, &record); err != nil {
+			// The key is already taken, either by a finished or an in-flight request
+			var existing IdempotencyKey
+			if err := getIdempotencyKey(db, subject, key, &existing); err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					http.Error(w, "request with this Idempotency-Key is in progress", http.StatusConflict)
+					return
+				}
+				log.Printf("Error fetching idempotency key: %v\n", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+
+			if time.Since(existing.CreatedAt) > idempotencyWindow {
+				// The stored response has expired, so treat this as a new request
+				if err := deleteIdempotencyKey(db, subject, key); err != nil {
+					log.Printf("Error deleting idempotency key: %v\n", err)
+				}
+				if err := createIdempotencyKey(db, &record); err != nil {
+					http.Error(w, "request with this Idempotency-Key is in progress", http.StatusConflict)
+					return
+				}
+			} else {
+				replayIdempotentResponse(w, &existing, fingerprint)
+				return
+			}
+		}
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next(rec, r)
+		if rec.statusCode == 0 {
+			rec.statusCode = http.StatusOK
+		}
+
+		if rec.statusCode >= http.StatusInternalServerError {
+			if err := deleteIdempotencyKey(db, subject, key); err != nil {
+				log.Printf("Error deleting idempotency key: %v\n", err)
+			}
+			return
+		}
+
+		record.StatusCode = rec.statusCode
+		record.ContentType = rec.Header().Get("Content-Type")
+		record.ETag = rec.Header().Get("ETag")
+		record.Location = rec.Header().Get("Location")
+		record.Body = rec.body.Bytes()
+		if err := saveIdempotencyKey(db, &record); err != nil {
+			log.Printf("Error saving idempotency key: %v\n", err)
+		}
+	}
+}
+
+func replayIdempotentResponse(w http.ResponseWriter, record *IdempotencyKey, fingerprint string) {
+	if record.Fingerprint != fingerprint {
+		http.Error(w, "Idempotency-Key was already used for a different request", http.StatusUnprocessableEntity)
+		return
+	}
+	if record.StatusCode == 0 {
+		http.Error(w, "request with this Idempotency-Key is in progress", http.StatusConflict)
+		return
+	}
+
+	if record.ContentType != "" {
+		w.Header().Set("Content-Type", record.ContentType)
+	}
+	if record.ETag != "" {
+		w.Header().Set("ETag", record.ETag)
+	}
+	if record.Location != "" {
+		w.Header().Set("Location", record.Location)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(record.StatusCode)
+	w.Write(record.Body)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestWithIdempotency(t *testing.T) {
+	useTestDB(t)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/page/set", withIdempotency(handleSet)).Methods("POST")
+
+	send := func(key string, payload string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/page/set", bytes.NewBufferString(payload))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	countArticles := func() int64 {
+		var count int64
+		if err := db.Model(&Article{}).Count(&count).Error; err != nil {
+			t.Fatalf("Failed to count articles: %v", err)
+		}
+		return count
+	}
+
+	payload := `{"title": "Retry", "author": "Producer", "content": "Sent twice"}`
+
+	// The first request is applied
+	rec := send("retry-1", payload)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d but got %d", http.StatusOK, rec.Code)
+	}
+	if rec.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("First response should not be marked as replayed")
+	}
+
+	// A retry with the same key replays the response without a second write
+	rec = send("retry-1", payload)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status code %d but got %d", http.StatusOK, rec.Code)
+	}
+	if rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("Retried response should be marked as replayed")
+	}
+	if count := countArticles(); count != 1 {
+		t.Errorf("Expected 1 article after a retry, got %d", count)
+	}
+
+	// Reusing the key for a different request is rejected
+	rec = send("retry-1", `{"title": "Other", "author": "Producer", "content": "Different"}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status code %d but got %d", http.StatusUnprocessableEntity, rec.Code)
+	}
+
+	// Requests without a key are applied every time
+	send("", payload)
+	send("", payload)
+	if count := countArticles(); count != 3 {
+		t.Errorf("Expected 3 articles, got %d", count)
+	}
+
+	// Once the window has passed the key can be used again
+	if err := db.Model(&IdempotencyKey{}).Where("key = ?", "retry-1").
+		Update("created_at", time.Now().Add(-idempotencyWindow-time.Minute)).Error; err != nil {
+		t.Fatalf("Failed to age idempotency key: %v", err)
+	}
+	rec = send("retry-1", payload)
+	if rec.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("Expired key should not be replayed")
+	}
+	if count := countArticles(); count != 4 {
+		t.Errorf("Expected 4 articles, got %d", count)
+	}
+}
+
+func TestIdempotencyPerPrincipal(t *testing.T) {
+	useTestDB(t)
+	useAuth(t)
+	router := newRouter()
+	first, _ := mintAPIKey(t, "first", ScopeRead, ScopeWrite)
+	second, _ := mintAPIKey(t, "second", ScopeRead, ScopeWrite)
+
+	send := func(key, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", url, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+key)
+		req.Header.Set(IdempotencyKeyHeader, "same-key")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	article := `{"title": "Title", "author": "Author", "content": "Content"}`
+
+	rec := send(first, "/v1/lists/5/items", article)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d but got %d: %s", http.StatusCreated, rec.Code, rec.Body.String())
+	}
+	location := rec.Header().Get("Location")
+
+	// Replays keep the headers of the original response
+	rec = send(first, "/v1/lists/5/items", article)
+	if rec.Header().Get("Idempotent-Replayed") != "true" || rec.Header().Get("Location") != location {
+		t.Errorf("Expected a replay with Location %q, got %q", location, rec.Header().Get("Location"))
+	}
+
+	// Another caller using the same key gets its own request applied
+	rec = send(second, "/v1/lists/6/items", article)
+	if rec.Code != http.StatusCreated || rec.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("Expected the request of another caller to be applied, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestIdempotencyBodyLimit(t *testing.T) {
+	useTestDB(t)
+	savedSize := maxBodySize
+	maxBodySize = 16
+	t.Cleanup(func() { maxBodySize = savedSize })
+
+	req := httptest.NewRequest("DELETE", "/page/delete?list_id=1", bytes.NewBufferString(`{"padding": "more than sixteen bytes"}`))
+	req.Header.Set(IdempotencyKeyHeader, "large")
+	rec := httptest.NewRecorder()
+	withIdempotency(handleDeletePage)(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status code %d but got %d", http.StatusRequestEntityTooLarge, rec.Code)
+	}
+}
@@ -9,9 +9,18 @@ CREATE TABLE lists (
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     deleted_at TIMESTAMP WITH TIME ZONE,
-    next_page_id INTEGER
+    next_page_id INTEGER,
+    version INTEGER NOT NULL DEFAULT 1,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    generation INTEGER NOT NULL DEFAULT 1,
+    owner_key_id INTEGER,
+    max_items BIGINT NOT NULL DEFAULT 0,
+    max_pages BIGINT NOT NULL DEFAULT 0,
+    mode VARCHAR(16) NOT NULL DEFAULT 'append'
 );
 
+CREATE INDEX idx_lists_owner_key_id ON lists (owner_key_id);
+
 CREATE TABLE pages (
     id SERIAL PRIMARY KEY,
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
@@ -19,6 +28,7 @@ CREATE TABLE pages (
     deleted_at TIMESTAMP WITH TIME ZONE,
     list_id INTEGER REFERENCES lists(id),
     next_page_id INTEGER,
+    version INTEGER NOT NULL DEFAULT 1,
     UNIQUE (list_id, id)
 );
 
@@ -30,9 +40,137 @@ CREATE TABLE articles (
     title VARCHAR(255),
     author VARCHAR(255),
     content TEXT,
+    score DOUBLE PRECISION NOT NULL DEFAULT 0,
     page_id INTEGER REFERENCES pages(id)
 );
 
+CREATE INDEX idx_pages_list_id ON pages (list_id);
+CREATE INDEX idx_articles_page_id ON articles (page_id);
+CREATE INDEX idx_articles_score ON articles (score);
+
+CREATE INDEX idx_articles_search ON articles USING GIN (
+    to_tsvector('english', coalesce(articles.title, '') || ' ' || coalesce(articles.author, '') || ' ' || coalesce(articles.content, ''))
+);
+
+CREATE TABLE idempotency_keys (
+    key VARCHAR(255) PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    fingerprint VARCHAR(64),
+    status_code INTEGER,
+    content_type VARCHAR(255),
+    body BYTEA
+);
+
+CREATE TABLE changes (
+    seq BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    type VARCHAR(32),
+    list_id INTEGER,
+    page_id INTEGER,
+    data TEXT
+);
+
+CREATE INDEX idx_changes_list_id ON changes (list_id);
+
+CREATE TABLE webhooks (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    list_id INTEGER,
+    url TEXT,
+    secret VARCHAR(255),
+    event_types VARCHAR(255),
+    after_seq BIGINT
+);
+
+CREATE INDEX idx_webhooks_list_id ON webhooks (list_id);
+
+CREATE TABLE webhook_deliveries (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    webhook_id INTEGER,
+    change_seq BIGINT,
+    event_type VARCHAR(32),
+    payload TEXT,
+    status VARCHAR(16),
+    attempts INTEGER,
+    next_attempt_at TIMESTAMP WITH TIME ZONE,
+    last_status_code INTEGER,
+    last_error TEXT
+);
+
+CREATE INDEX idx_webhook_deliveries_webhook_id ON webhook_deliveries (webhook_id);
+CREATE INDEX idx_webhook_deliveries_status ON webhook_deliveries (status);
+
+CREATE TABLE publish_jobs (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    subject VARCHAR(255),
+    title VARCHAR(255),
+    author VARCHAR(255),
+    content TEXT,
+    status VARCHAR(16),
+    total INTEGER,
+    succeeded INTEGER,
+    failed INTEGER
+);
+
+CREATE TABLE publish_targets (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    job_id INTEGER,
+    list_id INTEGER,
+    status VARCHAR(16),
+    page_id INTEGER,
+    error TEXT
+);
+
+CREATE INDEX idx_publish_targets_job_id ON publish_targets (job_id);
+CREATE INDEX idx_publish_targets_status ON publish_targets (status);
+
+CREATE TABLE list_groups (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    subject VARCHAR(255)
+);
+
+CREATE TABLE list_group_members (
+    group_id INTEGER,
+    list_id INTEGER,
+    PRIMARY KEY (group_id, list_id)
+);
+
+CREATE TABLE dispatch_cursors (
+    name VARCHAR(64) PRIMARY KEY,
+    seq BIGINT
+);
+
+CREATE TABLE api_keys (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    name VARCHAR(255),
+    prefix VARCHAR(16),
+    hash VARCHAR(64),
+    scopes VARCHAR(64),
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE UNIQUE INDEX idx_api_keys_hash ON api_keys (hash);
+
+CREATE TABLE list_grants (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    list_id INTEGER,
+    api_key_id INTEGER,
+    access VARCHAR(16)
+);
+
+CREATE UNIQUE INDEX idx_list_grants_list_key ON list_grants (list_id, api_key_id);
+
 INSERT INTO lists (id, next_page_id)
 SELECT 1, 1
 WHERE NOT EXISTS (SELECT 1 FROM lists WHERE id = 1);
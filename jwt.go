@@ -0,0 +1,302 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// jwtKey verifies the signatures of JWTs with one algorithm.
+type jwtKey struct {
+	// ID is matched against the kid header of tokens, if both are set
+	ID  string
+	Alg string
+	// Secret is the key of HS256, PublicKey the key of RS256
+	Secret    []byte
+	PublicKey *rsa.PublicKey
+}
+
+// jwtKeys verify the JWTs sent as bearer tokens. JWTs are not accepted if
+// there are none.
+var jwtKeys []jwtKey
+
+// jwtIssuer and jwtAudience, if set, must match the iss and aud claims of
+// tokens.
+var (
+	jwtIssuer   string
+	jwtAudience string
+)
+
+// jwtLeeway allows for clock skew when checking the times in tokens.
+var jwtLeeway = time.Minute
+
+// jwtAudienceClaim is the aud claim, which is a string or an array.
+type jwtAudienceClaim []string
+
+func (a *jwtAudienceClaim) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = []string{s}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(a))
+}
+
+// jwtClaims are the claims of a token that are checked or mapped to
+// authorization decisions.
+type jwtClaims struct {
+	Subject   string           `json:"sub"`
+	Issuer    string           `json:"iss"`
+	Audience  jwtAudienceClaim `json:"aud"`
+	ExpiresAt *int64           `json:"exp"`
+	NotBefore *int64           `json:"nbf"`
+	// Scope is the space separated scopes of the token, among read, write
+	// and admin
+	Scope string `json:"scope"`
+	// Lists are the IDs of the Lists the token opens
+	Lists []uint `json:"lists"`
+	// KeyID binds the token to an API key, whose Lists and grants it can
+	// then use within its scopes
+	KeyID uint `json:"key_id"`
+}
+
+// loadJWTKeys reads the keys in a file, which holds a JWKS, a PEM encoded
+// RSA public key for RS256, or a secret for HS256.
+func loadJWTKeys(path string) ([]jwtKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	trimmed := strings.TrimSpace(string(b))
+	switch {
+	case strings.HasPrefix(trimmed, "{"):
+		return parseJWKS([]byte(trimmed))
+	case strings.HasPrefix(trimmed, "-----BEGIN"):
+		block, _ := pem.Decode([]byte(trimmed))
+		if block == nil {
+			return nil, fmt.Errorf("invalid PEM block in %s", path)
+		}
+		publicKey, err := parseRSAPublicKey(block)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA public key in %s: %v", path, err)
+		}
+		return []jwtKey{{Alg: "RS256", PublicKey: publicKey}}, nil
+	case trimmed == "":
+		return nil, fmt.Errorf("no key in %s", path)
+	default:
+		return []jwtKey{{Alg: "HS256", Secret: []byte(trimmed)}}, nil
+	}
+}
+
+func parseRSAPublicKey(block *pem.Block) (*rsa.PublicKey, error) {
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not an RSA key")
+	}
+	return publicKey, nil
+}
+
+// parseJWKS reads the signing keys of a JSON Web Key Set. Keys of other
+// types or uses are skipped.
+func parseJWKS(b []byte) ([]jwtKey, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			Alg string `json:"alg"`
+			K   string `json:"k"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %v", err)
+	}
+
+	var keys []jwtKey
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch {
+		case k.Kty == "oct" && (k.Alg == "" || k.Alg == "HS256"):
+			secret, err := base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil || len(secret) == 0 {
+				return nil, fmt.Errorf("invalid JWKS: key %q has an invalid k", k.Kid)
+			}
+			keys = append(keys, jwtKey{ID: k.Kid, Alg: "HS256", Secret: secret})
+		case k.Kty == "RSA" && (k.Alg == "" || k.Alg == "RS256"):
+			n, err := base64.RawURLEncoding.DecodeString(k.N)
+			if err != nil || len(n) == 0 {
+				return nil, fmt.Errorf("invalid JWKS: key %q has an invalid n", k.Kid)
+			}
+			e, err := base64.RawURLEncoding.DecodeString(k.E)
+			if err != nil || len(e) == 0 || len(e) > 4 {
+				return nil, fmt.Errorf("invalid JWKS: key %q has an invalid e", k.Kid)
+			}
+			keys = append(keys, jwtKey{ID: k.Kid, Alg: "RS256", PublicKey: &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}})
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("invalid JWKS: no HS256 or RS256 signing key")
+	}
+	return keys, nil
+}
+
+// verify checks the signature of the signed part of a token.
+func (key *jwtKey) verify(signed string, signature []byte) bool {
+	switch key.Alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, key.Secret)
+		mac.Write([]byte(signed))
+		return hmac.Equal(signature, mac.Sum(nil))
+	case "RS256":
+		digest := sha256.Sum256([]byte(signed))
+		return rsa.VerifyPKCS1v15(key.PublicKey, crypto.SHA256, digest[:], signature) == nil
+	}
+	return false
+}
+
+// verifyJWT checks the signature, times, issuer and audience of a compact
+// JWT and decodes its claims. Only keys of the algorithm named in the token
+// are tried, so a token cannot make an RSA public key be used as an HMAC
+// secret.
+func verifyJWT(token string, keys []jwtKey, now time.Time, claims *jwtClaims) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("malformed token")
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return errors.New("malformed token header")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return errors.New("malformed token header")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return errors.New("malformed token signature")
+	}
+
+	verified := false
+	for i := range keys {
+		key := &keys[i]
+		if key.Alg != header.Alg || (header.Kid != "" && key.ID != "" && key.ID != header.Kid) {
+			continue
+		}
+		if key.verify(parts[0]+"."+parts[1], signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return errors.New("invalid token signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return errors.New("malformed token payload")
+	}
+	*claims = jwtClaims{}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return errors.New("malformed token payload")
+	}
+
+	if claims.ExpiresAt == nil {
+		return errors.New("token has no expiry")
+	}
+	if now.After(time.Unix(*claims.ExpiresAt, 0).Add(jwtLeeway)) {
+		return errors.New("token expired")
+	}
+	if claims.NotBefore != nil && now.Add(jwtLeeway).Before(time.Unix(*claims.NotBefore, 0)) {
+		return errors.New("token not valid yet")
+	}
+	if jwtIssuer != "" && claims.Issuer != jwtIssuer {
+		return errors.New("token has the wrong issuer")
+	}
+	if jwtAudience != "" {
+		found := false
+		for _, aud := range claims.Audience {
+			found = found || aud == jwtAudience
+		}
+		if !found {
+			return errors.New("token has the wrong audience")
+		}
+	}
+	return nil
+}
+
+// jwtPrincipal verifies a JWT and maps its claims to a principal. A token
+// bound to an API key only keeps the scopes the key has, and fails once the
+// key is revoked.
+func jwtPrincipal(token string) (*principal, error) {
+	var claims jwtClaims
+	if err := verifyJWT(token, jwtKeys, time.Now(), &claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnauthorized, err)
+	}
+	p := &principal{Subject: claims.Subject, Lists: make(map[uint]bool)}
+	if claims.Scope != "" {
+		scopes, err := parseScopes(strings.Join(strings.Fields(claims.Scope), ","))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errUnauthorized, err)
+		}
+		p.Scopes = scopes
+	}
+	if p.Subject == "" {
+		p.Subject = "token"
+	}
+	for _, listID := range claims.Lists {
+		p.Lists[listID] = true
+	}
+
+	if claims.KeyID != 0 {
+		var key APIKey
+		if err := getAPIKeyByID(db, claims.KeyID, &key); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, fmt.Errorf("%w: unknown key_id", errUnauthorized)
+			}
+			return nil, err
+		}
+		if key.RevokedAt != nil {
+			return nil, fmt.Errorf("%w: revoked key_id", errUnauthorized)
+		}
+		keyPrincipal := apiKeyPrincipal(&key)
+		var kept []string
+		for _, scope := range p.Scopes {
+			if keyPrincipal.hasScope(scope) {
+				kept = append(kept, scope)
+			}
+		}
+		p.Scopes, p.Key = kept, &key
+	}
+	return p, nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testHMACSecret = []byte("test-secret-that-is-long-enough")
+
+// signJWT signs claims into a compact JWT. The key is a []byte for HS256 or
+// an *rsa.PrivateKey for RS256.
+func signJWT(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	t.Helper()
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	headerJSON, _ := json.Marshal(header)
+	claimsJSON, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+
+	var signature []byte
+	switch alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, key.([]byte))
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case "RS256":
+		digest := sha256.Sum256([]byte(signed))
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatalf("Error signing token: %v", err)
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// validClaims are claims that pass verification, for tests to change.
+func validClaims(extra map[string]interface{}) map[string]interface{} {
+	claims := map[string]interface{}{
+		"sub":   "gateway-user",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"scope": "read write",
+	}
+	for name, value := range extra {
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+	}
+	return claims
+}
+
+// useJWTKeys configures the keys, issuer and audience JWTs are verified with
+// for the duration of a test.
+func useJWTKeys(t *testing.T, keys []jwtKey, issuer, audience string) {
+	t.Helper()
+	jwtKeys, jwtIssuer, jwtAudience = keys, issuer, audience
+	t.Cleanup(func() { jwtKeys, jwtIssuer, jwtAudience = nil, "", "" })
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Error writing %s: %v", name, err)
+	}
+	return path
+}
+
+func TestLoadJWTKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating RSA key: %v", err)
+	}
+	pkix, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "oct", "kid": "hmac", "alg": "HS256", "k": base64.RawURLEncoding.EncodeToString(testHMACSecret)},
+		{"kty": "RSA", "kid": "rsa", "use": "sig", "n": base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AQAB", "e": "AQAB"},
+	}})
+
+	testCases := []struct {
+		name     string
+		content  string
+		expected []string
+		err      bool
+	}{
+		{"JWKS", string(jwks), []string{"HS256 hmac", "RS256 rsa"}, false},
+		{"PKIX PEM", string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pkix})), []string{"RS256 "}, false},
+		{"PKCS1 PEM", string(pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)})), []string{"RS256 "}, false},
+		{"Secret", string(testHMACSecret) + "\n", []string{"HS256 "}, false},
+		{"Empty", "\n", nil, true},
+		{"JWKS without signing keys", `{"keys": [{"kty": "EC"}]}`, nil, true},
+		{"Invalid PEM", "-----BEGIN PUBLIC KEY-----\nnot base64\n", nil, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			keys, err := loadJWTKeys(writeFile(t, "keys", tc.content))
+			if tc.err {
+				if err == nil {
+					t.Errorf("Expected an error, got %+v", keys)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			var got []string
+			for _, key := range keys {
+				got = append(got, key.Alg+" "+key.ID)
+			}
+			if strings.Join(got, ",") != strings.Join(tc.expected, ",") {
+				t.Errorf("Expected keys %v but got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestVerifyJWT(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating RSA key: %v", err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating RSA key: %v", err)
+	}
+	pkix, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pkix})
+
+	keys := []jwtKey{
+		{ID: "hmac", Alg: "HS256", Secret: testHMACSecret},
+		{ID: "rsa", Alg: "RS256", PublicKey: &rsaKey.PublicKey},
+	}
+	rsaOnly := []jwtKey{{Alg: "RS256", PublicKey: &rsaKey.PublicKey}}
+	now := time.Now()
+
+	testCases := []struct {
+		name     string
+		keys     []jwtKey
+		token    string
+		issuer   string
+		audience string
+		expected string
+	}{
+		{"HS256", keys, signJWT(t, "HS256", "hmac", testHMACSecret, validClaims(nil)), "", "", ""},
+		{"RS256", keys, signJWT(t, "RS256", "rsa", rsaKey, validClaims(nil)), "", "", ""},
+		{"No kid", keys, signJWT(t, "RS256", "", rsaKey, validClaims(nil)), "", "", ""},
+		{"Unknown kid", keys, signJWT(t, "HS256", "other", testHMACSecret, validClaims(nil)), "", "", "invalid token signature"},
+		{"Wrong secret", keys, signJWT(t, "HS256", "hmac", []byte("wrong"), validClaims(nil)), "", "", "invalid token signature"},
+		{"Wrong RSA key", keys, signJWT(t, "RS256", "rsa", otherKey, validClaims(nil)), "", "", "invalid token signature"},
+		{"Public key as HMAC secret", rsaOnly, signJWT(t, "HS256", "", pemBytes, validClaims(nil)), "", "", "invalid token signature"},
+		{"Alg none", keys, strings.TrimSuffix(signJWT(t, "none", "", nil, validClaims(nil)), "."), "", "", "malformed token"},
+		{"Unsigned", keys, signJWT(t, "none", "", nil, validClaims(nil)), "", "", "invalid token signature"},
+		{"Malformed", keys, "a.b.c", "", "", "malformed token header"},
+		{"Expired", keys, signJWT(t, "HS256", "", testHMACSecret, validClaims(map[string]interface{}{"exp": now.Add(-2 * time.Minute).Unix()})), "", "", "token expired"},
+		{"Expired within leeway", keys, signJWT(t, "HS256", "", testHMACSecret, validClaims(map[string]interface{}{"exp": now.Add(-30 * time.Second).Unix()})), "", "", ""},
+		{"No expiry", keys, signJWT(t, "HS256", "", testHMACSecret, validClaims(map[string]interface{}{"exp": nil})), "", "", "token has no expiry"},
+		{"Not valid yet", keys, signJWT(t, "HS256", "", testHMACSecret, validClaims(map[string]interface{}{"nbf": now.Add(time.Hour).Unix()})), "", "", "token not valid yet"},
+		{"Issuer", keys, signJWT(t, "HS256", "", testHMACSecret, validClaims(map[string]interface{}{"iss": "gateway"})), "gateway", "", ""},
+		{"Wrong issuer", keys, signJWT(t, "HS256", "", testHMACSecret, validClaims(map[string]interface{}{"iss": "other"})), "gateway", "", "token has the wrong issuer"},
+		{"Audience string", keys, signJWT(t, "HS256", "", testHMACSecret, validClaims(map[string]interface{}{"aud": "lists"})), "", "lists", ""},
+		{"Audience array", keys, signJWT(t, "HS256", "", testHMACSecret, validClaims(map[string]interface{}{"aud": []string{"other", "lists"}})), "", "lists", ""},
+		{"Wrong audience", keys, signJWT(t, "HS256", "", testHMACSecret, validClaims(map[string]interface{}{"aud": "other"})), "", "lists", "token has the wrong audience"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			useJWTKeys(t, tc.keys, tc.issuer, tc.audience)
+			var claims jwtClaims
+			err := verifyJWT(tc.token, tc.keys, now, &claims)
+			if tc.expected == "" {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				} else if claims.Subject != "gateway-user" {
+					t.Errorf("Expected subject gateway-user but got %q", claims.Subject)
+				}
+				return
+			}
+			if err == nil || err.Error() != tc.expected {
+				t.Errorf("Expected error %q but got %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+func TestJWTAuthorization(t *testing.T) {
+	useTestDB(t)
+	useAuth(t)
+	useJWTKeys(t, []jwtKey{{Alg: "HS256", Secret: testHMACSecret}}, "", "")
+	router := newRouter()
+
+	_, ownerKey := mintAPIKey(t, "owner", ScopeRead, ScopeWrite)
+	_, readerKey := mintAPIKey(t, "reader", ScopeRead)
+	_, revokedKey := mintAPIKey(t, "revoked", ScopeRead)
+	if err := revokeAPIKey(db, revokedKey.ID); err != nil {
+		t.Fatalf("Error revoking API key: %v", err)
+	}
+	for _, listID := range []uint{5, 6} {
+		if err := claimList(db, listID, ownerKey.ID); err != nil {
+			t.Fatalf("Error claiming list: %v", err)
+		}
+	}
+	if err := saveListGrant(db, &ListGrant{ListID: 6, APIKeyID: readerKey.ID, Access: ScopeRead}); err != nil {
+		t.Fatalf("Error saving grant: %v", err)
+	}
+
+	token := func(extra map[string]interface{}) string {
+		return signJWT(t, "HS256", "", testHMACSecret, validClaims(extra))
+	}
+	expect := func(token, method, url, body string, code int, what string) {
+		t.Helper()
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != code {
+			t.Errorf("%s: expected status code %d but got %d: %s", what, code, rec.Code, rec.Body.String())
+		}
+	}
+	article := `{"title": "Title", "author": "Author", "content": "Content"}`
+
+	// The lists claim opens the Lists it names, within the scopes of the token
+	lists := token(map[string]interface{}{"lists": []uint{5}})
+	expect(lists, "GET", "/v1/lists/5", "", http.StatusOK, "token reads a list it names")
+	expect(lists, "POST", "/v1/lists/5/items", article, http.StatusCreated, "token appends to a list it names")
+	expect(lists, "GET", "/v1/lists/6", "", http.StatusForbidden, "token reads another list")
+	expect(lists, "GET", "/v1/lists/5/grants", "", http.StatusForbidden, "token lists grants")
+	readOnly := token(map[string]interface{}{"lists": []uint{5}, "scope": "read"})
+	expect(readOnly, "POST", "/v1/lists/5/items", article, http.StatusForbidden, "read token appends")
+	noScope := token(map[string]interface{}{"lists": []uint{5}, "scope": nil})
+	expect(noScope, "GET", "/v1/lists/5", "", http.StatusForbidden, "token without scopes reads")
+
+	// The admin scope opens everything
+	admin := token(map[string]interface{}{"scope": "admin"})
+	expect(admin, "GET", "/v1/lists/6", "", http.StatusOK, "admin token reads")
+	expect(admin, "GET", "/changes", "", http.StatusOK, "admin token reads every change")
+
+	// A token bound to a key uses the Lists and grants of the key, and only
+	// the scopes the key has
+	bound := token(map[string]interface{}{"key_id": readerKey.ID})
+	expect(bound, "GET", "/v1/lists/6", "", http.StatusOK, "bound token reads a granted list")
+	expect(bound, "GET", "/v1/lists/5", "", http.StatusForbidden, "bound token reads a list without a grant")
+	expect(bound, "POST", "/v1/lists/6/items", article, http.StatusForbidden, "bound token writes beyond the key scopes")
+	expect(token(map[string]interface{}{"key_id": ownerKey.ID}), "GET", "/v1/lists/5/grants", "", http.StatusOK, "token bound to the owner lists grants")
+	expect(token(map[string]interface{}{"key_id": revokedKey.ID}), "GET", "/v1/lists/6", "", http.StatusUnauthorized, "token bound to a revoked key")
+	expect(token(map[string]interface{}{"key_id": 999}), "GET", "/v1/lists/6", "", http.StatusUnauthorized, "token bound to an unknown key")
+
+	// Invalid tokens are rejected rather than looked up as API keys
+	expect(token(map[string]interface{}{"scope": "root"}), "GET", "/v1/lists/5", "", http.StatusUnauthorized, "token with an unknown scope")
+	expect(token(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}), "GET", "/v1/lists/5", "", http.StatusUnauthorized, "expired token")
+	expect(signJWT(t, "HS256", "", []byte("wrong"), validClaims(nil)), "GET", "/v1/lists/5", "", http.StatusUnauthorized, "forged token")
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const commandUsage = `usage:
+  key-value-list [flags] keys create -name <name> -scopes <read,write,admin>
+  key-value-list [flags] keys list
+  key-value-list [flags] keys revoke <id>`
+
+// runCommand runs the admin command given after the flags of the server,
+// writing its output to out.
+func runCommand(args []string, out io.Writer) error {
+	if len(args) < 2 || args[0] != "keys" {
+		return errors.New(commandUsage)
+	}
+
+	switch args[1] {
+	case "create":
+		fs := flag.NewFlagSet("keys create", flag.ContinueOnError)
+		fs.SetOutput(out)
+		name := fs.String("name", "", "Name of the key, to tell what it is used for")
+		scopesStr := fs.String("scopes", ScopeRead, "Comma separated scopes of the key, among read, write and admin")
+		if err := fs.Parse(args[2:]); err != nil {
+			return err
+		}
+		scopes, err := parseScopes(*scopesStr)
+		if err != nil {
+			return err
+		}
+
+		var apiKey APIKey
+		key, err := createAPIKey(db, *name, scopes, &apiKey)
+		if err != nil {
+			return fmt.Errorf("error creating API key: %v", err)
+		}
+		fmt.Fprintf(out, "Created API key %d with scopes %s. It is not shown again:\n%s\n", apiKey.ID, apiKey.Scopes, key)
+		return nil
+
+	case "list":
+		var apiKeys []APIKey
+		if err := getAPIKeys(db, &apiKeys); err != nil {
+			return fmt.Errorf("error fetching API keys: %v", err)
+		}
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tPREFIX\tSCOPES\tCREATED\tREVOKED")
+		for _, apiKey := range apiKeys {
+			revoked := "-"
+			if apiKey.RevokedAt != nil {
+				revoked = apiKey.RevokedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", apiKey.ID, apiKey.Name, apiKey.Prefix, apiKey.Scopes, apiKey.CreatedAt.Format(time.RFC3339), revoked)
+		}
+		return tw.Flush()
+
+	case "revoke":
+		if len(args) != 3 {
+			return errors.New(commandUsage)
+		}
+		id, err := strconv.Atoi(args[2])
+		if err != nil || id <= 0 {
+			return fmt.Errorf("key id is not a valid integer")
+		}
+		if err := revokeAPIKey(db, uint(id)); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Revoked API key %d\n", id)
+		return nil
+	}
+	return errors.New(commandUsage)
+}
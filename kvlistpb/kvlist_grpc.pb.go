@@ -0,0 +1,336 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v4.25.1
+// source: kvlist.proto
+
+package kvlistpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	KeyValueList_GetHead_FullMethodName      = "/kvlist.v1.KeyValueList/GetHead"
+	KeyValueList_GetPage_FullMethodName      = "/kvlist.v1.KeyValueList/GetPage"
+	KeyValueList_Append_FullMethodName       = "/kvlist.v1.KeyValueList/Append"
+	KeyValueList_UpdatePage_FullMethodName   = "/kvlist.v1.KeyValueList/UpdatePage"
+	KeyValueList_DeleteList_FullMethodName   = "/kvlist.v1.KeyValueList/DeleteList"
+	KeyValueList_TraverseList_FullMethodName = "/kvlist.v1.KeyValueList/TraverseList"
+)
+
+// KeyValueListClient is the client API for KeyValueList service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type KeyValueListClient interface {
+	// GetHead returns the ID of the first page of a list.
+	GetHead(ctx context.Context, in *GetHeadRequest, opts ...grpc.CallOption) (*GetHeadResponse, error)
+	// GetPage returns a page and its articles.
+	GetPage(ctx context.Context, in *GetPageRequest, opts ...grpc.CallOption) (*Page, error)
+	// Append adds an article to the last page of a list.
+	Append(ctx context.Context, in *AppendRequest, opts ...grpc.CallOption) (*AppendResponse, error)
+	// UpdatePage replaces the articles of a page.
+	UpdatePage(ctx context.Context, in *UpdatePageRequest, opts ...grpc.CallOption) (*Page, error)
+	// DeleteList deletes all pages and articles of a list.
+	DeleteList(ctx context.Context, in *DeleteListRequest, opts ...grpc.CallOption) (*DeleteListResponse, error)
+	// TraverseList streams every page of a list, from the head along the
+	// next page links.
+	TraverseList(ctx context.Context, in *TraverseListRequest, opts ...grpc.CallOption) (KeyValueList_TraverseListClient, error)
+}
+
+type keyValueListClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewKeyValueListClient(cc grpc.ClientConnInterface) KeyValueListClient {
+	return &keyValueListClient{cc}
+}
+
+func (c *keyValueListClient) GetHead(ctx context.Context, in *GetHeadRequest, opts ...grpc.CallOption) (*GetHeadResponse, error) {
+	out := new(GetHeadResponse)
+	err := c.cc.Invoke(ctx, KeyValueList_GetHead_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *keyValueListClient) GetPage(ctx context.Context, in *GetPageRequest, opts ...grpc.CallOption) (*Page, error) {
+	out := new(Page)
+	err := c.cc.Invoke(ctx, KeyValueList_GetPage_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *keyValueListClient) Append(ctx context.Context, in *AppendRequest, opts ...grpc.CallOption) (*AppendResponse, error) {
+	out := new(AppendResponse)
+	err := c.cc.Invoke(ctx, KeyValueList_Append_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *keyValueListClient) UpdatePage(ctx context.Context, in *UpdatePageRequest, opts ...grpc.CallOption) (*Page, error) {
+	out := new(Page)
+	err := c.cc.Invoke(ctx, KeyValueList_UpdatePage_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *keyValueListClient) DeleteList(ctx context.Context, in *DeleteListRequest, opts ...grpc.CallOption) (*DeleteListResponse, error) {
+	out := new(DeleteListResponse)
+	err := c.cc.Invoke(ctx, KeyValueList_DeleteList_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *keyValueListClient) TraverseList(ctx context.Context, in *TraverseListRequest, opts ...grpc.CallOption) (KeyValueList_TraverseListClient, error) {
+	stream, err := c.cc.NewStream(ctx, &KeyValueList_ServiceDesc.Streams[0], KeyValueList_TraverseList_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &keyValueListTraverseListClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type KeyValueList_TraverseListClient interface {
+	Recv() (*Page, error)
+	grpc.ClientStream
+}
+
+type keyValueListTraverseListClient struct {
+	grpc.ClientStream
+}
+
+func (x *keyValueListTraverseListClient) Recv() (*Page, error) {
+	m := new(Page)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// KeyValueListServer is the server API for KeyValueList service.
+// All implementations must embed UnimplementedKeyValueListServer
+// for forward compatibility
+type KeyValueListServer interface {
+	// GetHead returns the ID of the first page of a list.
+	GetHead(context.Context, *GetHeadRequest) (*GetHeadResponse, error)
+	// GetPage returns a page and its articles.
+	GetPage(context.Context, *GetPageRequest) (*Page, error)
+	// Append adds an article to the last page of a list.
+	Append(context.Context, *AppendRequest) (*AppendResponse, error)
+	// UpdatePage replaces the articles of a page.
+	UpdatePage(context.Context, *UpdatePageRequest) (*Page, error)
+	// DeleteList deletes all pages and articles of a list.
+	DeleteList(context.Context, *DeleteListRequest) (*DeleteListResponse, error)
+	// TraverseList streams every page of a list, from the head along the
+	// next page links.
+	TraverseList(*TraverseListRequest, KeyValueList_TraverseListServer) error
+	mustEmbedUnimplementedKeyValueListServer()
+}
+
+// UnimplementedKeyValueListServer must be embedded to have forward compatible implementations.
+type UnimplementedKeyValueListServer struct {
+}
+
+func (UnimplementedKeyValueListServer) GetHead(context.Context, *GetHeadRequest) (*GetHeadResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetHead not implemented")
+}
+func (UnimplementedKeyValueListServer) GetPage(context.Context, *GetPageRequest) (*Page, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPage not implemented")
+}
+func (UnimplementedKeyValueListServer) Append(context.Context, *AppendRequest) (*AppendResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Append not implemented")
+}
+func (UnimplementedKeyValueListServer) UpdatePage(context.Context, *UpdatePageRequest) (*Page, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdatePage not implemented")
+}
+func (UnimplementedKeyValueListServer) DeleteList(context.Context, *DeleteListRequest) (*DeleteListResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteList not implemented")
+}
+func (UnimplementedKeyValueListServer) TraverseList(*TraverseListRequest, KeyValueList_TraverseListServer) error {
+	return status.Errorf(codes.Unimplemented, "method TraverseList not implemented")
+}
+func (UnimplementedKeyValueListServer) mustEmbedUnimplementedKeyValueListServer() {}
+
+// UnsafeKeyValueListServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to KeyValueListServer will
+// result in compilation errors.
+type UnsafeKeyValueListServer interface {
+	mustEmbedUnimplementedKeyValueListServer()
+}
+
+func RegisterKeyValueListServer(s grpc.ServiceRegistrar, srv KeyValueListServer) {
+	s.RegisterService(&KeyValueList_ServiceDesc, srv)
+}
+
+func _KeyValueList_GetHead_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetHeadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyValueListServer).GetHead(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KeyValueList_GetHead_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyValueListServer).GetHead(ctx, req.(*GetHeadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KeyValueList_GetPage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyValueListServer).GetPage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KeyValueList_GetPage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyValueListServer).GetPage(ctx, req.(*GetPageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KeyValueList_Append_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AppendRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyValueListServer).Append(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KeyValueList_Append_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyValueListServer).Append(ctx, req.(*AppendRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KeyValueList_UpdatePage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdatePageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyValueListServer).UpdatePage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KeyValueList_UpdatePage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyValueListServer).UpdatePage(ctx, req.(*UpdatePageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KeyValueList_DeleteList_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyValueListServer).DeleteList(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KeyValueList_DeleteList_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyValueListServer).DeleteList(ctx, req.(*DeleteListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KeyValueList_TraverseList_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(TraverseListRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(KeyValueListServer).TraverseList(m, &keyValueListTraverseListServer{stream})
+}
+
+type KeyValueList_TraverseListServer interface {
+	Send(*Page) error
+	grpc.ServerStream
+}
+
+type keyValueListTraverseListServer struct {
+	grpc.ServerStream
+}
+
+func (x *keyValueListTraverseListServer) Send(m *Page) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// KeyValueList_ServiceDesc is the grpc.ServiceDesc for KeyValueList service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var KeyValueList_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "kvlist.v1.KeyValueList",
+	HandlerType: (*KeyValueListServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetHead",
+			Handler:    _KeyValueList_GetHead_Handler,
+		},
+		{
+			MethodName: "GetPage",
+			Handler:    _KeyValueList_GetPage_Handler,
+		},
+		{
+			MethodName: "Append",
+			Handler:    _KeyValueList_Append_Handler,
+		},
+		{
+			MethodName: "UpdatePage",
+			Handler:    _KeyValueList_UpdatePage_Handler,
+		},
+		{
+			MethodName: "DeleteList",
+			Handler:    _KeyValueList_DeleteList_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "TraverseList",
+			Handler:       _KeyValueList_TraverseList_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "kvlist.proto",
+}
@@ -1,6 +1,11 @@
 package main
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
 
 func getListByID(db *gorm.DB, id uint, list *List) error {
 	if err := db.Table("lists").First(list, id).Error; err != nil {
@@ -9,7 +14,78 @@ func getListByID(db *gorm.DB, id uint, list *List) error {
 	return nil
 }
 
+// GetListForUpdate gets the List and locks it until the end of the
+// transaction, so that writes to the same List are applied one at a time.
+func getListForUpdate(db *gorm.DB, id uint, list *List) error {
+	return db.Clauses(clause.Locking{Strength: "UPDATE"}).Table("lists").First(list, id).Error
+}
+
+// CountListsByOwner counts the Lists owned by the API key.
+func countListsByOwner(db *gorm.DB, keyID uint, count *int64) error {
+	return db.Table("lists").Where("owner_key_id = ?", keyID).Count(count).Error
+}
+
 // CreatePage creates a new Page in the database.
 func createList(db *gorm.DB, list *List) error {
 	return db.Table("lists").Create(list).Error
 }
+
+// IncrementListVersion bumps the version of the List after it has changed.
+func incrementListVersion(db *gorm.DB, listID uint) error {
+	return db.Table("lists").Where("id = ?", listID).UpdateColumns(map[string]interface{}{"version": gorm.Expr("version + 1"), "updated_at": time.Now()}).Error
+}
+
+// BumpListVersion moves the List from the version it was loaded with to the
+// next one. It returns errVersionConflict if the List has been changed since.
+func bumpListVersion(db *gorm.DB, list *List) error {
+	now := time.Now()
+	res := db.Table("lists").Where("id = ? AND version = ?", list.ID, list.Version).UpdateColumns(map[string]interface{}{"version": list.Version + 1, "updated_at": now})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errVersionConflict
+	}
+	list.Version++
+	list.UpdatedAt = now
+	return nil
+}
+
+// UpdateListNextPageID points the List at its new head Page.
+func updateListNextPageID(db *gorm.DB, list *List, pageID uint) error {
+	if err := db.Model(list).UpdateColumn("next_page_id", pageID).Error; err != nil {
+		return err
+	}
+	list.NextPageID = pageID
+	return nil
+}
+
+// UpdateListExpiresAt sets the time at which the List expires, or clears it
+// if expiresAt is nil.
+func updateListExpiresAt(db *gorm.DB, list *List, expiresAt *time.Time) error {
+	if err := db.Model(list).UpdateColumn("expires_at", expiresAt).Error; err != nil {
+		return err
+	}
+	list.ExpiresAt = expiresAt
+	return nil
+}
+
+// SaveListSettings saves the caps and the mode of the List.
+func saveListSettings(db *gorm.DB, list *List) error {
+	return db.Model(list).UpdateColumns(map[string]interface{}{"max_items": list.MaxItems, "max_pages": list.MaxPages, "mode": list.Mode}).Error
+}
+
+// IncrementListGeneration starts a new generation of the List after its pages
+// have been thrown away.
+func incrementListGeneration(db *gorm.DB, list *List) error {
+	if err := db.Model(list).UpdateColumn("generation", gorm.Expr("generation + 1")).Error; err != nil {
+		return err
+	}
+	list.Generation++
+	return nil
+}
+
+// GetExpiredLists gets the Lists that expired before the given time.
+func getExpiredLists(db *gorm.DB, now time.Time, lists *[]List) error {
+	return db.Table("lists").Where("expires_at IS NOT NULL AND expires_at <= ?", now).Find(lists).Error
+}
@@ -72,3 +72,35 @@ func TestCreateList(t *testing.T) {
 		t.Errorf("Retrieved list ID (%d) does not match the original list ID (%d)", retrievedList.ID, list.ID)
 	}
 }
+
+func TestUpdateListNextPageID(t *testing.T) {
+	// Create an in-memory SQLite database for testing
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to connect to the database: %v", err)
+	}
+
+	// Automatically create the "lists" table in the database
+	err = db.AutoMigrate(&List{})
+	if err != nil {
+		t.Fatalf("Failed to migrate the database: %v", err)
+	}
+
+	list := &List{ID: 1, NextPageID: 1}
+	if err := createList(db, list); err != nil {
+		t.Fatalf("Failed to create a new list: %v", err)
+	}
+
+	// Point the list at a new head page
+	if err := updateListNextPageID(db, list, 5); err != nil {
+		t.Fatalf("Failed to update the head of the list: %v", err)
+	}
+
+	var retrievedList List
+	if err := getListByID(db, 1, &retrievedList); err != nil {
+		t.Fatalf("Failed to retrieve the list by ID: %v", err)
+	}
+	if retrievedList.NextPageID != 5 || list.NextPageID != 5 {
+		t.Errorf("Expected next page ID 5, got %d in the database and %d in memory", retrievedList.NextPageID, list.NextPageID)
+	}
+}
@@ -2,11 +2,16 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"gorm.io/gorm"
@@ -50,11 +55,21 @@ func initDB(host string, port string, user string, password string, dbname strin
 	}
 
 	// Auto-migrate the schema to create the tables and relationships
-	if err = db.AutoMigrate(&Page{}, &Article{}, &List{}); err != nil {
+	if err = db.AutoMigrate(&Page{}, &Article{}, &List{}, &IdempotencyKey{}, &Change{}, &Webhook{}, &WebhookDelivery{}, &DispatchCursor{}, &APIKey{}, &ListGrant{}, &PublishJob{}, &PublishTarget{}, &ListGroup{}, &ListGroupMember{}); err != nil {
 		// Handle error here
 		log.Fatalf("Error during migration: %v", err)
 	}
 
+	// Index the articles for searching
+	if err = setupSearch(db); err != nil {
+		log.Fatalf("Error setting up search: %v", err)
+	}
+
+	// Drop the stored responses that can no longer be replayed
+	if err = deleteExpiredIdempotencyKeys(db, time.Now().Add(-idempotencyWindow)); err != nil {
+		log.Printf("Error deleting expired idempotency keys: %v", err)
+	}
+
 	// createListIfNotExists()
 
 	// Create same sample articles if there is no data in articles table
@@ -69,31 +84,185 @@ func main() {
 	dbUser := flag.String("dbUser", "myuser", "Database user")
 	dbPassword := flag.String("dbPassword", "mysecretpassword", "Database password")
 	dbName := flag.String("dbName", "my_database", "Database name")
+	grpcPort := flag.Int("grpcPort", 0, "gRPC server port, 0 disables it")
+	respPort := flag.Int("respPort", 0, "Redis protocol (RESP) server port, 0 disables it")
+	eventLogSize := flag.Int("eventLogSize", 1000, "Number of list events retained for clients resuming a stream")
+	webhookInterval := flag.Duration("webhookInterval", time.Second, "How often webhook deliveries are sent, 0 disables them")
+	flag.IntVar(&webhookWorkers, "webhookWorkers", webhookWorkers, "Number of webhooks sent deliveries at once")
+	flag.BoolVar(&allowPrivateWebhooks, "allowPrivateWebhooks", allowPrivateWebhooks, "Let webhooks post to loopback, link-local and private addresses")
+	publishInterval := flag.Duration("publishInterval", time.Second, "How often pending publish targets are looked for, 0 disables publishing")
+	flag.IntVar(&publishWorkers, "publishWorkers", publishWorkers, "Number of lists published to at once")
+	pageCacheSize := flag.Int("pageCacheSize", 1024, "Number of pages kept in the in-process cache, 0 disables it")
+	flag.StringVar(&cacheControl, "cacheControl", cacheControl, "Cache-Control header sent with lists and pages")
+	flag.BoolVar(&authRequired, "requireAuth", authRequired, "Require an API key on every HTTP route but /openapi.json")
+	jwtKeyFile := flag.String("jwtKeyFile", "", "File with the keys that verify JWTs: a JWKS, a PEM RSA public key or an HMAC secret. JWTs are not accepted if empty")
+	flag.StringVar(&jwtIssuer, "jwtIssuer", "", "Issuer JWTs must have, if set")
+	flag.StringVar(&jwtAudience, "jwtAudience", "", "Audience JWTs must have, if set")
+	readRateLimit := flag.Float64("readRateLimit", 100, "Requests a second each client can make to each read route, 0 disables the limit")
+	writeRateLimit := flag.Float64("writeRateLimit", 10, "Requests a second each client can make to each write route, 0 disables the limit")
+	addressRateLimit := flag.Float64("addressRateLimit", 200, "Requests a second each IP address can make to the whole API, counted before authentication, 0 disables the limit")
+	routeRateLimits := flag.String("routeRateLimits", "", "Comma separated limits of single routes, like set=1,appendItemV1=5, in requests a second")
+	flag.Int64Var(&maxListsPerOwner, "maxListsPerOwner", 0, "Most lists one API key can own, 0 is unlimited")
+	flag.Int64Var(&maxPagesPerList, "maxPagesPerList", 0, "Most pages in one list, 0 is unlimited")
+	flag.Int64Var(&maxItemsPerDay, "maxItemsPerDay", 0, "Most items each client can append in a UTC day, 0 is unlimited")
+	flag.Int64Var(&maxBodySize, "maxBodySize", maxBodySize, "Largest request body accepted, in bytes")
+	flag.DurationVar(&idempotencyWindow, "idempotencyWindow", idempotencyWindow, "How long responses to requests with an Idempotency-Key are replayed")
+	secret := flag.String("cursorSecret", "", "Secret that signs pagination cursors, random if empty")
+	flag.DurationVar(&cursorMaxAge, "cursorMaxAge", cursorMaxAge, "How long pagination cursors stay valid")
 	flag.Parse()
 
+	if *secret != "" {
+		cursorSecret = []byte(*secret)
+	}
+	routes, err := parseRouteRateLimits(*routeRateLimits)
+	if err != nil {
+		log.Fatal(err)
+	}
+	limits = newLimiter(newRateLimit(*readRateLimit), newRateLimit(*writeRateLimit), routes)
+	limits.address = newRateLimit(*addressRateLimit)
+	if publishWorkers < 1 {
+		log.Fatal("-publishWorkers must be at least 1")
+	}
+	if webhookWorkers < 1 {
+		log.Fatal("-webhookWorkers must be at least 1")
+	}
+	if *eventLogSize < 0 {
+		log.Fatal("-eventLogSize must not be negative")
+	}
+	if *jwtKeyFile != "" {
+		keys, err := loadJWTKeys(*jwtKeyFile)
+		if err != nil {
+			log.Fatalf("Error loading JWT keys: %v", err)
+		}
+		jwtKeys = keys
+	}
+
+	cachedPages = newPageCache(*pageCacheSize)
+	listEvents = newEventBroker(*eventLogSize)
+
 	initDB(*dbHost, *dbPort, *dbUser, *dbPassword, *dbName)
 
 	sqlDB, _ = db.DB()
 	defer sqlDB.Close()
 
+	// Run an admin command instead of the servers
+	if flag.NArg() > 0 {
+		if err := runCommand(flag.Args(), os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *grpcPort != 0 {
+		ln, err := net.Listen("tcp", fmt.Sprintf(":%d", *grpcPort))
+		if err != nil {
+			log.Fatalf("Error starting gRPC server: %v", err)
+		}
+		go func() {
+			log.Fatal(newGRPCServer().Serve(ln))
+		}()
+	}
+
+	if *respPort != 0 {
+		ln, err := net.Listen("tcp", fmt.Sprintf(":%d", *respPort))
+		if err != nil {
+			log.Fatalf("Error starting RESP server: %v", err)
+		}
+		go func() {
+			log.Fatal(serveRESP(ln))
+		}()
+	}
+
+	if *webhookInterval > 0 {
+		done := make(chan struct{})
+		defer close(done)
+		go dispatchWebhooksEvery(*webhookInterval, done)
+	}
+	if *publishInterval > 0 {
+		done := make(chan struct{})
+		defer close(done)
+		go runPublisher(*publishInterval, done)
+	}
+
+	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", *serverPort), newRouter()))
+}
+
+// newRouter creates the router of the HTTP API.
+func newRouter() *mux.Router {
 	r := mux.NewRouter()
+	r.Use(limitAddresses, authenticate, validateRequest, authorize, limitRequests, claimLists)
+	r.HandleFunc("/openapi.json", handleOpenAPI).Methods("GET")
+
+	// v1
+	r.HandleFunc("/v1/lists/{id}", handleGetListV1).Methods("GET")
+	r.HandleFunc("/v1/lists/{id}", withIdempotency(handleUpdateListV1)).Methods("PATCH")
+	r.HandleFunc("/v1/lists/{id}", withIdempotency(handleDeleteListV1)).Methods("DELETE")
+	r.HandleFunc("/v1/lists/{id}/items", withIdempotency(handleAppendItemV1)).Methods("POST")
+	r.HandleFunc("/v1/lists/{id}/pages/{pid}", handleGetPageV1).Methods("GET")
+	r.HandleFunc("/v1/lists/{id}/pages/{pid}", withIdempotency(handleReplacePageV1)).Methods("PUT")
+	r.HandleFunc("/v1/lists/{id}/grants", handleListGrants).Methods("GET")
+	r.HandleFunc("/v1/lists/{id}/grants/{key_id}", handleSaveGrant).Methods("PUT")
+	r.HandleFunc("/v1/lists/{id}/grants/{key_id}", handleRemoveGrant).Methods("DELETE")
+	r.HandleFunc("/v1/usage", handleGetUsage).Methods("GET")
+	r.HandleFunc("/v1/publish", withIdempotency(handlePublishV1)).Methods("POST")
+	r.HandleFunc("/v1/publish/{id}", handleGetPublishJob).Methods("GET")
+	r.HandleFunc("/v1/publish/{id}/retry", handleRetryPublishJob).Methods("POST")
+	r.HandleFunc("/v1/groups/{id}", handleGetGroup).Methods("GET")
+	r.HandleFunc("/v1/groups/{id}", handleSaveGroup).Methods("PUT")
+	r.HandleFunc("/v1/groups/{id}", handleRemoveGroup).Methods("DELETE")
 
 	// list
-	r.HandleFunc("/list/get", handleGetHead).Methods("GET")
+	r.HandleFunc("/list/get", deprecated("/v1/lists/{id}", handleGetHead)).Methods("GET")
+	r.HandleFunc("/lists/{id}/events", handleListEvents).Methods("GET")
+	r.HandleFunc("/lists/{id}/articles", handleQueryList).Methods("GET")
+	r.HandleFunc("/changes", handleGetChanges).Methods("GET")
+	r.HandleFunc("/search", handleSearch).Methods("GET")
+
+	// webhooks
+	r.HandleFunc("/lists/{id}/webhooks", handleRegisterWebhook).Methods("POST")
+	r.HandleFunc("/lists/{id}/webhooks", handleListWebhooks).Methods("GET")
+	r.HandleFunc("/webhooks/{id}", handleRemoveWebhook).Methods("DELETE")
+	r.HandleFunc("/webhooks/{id}/deliveries", handleGetWebhookDeliveries).Methods("GET")
+	r.HandleFunc("/webhooks/{id}/deliveries/{delivery_id}/retry", handleRetryWebhookDelivery).Methods("POST")
+
+	// page, deprecated in favour of v1
+	r.HandleFunc("/page/get", deprecated("/v1/lists/{id}/pages/{pid}", handleGetPage)).Methods("GET")
+	r.HandleFunc("/page/set", deprecated(fmt.Sprintf("/v1/lists/%d/items", FirstListKey), withIdempotency(handleSet))).Methods("POST")
+	r.HandleFunc("/page/update", deprecated("/v1/lists/{id}/pages/{pid}", withIdempotency(handleUpdate))).Methods("POST")
+	r.HandleFunc("/page/delete", deprecated("/v1/lists/{id}", withIdempotency(handleDeletePage))).Methods("DELETE")
+
+	// v2
+	r.HandleFunc("/v2/lists/{id}", handleGetListV2).Methods("GET")
+	r.HandleFunc("/v2/lists/{id}/items", handleGetListItemsV2).Methods("GET")
+	r.HandleFunc("/v2/lists/{id}/range", handleGetListRangeV2).Methods("GET")
+	r.HandleFunc("/v2/merge", handleMergeListsV2).Methods("GET")
 
-	// page
-	r.HandleFunc("/page/get", handleGetPage).Methods("GET")
-	r.HandleFunc("/page/set", handleSet).Methods("POST")
-	r.HandleFunc("/page/update", handleUpdate).Methods("POST")
-	r.HandleFunc("/page/delete", handleDeletePage).Methods("DELETE")
+	// metrics
+	r.HandleFunc("/metrics/cache", handleCacheStats).Methods("GET")
 
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", *serverPort), r))
+	return r
 }
 
 func handleGetHead(w http.ResponseWriter, r *http.Request) {
 	if err := getHead(w, r); err != nil {
 		log.Printf("Error in getHead: %v\n", err)
-		if strings.Contains(err.Error(), "missing") || strings.Contains(err.Error(), "valid integer") {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+func handleGetPage(w http.ResponseWriter, r *http.Request) {
+	if err := getPage(w, r); err != nil {
+		log.Printf("Error in getPage: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+func handleListEvents(w http.ResponseWriter, r *http.Request) {
+	if err := streamListEvents(w, r); err != nil {
+		log.Printf("Error in streamListEvents: %v\n", err)
+		if strings.Contains(err.Error(), "valid integer") {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 		} else {
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -102,9 +271,204 @@ func handleGetHead(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func handleGetPage(w http.ResponseWriter, r *http.Request) {
-	if err := getPage(w, r); err != nil {
-		log.Printf("Error in getPage: %v\n", err)
+func handleQueryList(w http.ResponseWriter, r *http.Request) {
+	if err := queryList(w, r); err != nil {
+		log.Printf("Error in queryList: %v\n", err)
+		if strings.Contains(err.Error(), "invalid") || strings.Contains(err.Error(), "valid integer") {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else if errors.Is(err, errCursorExpired) {
+			http.Error(w, err.Error(), http.StatusGone)
+		} else {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+}
+
+// v1Error answers a failed v1 request with the status code that matches the
+// error.
+func v1Error(w http.ResponseWriter, name string, err error) {
+	log.Printf("Error in %s: %v\n", name, err)
+	if writeRequestError(w, err) {
+		return
+	}
+	if strings.Contains(err.Error(), "valid integer") || strings.Contains(err.Error(), "invalid request body") {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	} else if strings.Contains(err.Error(), "not found") {
+		http.Error(w, err.Error(), http.StatusNotFound)
+	} else if strings.Contains(err.Error(), "precondition failed") {
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+	} else if strings.Contains(err.Error(), "quota exceeded") {
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	} else if strings.Contains(err.Error(), "only be changed while it is empty") ||
+		strings.Contains(err.Error(), "no failed targets") || errors.Is(err, errSortedPage) {
+		http.Error(w, err.Error(), http.StatusConflict)
+	} else if errors.Is(err, errForbidden) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+	} else {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func handleGetUsage(w http.ResponseWriter, r *http.Request) {
+	if err := getUsage(w, r); err != nil {
+		log.Printf("Error in getUsage: %v\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func handlePublishV1(w http.ResponseWriter, r *http.Request) {
+	if err := publishV1(w, r); err != nil {
+		v1Error(w, "publishV1", err)
+	}
+}
+
+func handleGetPublishJob(w http.ResponseWriter, r *http.Request) {
+	if err := getPublishJob(w, r); err != nil {
+		v1Error(w, "getPublishJob", err)
+	}
+}
+
+func handleRetryPublishJob(w http.ResponseWriter, r *http.Request) {
+	if err := retryPublishJob(w, r); err != nil {
+		v1Error(w, "retryPublishJob", err)
+	}
+}
+
+func handleGetGroup(w http.ResponseWriter, r *http.Request) {
+	if err := getGroup(w, r); err != nil {
+		v1Error(w, "getGroup", err)
+	}
+}
+
+func handleSaveGroup(w http.ResponseWriter, r *http.Request) {
+	if err := saveGroup(w, r); err != nil {
+		v1Error(w, "saveGroup", err)
+	}
+}
+
+func handleRemoveGroup(w http.ResponseWriter, r *http.Request) {
+	if err := removeGroup(w, r); err != nil {
+		v1Error(w, "removeGroup", err)
+	}
+}
+
+func handleListGrants(w http.ResponseWriter, r *http.Request) {
+	if err := listGrants(w, r); err != nil {
+		v1Error(w, "listGrants", err)
+	}
+}
+
+func handleSaveGrant(w http.ResponseWriter, r *http.Request) {
+	if err := saveGrant(w, r); err != nil {
+		v1Error(w, "saveGrant", err)
+	}
+}
+
+func handleRemoveGrant(w http.ResponseWriter, r *http.Request) {
+	if err := removeGrant(w, r); err != nil {
+		v1Error(w, "removeGrant", err)
+	}
+}
+
+func handleGetListV1(w http.ResponseWriter, r *http.Request) {
+	if err := getListV1(w, r); err != nil {
+		v1Error(w, "getListV1", err)
+	}
+}
+
+func handleGetPageV1(w http.ResponseWriter, r *http.Request) {
+	if err := getPageV1(w, r); err != nil {
+		v1Error(w, "getPageV1", err)
+	}
+}
+
+func handleReplacePageV1(w http.ResponseWriter, r *http.Request) {
+	if err := replacePageV1(w, r); err != nil {
+		v1Error(w, "replacePageV1", err)
+	}
+}
+
+func handleAppendItemV1(w http.ResponseWriter, r *http.Request) {
+	if err := appendItemV1(w, r); err != nil {
+		v1Error(w, "appendItemV1", err)
+	}
+}
+
+func handleUpdateListV1(w http.ResponseWriter, r *http.Request) {
+	if err := updateListV1(w, r); err != nil {
+		v1Error(w, "updateListV1", err)
+	}
+}
+
+func handleDeleteListV1(w http.ResponseWriter, r *http.Request) {
+	if err := deleteListV1(w, r); err != nil {
+		v1Error(w, "deleteListV1", err)
+	}
+}
+
+// v2Error answers a failed v2 request with the status code that matches the
+// error.
+func v2Error(w http.ResponseWriter, name string, err error) {
+	log.Printf("Error in %s: %v\n", name, err)
+	if writeRequestError(w, err) {
+		return
+	}
+	if strings.Contains(err.Error(), "valid integer") || strings.Contains(err.Error(), "parameter is invalid") ||
+		errors.Is(err, errInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	} else if strings.Contains(err.Error(), "not found") {
+		http.Error(w, err.Error(), http.StatusNotFound)
+	} else if errors.Is(err, errCursorExpired) {
+		http.Error(w, "cursor expired, read the list again from the start", http.StatusGone)
+	} else if strings.Contains(err.Error(), "is not sorted") {
+		http.Error(w, err.Error(), http.StatusConflict)
+	} else if errors.Is(err, errForbidden) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+	} else {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func handleGetListV2(w http.ResponseWriter, r *http.Request) {
+	if err := getListV2(w, r); err != nil {
+		v2Error(w, "getListV2", err)
+	}
+}
+
+func handleGetListItemsV2(w http.ResponseWriter, r *http.Request) {
+	if err := getListItemsV2(w, r); err != nil {
+		v2Error(w, "getListItemsV2", err)
+	}
+}
+
+func handleMergeListsV2(w http.ResponseWriter, r *http.Request) {
+	if err := mergeListsV2(w, r); err != nil {
+		v2Error(w, "mergeListsV2", err)
+	}
+}
+
+func handleGetListRangeV2(w http.ResponseWriter, r *http.Request) {
+	if err := getListRangeV2(w, r); err != nil {
+		v2Error(w, "getListRangeV2", err)
+	}
+}
+
+func handleGetChanges(w http.ResponseWriter, r *http.Request) {
+	if err := getChanges(w, r); err != nil {
+		log.Printf("Error in getChanges: %v\n", err)
+		if strings.Contains(err.Error(), "valid integer") {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+}
+
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	if err := search(w, r); err != nil {
+		log.Printf("Error in search: %v\n", err)
 		if strings.Contains(err.Error(), "missing") || strings.Contains(err.Error(), "valid integer") {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 		} else {
@@ -114,6 +478,63 @@ func handleGetPage(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// webhookError answers a failed webhook request with the status code that
+// matches the error.
+func webhookError(w http.ResponseWriter, name string, err error) {
+	log.Printf("Error in %s: %v\n", name, err)
+	if writeRequestError(w, err) {
+		return
+	}
+	if strings.Contains(err.Error(), "valid integer") ||
+		strings.Contains(err.Error(), "invalid request body") ||
+		strings.Contains(err.Error(), "valid delivery status") {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	} else if strings.Contains(err.Error(), "not found") {
+		http.Error(w, err.Error(), http.StatusNotFound)
+	} else if strings.Contains(err.Error(), "only dead deliveries") {
+		http.Error(w, err.Error(), http.StatusConflict)
+	} else {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func handleRegisterWebhook(w http.ResponseWriter, r *http.Request) {
+	if err := registerWebhook(w, r); err != nil {
+		webhookError(w, "registerWebhook", err)
+	}
+}
+
+func handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	if err := listWebhooks(w, r); err != nil {
+		webhookError(w, "listWebhooks", err)
+	}
+}
+
+func handleRemoveWebhook(w http.ResponseWriter, r *http.Request) {
+	if err := removeWebhook(w, r); err != nil {
+		webhookError(w, "removeWebhook", err)
+	}
+}
+
+func handleGetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	if err := getWebhookDeliveryLog(w, r); err != nil {
+		webhookError(w, "getWebhookDeliveryLog", err)
+	}
+}
+
+func handleRetryWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	if err := retryWebhookDelivery(w, r); err != nil {
+		webhookError(w, "retryWebhookDelivery", err)
+	}
+}
+
+func handleCacheStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(cachedPages.stats()); err != nil {
+		log.Printf("Error encoding cache stats: %v\n", err)
+	}
+}
+
 func handleSet(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
@@ -121,8 +542,13 @@ func handleSet(w http.ResponseWriter, r *http.Request) {
 	}
 	if err := set(w, r); err != nil {
 		log.Printf("Error in set: %v\n", err)
+		if writeRequestError(w, err) {
+			return
+		}
 		if strings.Contains(err.Error(), "invalid request body") {
 			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else if strings.Contains(err.Error(), "quota exceeded") {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
 		} else {
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 		}
@@ -137,12 +563,17 @@ func handleUpdate(w http.ResponseWriter, r *http.Request) {
 	}
 	if err := update(w, r); err != nil {
 		log.Printf("Error in update: %v\n", err)
-		if strings.Contains(err.Error(), "page_id parameter is missing") ||
-			strings.Contains(err.Error(), "page_id parameter is not a valid integer") ||
-			strings.Contains(err.Error(), "Invalid request body") {
+		if writeRequestError(w, err) {
+			return
+		}
+		if strings.Contains(err.Error(), "invalid request body") {
 			http.Error(w, err.Error(), http.StatusBadRequest)
-		} else if strings.Contains(err.Error(), "Page not found") {
+		} else if errors.Is(err, gorm.ErrRecordNotFound) {
 			http.Error(w, err.Error(), http.StatusNotFound)
+		} else if strings.Contains(err.Error(), "precondition failed") {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		} else if errors.Is(err, errSortedPage) {
+			http.Error(w, err.Error(), http.StatusConflict)
 		} else {
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 		}
@@ -153,9 +584,8 @@ func handleUpdate(w http.ResponseWriter, r *http.Request) {
 func handleDeletePage(w http.ResponseWriter, r *http.Request) {
 	if err := deletePage(w, r); err != nil {
 		log.Printf("Error in deletePage: %v\n", err)
-		if strings.Contains(err.Error(), "list_id parameter is missing") ||
-			strings.Contains(err.Error(), "list_id parameter is not a valid integer") {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		if strings.Contains(err.Error(), "precondition failed") {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
 		} else {
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 		}
@@ -163,78 +593,406 @@ func handleDeletePage(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func createNewPage(newPageID uint, ListID uint) (page Page) {
-	var err error
-	// Create a new page with the given pageID and ListID
+// createNewPage creates a new, empty page at the end of the list. lastPage is
+// the current tail of the list, or nil if the list has no pages yet.
+func createNewPage(tx *gorm.DB, list *List, lastPage *Page) (page Page, err error) {
+	// Create a new page with the given ListID
 	page = Page{
-		ID:     newPageID,
-		ListID: ListID,
+		ListID: list.ID,
 	}
-	if newPageID != FirstPageKey {
-		var lastPage Page
-		err = getLastPage(db, &lastPage)
+	err = createPage(tx, &page)
+	if err != nil {
+		return page, err
+	}
+
+	if lastPage == nil {
+		err = recordChange(tx, &Change{Type: EventPageCreate, ListID: list.ID, PageID: page.ID}, nil)
 		if err != nil {
-			log.Fatal(err)
+			return page, err
 		}
-		// log.Printf("last page: %+v", lastPage)
-		err = updateLastPageNextPageID(db, &lastPage, newPageID)
+
+		// The first page becomes the head of the list
+		err = updateListNextPageID(tx, list, page.ID)
 		if err != nil {
-			log.Fatal(err)
+			return page, err
 		}
+		err = recordChange(tx, &Change{Type: EventHeadSwap, ListID: list.ID, PageID: page.ID}, nil)
+		if err != nil {
+			return page, err
+		}
+		return page, nil
 	}
 
-	err = createPage(db, &page)
+	err = recordChange(tx, &Change{Type: EventPageCreate, ListID: list.ID, PageID: page.ID},
+		map[string]uint{"previous_page_id": lastPage.ID})
 	if err != nil {
-		log.Fatal(err)
+		return page, err
+	}
+
+	err = updateLastPageNextPageID(tx, lastPage, page.ID)
+	if err != nil {
+		return page, err
+	}
+	err = incrementPageVersion(tx, lastPage.ID)
+	if err != nil {
+		return page, err
 	}
-	return page
+	return page, nil
 }
 
-func addArticleToPage(newArticle Article) error {
-	var page Page
-	var err error
+// createNewHeadPage creates a new, empty page in front of the head of a list
+// in prepend mode and makes it the head.
+func createNewHeadPage(tx *gorm.DB, list *List) (page Page, err error) {
+	page = Page{
+		ListID:     list.ID,
+		NextPageID: list.NextPageID,
+	}
+	err = createPage(tx, &page)
+	if err != nil {
+		return page, err
+	}
 
-	// find the page you want to add the article to
-	err = getLastPage(db, &page)
+	err = recordChange(tx, &Change{Type: EventPageCreate, ListID: list.ID, PageID: page.ID},
+		map[string]uint{"next_page_id": page.NextPageID})
 	if err != nil {
-		if err == gorm.ErrRecordNotFound {
-			// Pages table is empty
-			fmt.Println("create the first page")
-			page = createNewPage(FirstPageKey, FirstListKey)
+		return page, err
+	}
+	err = updateListNextPageID(tx, list, page.ID)
+	if err != nil {
+		return page, err
+	}
+	err = recordChange(tx, &Change{Type: EventHeadSwap, ListID: list.ID, PageID: page.ID}, nil)
+	if err != nil {
+		return page, err
+	}
+	return page, nil
+}
+
+// appendArticle adds the article to the last page of the list, starting a new
+// page when the last one is full, and returns the page it was added to. Lists
+// in prepend mode take the article on their head page instead, and start a
+// new head page when it is full. The list is created if it does not exist
+// yet. The Page is zero when the list dropped the article at once, as a
+// capped sorted list does with an article scored below all it keeps.
+func appendArticle(listID uint, newArticle Article) (Page, error) {
+	return appendArticleWith(listID, newArticle, nil)
+}
+
+// appendArticleWith appends the article like appendArticle. record is called
+// with the page the article was added to in the same transaction, and undoes
+// the append by returning an error.
+func appendArticleWith(listID uint, newArticle Article, record func(tx *gorm.DB, page *Page) error) (Page, error) {
+	var page Page
+	var changed []uint
+	var newHead bool
+	var split uint
+	var trimmed trimResult
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var list List
+		err := getListForUpdate(tx, listID, &list)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			list = List{ID: listID}
+			err = createList(tx, &list)
+		}
+		if err != nil {
+			return err
+		}
+
+		// find the page you want to add the article to: the tail of the
+		// list, its head in prepend mode, or the page of its score in
+		// sorted mode
+		if list.Mode != ListModeSorted {
+			newArticle.Score = 0
+		}
+		if list.Mode == ListModePrepend {
+			err = getHeadPage(tx, &list, &page)
+		} else if list.Mode == ListModeSorted {
+			err = findSortedPage(tx, &list, newArticle.Score, &page)
 		} else {
-			log.Fatal(err)
+			err = getLastPageByListID(tx, listID, &page)
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("create the first page of list %d\n", listID)
+			page, err = createNewPage(tx, &list, nil)
+			if err != nil {
+				return err
+			}
+			newHead = true
+		} else if err != nil {
+			return err
+		} else if list.Mode != ListModeSorted {
+			// Count the articles on the page instead of loading them
+			var count int64
+			err = countArticlesByPageID(tx, page.ID, &count)
+			if err != nil {
+				return err
+			}
+
+			if count >= NumberOfArticleInOnePage {
+				if err := checkPageQuota(tx, listID); err != nil {
+					return err
+				}
+				if list.Mode == ListModePrepend {
+					// The new page goes in front of the full head
+					page, err = createNewHeadPage(tx, &list)
+					if err != nil {
+						return err
+					}
+					newHead = true
+				} else {
+					lastPage := page
+					page, err = createNewPage(tx, &list, &lastPage)
+					if err != nil {
+						return err
+					}
+					changed = append(changed, lastPage.ID)
+				}
+				log.Printf("createNewPage id: %v\n", page.ID)
+			}
+		}
+
+		// Insert only the new article, the ones already on the page are unchanged
+		newArticle.PageID = page.ID
+		err = saveArticle(tx, &newArticle)
+		if err != nil {
+			return err
+		}
+		err = recordChange(tx, &Change{Type: EventAppend, ListID: listID, PageID: page.ID},
+			map[string]interface{}{"article_id": newArticle.ID, "article": articleFields(newArticle)})
+		if err != nil {
+			return err
+		}
+
+		// Sorted lists split the page once it is over full, the article
+		// stays on the page that holds it after the split
+		if list.Mode == ListModeSorted {
+			next, err := splitSortedPage(tx, &list, &page)
+			if err != nil {
+				return err
+			}
+			if next.ID != 0 {
+				var article Article
+				if err := getArticleByID(tx, newArticle.ID, &article); err != nil {
+					return err
+				}
+				split = next.ID
+				if article.PageID == next.ID {
+					split = page.ID
+					page = next
+				}
+				changed = append(changed, split)
+			}
+		}
+
+		// Capped lists drop their oldest articles in the same transaction
+		trimmed, err = trimList(tx, &list)
+		if err != nil {
+			return err
+		}
+
+		// A capped sorted list drops the new article right away when it has
+		// the lowest score, and may delete its page with it
+		if len(trimmed.pages) > 0 {
+			err := getArticleByID(tx, newArticle.ID, &Article{})
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				page = Page{}
+			} else if err != nil {
+				return err
+			}
 		}
-	}
 
-	err = preloadArticles(db, &page)
+		// Let readers holding an ETag know the page and its list have changed
+		if page.ID != 0 {
+			if err := incrementPageVersion(tx, page.ID); err != nil {
+				return err
+			}
+		}
+		if err := incrementListVersion(tx, listID); err != nil {
+			return err
+		}
+		if record != nil {
+			return record(tx, &page)
+		}
+		return nil
+	})
 	if err != nil {
-		log.Fatal(err)
+		return page, err
+	}
+	cachedPages.invalidate(append(changed, page.ID)...)
+
+	if page.ID != 0 {
+		if newHead {
+			listEvents.publish(ListEvent{Type: EventHeadSwap, ListID: listID, PageID: page.ID})
+		}
+		listEvents.publish(ListEvent{Type: EventAppend, ListID: listID, PageID: page.ID})
 	}
+	if split != 0 {
+		listEvents.publish(ListEvent{Type: EventPageUpdate, ListID: listID, PageID: split})
+	}
+	trimmed.publish(listID)
+
+	log.Printf("Add Article to page id: %v\n", page.ID)
+	return page, nil
+}
+
+// addArticleToPage adds the article to the first list.
+func addArticleToPage(newArticle Article) error {
+	_, err := appendArticle(FirstListKey, newArticle)
+	return err
+}
+
+// updatePage replaces the articles of the page in one transaction and returns
+// the updated page. check is called with the current page and may veto the
+// update by returning an error.
+func updatePage(pageID uint, articles []Article, check func(page *Page) error) (Page, error) {
+	var page Page
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Get the corresponding page
+		if err := getPageByID(tx, pageID, &page); err != nil {
+			return fmt.Errorf("page not found: %w", err)
+		}
+
+		if check != nil {
+			if err := check(&page); err != nil {
+				return err
+			}
+		}
+		var list List
+		if err := getListByID(tx, page.ListID, &list); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("error fetching list: %v", err)
+		}
+		if list.Mode == ListModeSorted {
+			return errSortedPage
+		}
+		// Only sorted lists keep the scores of their articles
+		for i := range articles {
+			articles[i].Score = 0
+		}
+		// Claim the next version first, so that a concurrent update of the
+		// same page fails instead of overwriting this one
+		if err := bumpPageVersion(tx, &page); err != nil {
+			return err
+		}
+
+		// Delete the existing articles associated with the page
+		if err := deleteArticlesByPageID(tx, page.ID); err != nil {
+			return fmt.Errorf("failed to delete articles: %v", err)
+		}
+
+		// Update the page's articles. Pages of prepend lists are read newest
+		// first, so their articles are saved in reverse to keep the order
+		// they were given in.
+		page.Articles = articles
+		if list.Mode == ListModePrepend {
+			reverseArticles(page.Articles)
+		}
+		if err := savePage(tx, &page); err != nil {
+			return fmt.Errorf("failed to update page: %v", err)
+		}
+		if list.Mode == ListModePrepend {
+			reverseArticles(page.Articles)
+		}
 
-	if len(page.Articles) >= NumberOfArticleInOnePage {
-		page = createNewPage(page.ID+1, page.ListID)
-		log.Printf("createNewPage id: %v\n", page.ID)
+		if err := incrementListVersion(tx, page.ListID); err != nil {
+			return fmt.Errorf("failed to update list version: %v", err)
+		}
+
+		articleData := []map[string]interface{}{}
+		for _, article := range page.Articles {
+			articleData = append(articleData, articleFields(article))
+		}
+		err := recordChange(tx, &Change{Type: EventPageUpdate, ListID: page.ListID, PageID: page.ID},
+			map[string]interface{}{"articles": articleData})
+		if err != nil {
+			return fmt.Errorf("failed to record change: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return page, err
 	}
+	cachedPages.invalidate(page.ID)
+	listEvents.publish(ListEvent{Type: EventPageUpdate, ListID: page.ListID, PageID: page.ID})
+
+	return page, nil
+}
 
-	// append the new article to the Articles slice of the page
-	page.Articles = append(page.Articles, newArticle)
+// deleteList deletes every page and article of the list in one transaction,
+// clears its head and returns the number of pages deleted. check is called
+// with the list, or nil if there is no such list, and may veto the delete by
+// returning an error.
+func deleteList(listID uint, check func(list *List) error) (int64, error) {
+	var deleted int64
+	var oldHead uint
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var list List
+		err := getListForUpdate(tx, listID, &list)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("error fetching list: %v", err)
+		}
+		found := err == nil
+
+		if check != nil {
+			listOrNil := &list
+			if !found {
+				listOrNil = nil
+			}
+			if err := check(listOrNil); err != nil {
+				return err
+			}
+		}
+
+		if err := countPagesByListID(tx, listID, &deleted); err != nil {
+			return fmt.Errorf("failed to count pages: %v", err)
+		}
+		if err := deletePagesByListID(tx, listID); err != nil {
+			return fmt.Errorf("failed to delete articles: %v", err)
+		}
+		if deleted > 0 {
+			err := recordChange(tx, &Change{Type: EventDelete, ListID: listID}, map[string]int64{"deleted_pages": deleted})
+			if err != nil {
+				return fmt.Errorf("failed to record change: %v", err)
+			}
+		}
 
-	// Save the updated page back to the database
-	err = savePage(db, &page)
+		if found {
+			oldHead = list.NextPageID
+			if err := bumpListVersion(tx, &list); err != nil {
+				return err
+			}
+			if err := updateListNextPageID(tx, &list, 0); err != nil {
+				return fmt.Errorf("failed to update list: %v", err)
+			}
+			if err := updateListExpiresAt(tx, &list, nil); err != nil {
+				return fmt.Errorf("failed to update list: %v", err)
+			}
+			if err := incrementListGeneration(tx, &list); err != nil {
+				return fmt.Errorf("failed to update list: %v", err)
+			}
+			if oldHead != 0 {
+				if err := recordChange(tx, &Change{Type: EventHeadSwap, ListID: listID}, nil); err != nil {
+					return fmt.Errorf("failed to record change: %v", err)
+				}
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
+	cachedPages.invalidateList(listID)
 
-	// err = preloadArticles(db, &page)
-	// if err != nil {
-	// 	log.Fatal(err)
-	// }
+	if deleted > 0 {
+		listEvents.publish(ListEvent{Type: EventDelete, ListID: listID})
+	}
+	if oldHead != 0 {
+		listEvents.publish(ListEvent{Type: EventHeadSwap, ListID: listID, PageID: 0})
+	}
 
-	log.Printf("Add Article to page id: %v\n", page.ID)
-	// for _, article := range page.Articles {
-	// 	log.Printf("Article ID: %d, Title: %s, Author: %s, Content: %s\n", article.ID, article.Title, article.Author, article.Content)
-	// }
-	return nil
+	return deleted, nil
 }
 
 func createSampleArticle() {
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
 	"bytes"
@@ -25,10 +26,16 @@ func TestMain(m *testing.M) {
 	}
 
 	// Migrate the database schema
-	db.AutoMigrate(&List{}, &Page{}, &Article{})
+	db.AutoMigrate(&List{}, &Page{}, &Article{}, &IdempotencyKey{}, &Change{}, &Webhook{}, &WebhookDelivery{}, &DispatchCursor{}, &APIKey{}, &ListGrant{}, &PublishJob{}, &PublishTarget{}, &ListGroup{}, &ListGroupMember{})
 
 	createListIfNotExists()
 
+	// The tests of the handlers do not send API keys; auth_test.go turns
+	// authentication back on
+	authRequired = false
+	// Nor are they rate limited; ratelimit_test.go uses its own limiter
+	limits = nil
+
 	// Create same sample articles if there is no data in articles table
 	// createSampleArticle()
 
@@ -39,9 +46,42 @@ func TestMain(m *testing.M) {
 	os.Exit(code)
 }
 
+// useTestDB replaces the global database with a fresh in-memory SQLite
+// database for the duration of a test or benchmark.
+func useTestDB(t testing.TB) {
+	t.Helper()
+
+	testDB, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to connect to the database: %v", err)
+	}
+	// Every connection to ":memory:" opens a separate database
+	sqlTestDB, _ := testDB.DB()
+	sqlTestDB.SetMaxOpenConns(1)
+
+	if err := testDB.AutoMigrate(&List{}, &Page{}, &Article{}, &IdempotencyKey{}, &Change{}, &Webhook{}, &WebhookDelivery{}, &DispatchCursor{}, &APIKey{}, &ListGrant{}, &PublishJob{}, &PublishTarget{}, &ListGroup{}, &ListGroupMember{}); err != nil {
+		t.Fatalf("Failed to migrate the database schema: %v", err)
+	}
+	if err := setupSearch(testDB); err != nil {
+		t.Fatalf("Failed to set up search: %v", err)
+	}
+
+	saved, savedCache, savedEvents := db, cachedPages, listEvents
+	db, cachedPages, listEvents = testDB, newPageCache(1024), newEventBroker(1000)
+	t.Cleanup(func() {
+		db, cachedPages, listEvents = saved, savedCache, savedEvents
+		sqlTestDB.Close()
+	})
+
+	if err := createListIfNotExists(); err != nil {
+		t.Fatalf("Failed to create the first list: %v", err)
+	}
+}
+
 func TestHandleGetHead(t *testing.T) {
 	// Initialize the database and router
 	router := mux.NewRouter()
+	router.Use(validateRequest)
 	router.HandleFunc("/list/get", handleGetHead).Methods("GET")
 
 	testCases := []struct {
@@ -114,6 +154,7 @@ func TestHandleGetPage(t *testing.T) {
 
 	// Initialize the database and router
 	router := mux.NewRouter()
+	router.Use(validateRequest)
 	router.HandleFunc("/page/get", handleGetPage).Methods("GET")
 
 	// Create a request with an invalid page ID (not an integer)
@@ -152,7 +193,7 @@ func TestHandleGetPage(t *testing.T) {
 		t.Errorf("Expected status code %d but got %d", http.StatusOK, rec.Code)
 	}
 	// Check the response body
-	expected := `{"articles":[{"author":"Test Author","content":"This is a test article.","title":"Test Article"}],"next_page_id":2}` + "\n"
+	expected := `{"articles":[{"author":"Test Author","content":"This is a test article.","score":0,"title":"Test Article"}],"next_page_id":2}` + "\n"
 	if rec.Body.String() != expected {
 		t.Errorf("Handler returned unexpected body: got %q, want %q", rec.Body.String(), expected)
 	}
@@ -274,6 +315,18 @@ func TestHandleUpdate(t *testing.T) {
 	}
 }
 
+func TestHandleUpdateMissingPage(t *testing.T) {
+	useTestDB(t)
+
+	body := `{"title": "Title", "author": "Author", "content": "Content"}`
+	rr := httptest.NewRecorder()
+	handleUpdate(rr, httptest.NewRequest("POST", "/page/update?page_id=999", strings.NewReader(body)))
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("Unexpected response status code: got %v, want %v", status, http.StatusNotFound)
+	}
+}
+
 func TestHandleDeletePage(t *testing.T) {
 	// Create a new test list
 	list := List{ID: 99}
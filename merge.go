@@ -0,0 +1,334 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Orders of a merged read.
+const (
+	// MergeNewest merges the items newest first, by creation time
+	MergeNewest = "newest"
+	// MergeOldest merges the items oldest first, by creation time
+	MergeOldest = "oldest"
+	// MergeHighest merges the items highest score first, like sorted Lists
+	MergeHighest = "highest"
+	// MergeLowest merges the items lowest score first
+	MergeLowest = "lowest"
+)
+
+// mergeOrder is how an order sorts the articles: on a column, descending or
+// not, with ties broken by the article IDs, descending or not.
+type mergeOrder struct {
+	column  string
+	desc    bool
+	idsDesc bool
+}
+
+var mergeOrders = map[string]mergeOrder{
+	MergeNewest:  {"articles.created_at", true, true},
+	MergeOldest:  {"articles.created_at", false, false},
+	MergeHighest: {"articles.score", true, false},
+	MergeLowest:  {"articles.score", false, true},
+}
+
+// scoreBounds restricts a read to the articles with scores from Min to Max,
+// inclusive. Infinite bounds leave the range open.
+type scoreBounds struct {
+	Min float64
+	Max float64
+}
+
+// maxMergeLists is the most Lists one merged read can take.
+var maxMergeLists = 10000
+
+// mergePosition is a position in the merge order: after the article with
+// the ID ArticleID, created at CreatedAt, in Unix nanoseconds, with the
+// Score. Article IDs are unique across Lists, so positions never tie. The
+// zero position is the start.
+type mergePosition struct {
+	ListID    uint    `json:"l,omitempty"`
+	CreatedAt int64   `json:"c"`
+	Score     float64 `json:"s,omitempty"`
+	ArticleID uint    `json:"a"`
+}
+
+// mergeCursor is a position in a merged read of several Lists. Lists holds
+// the position of each List the last page was merged from. The other Lists
+// had nothing before Merge, the position of the last item of the page, and
+// are read after it. Clients only see it signed and encoded by sealCursor.
+type mergeCursor struct {
+	Order    string          `json:"o"`
+	Merge    mergePosition   `json:"m"`
+	Lists    []mergePosition `json:"ls,omitempty"`
+	IssuedAt int64           `json:"t"`
+}
+
+// mergeItem is an article with the List it was merged from.
+type mergeItem struct {
+	ID        uint
+	ListID    uint
+	Title     string
+	Author    string
+	Content   string
+	Score     float64
+	CreatedAt time.Time
+}
+
+func (item *mergeItem) position() mergePosition {
+	return mergePosition{ListID: item.ListID, CreatedAt: item.CreatedAt.UnixNano(), Score: item.Score, ArticleID: item.ID}
+}
+
+// mergeBefore tells if a comes before b in the order.
+func mergeBefore(order string, a, b *mergeItem) bool {
+	o := mergeOrders[order]
+	if o.column == "articles.score" && a.Score != b.Score {
+		return (a.Score > b.Score) == o.desc
+	}
+	if o.column == "articles.created_at" && !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.After(b.CreatedAt) == o.desc
+	}
+	return (a.ID > b.ID) == o.idsDesc
+}
+
+// GetMergeItems gets up to limit articles of the Lists after the position,
+// in the order, with scores within the bounds if there are some.
+func getMergeItems(db *gorm.DB, listIDs []uint, order string, after mergePosition, bounds *scoreBounds, limit int, items *[]mergeItem) error {
+	q := db.Table("articles").
+		Select("articles.id, pages.list_id, articles.title, articles.author, articles.content, articles.score, articles.created_at").
+		Joins("JOIN pages ON pages.id = articles.page_id").
+		Where("pages.list_id IN ?", listIDs)
+
+	if bounds != nil && !math.IsInf(bounds.Min, -1) {
+		q = q.Where("articles.score >= ?", bounds.Min)
+	}
+	if bounds != nil && !math.IsInf(bounds.Max, 1) {
+		q = q.Where("articles.score <= ?", bounds.Max)
+	}
+
+	o := mergeOrders[order]
+	direction, compare := "ASC", ">"
+	if o.desc {
+		direction, compare = "DESC", "<"
+	}
+	idsDirection, idsCompare := "ASC", ">"
+	if o.idsDesc {
+		idsDirection, idsCompare = "DESC", "<"
+	}
+	if after.ArticleID != 0 {
+		var value interface{} = after.Score
+		if o.column == "articles.created_at" {
+			value = time.Unix(0, after.CreatedAt)
+		}
+		q = q.Where("("+o.column+" "+compare+" ? OR ("+o.column+" = ? AND articles.id "+idsCompare+" ?))",
+			value, value, after.ArticleID)
+	}
+
+	*items = nil
+	return q.Order(o.column + " " + direction).Order("articles.id " + idsDirection).Limit(limit).Find(items).Error
+}
+
+// mergeSources is a heap of the sources of a merge, each in merge order,
+// with the source of the next item on top.
+type mergeSources struct {
+	order   string
+	sources [][]mergeItem
+}
+
+func (h *mergeSources) Len() int { return len(h.sources) }
+func (h *mergeSources) Less(i, j int) bool {
+	return mergeBefore(h.order, &h.sources[i][0], &h.sources[j][0])
+}
+func (h *mergeSources) Swap(i, j int)      { h.sources[i], h.sources[j] = h.sources[j], h.sources[i] }
+func (h *mergeSources) Push(x interface{}) { h.sources = append(h.sources, x.([]mergeItem)) }
+func (h *mergeSources) Pop() interface{} {
+	last := h.sources[len(h.sources)-1]
+	h.sources = h.sources[:len(h.sources)-1]
+	return last
+}
+
+// mergeLists reads up to limit items of the Lists after the cursor with a
+// k-way merge, and moves the cursor past them. Lists read to the same
+// position are read together: one query gets the next limit+1 items of all
+// of them, which is all the merge can take from them. There are at most
+// limit+1 such positions, so a page takes a bounded number of queries
+// however many Lists are merged.
+func mergeLists(db *gorm.DB, listIDs []uint, cursor *mergeCursor, bounds *scoreBounds, limit int) ([]mergeItem, bool, error) {
+	positions := make(map[uint]mergePosition)
+	for _, position := range cursor.Lists {
+		positions[position.ListID] = position
+	}
+	var starts []mergePosition
+	groups := make(map[mergePosition][]uint)
+	for _, listID := range listIDs {
+		start, ok := positions[listID]
+		if !ok {
+			start = cursor.Merge
+		}
+		start.ListID = 0
+		if _, ok := groups[start]; !ok {
+			starts = append(starts, start)
+		}
+		groups[start] = append(groups[start], listID)
+	}
+
+	h := &mergeSources{order: cursor.Order}
+	for _, start := range starts {
+		var items []mergeItem
+		if err := getMergeItems(db, groups[start], cursor.Order, start, bounds, limit+1, &items); err != nil {
+			return nil, false, err
+		}
+		if len(items) > 0 {
+			h.sources = append(h.sources, items)
+		}
+	}
+	heap.Init(h)
+
+	merged := []mergeItem{}
+	for len(merged) < limit && h.Len() > 0 {
+		merged = append(merged, h.sources[0][0])
+		if h.sources[0] = h.sources[0][1:]; len(h.sources[0]) == 0 {
+			heap.Pop(h)
+		} else {
+			heap.Fix(h, 0)
+		}
+	}
+
+	if len(merged) > 0 {
+		cursor.Merge = merged[len(merged)-1].position()
+		cursor.Merge.ListID = 0
+		last := make(map[uint]int)
+		cursor.Lists = nil
+		for _, item := range merged {
+			if i, ok := last[item.ListID]; ok {
+				cursor.Lists[i] = item.position()
+				continue
+			}
+			last[item.ListID] = len(cursor.Lists)
+			cursor.Lists = append(cursor.Lists, item.position())
+		}
+	}
+	return merged, h.Len() > 0, nil
+}
+
+// decodeMergeCursor checks and decodes a cursor of a merged read in the
+// order. An empty string is the start.
+func decodeMergeCursor(s string, order string, cursor *mergeCursor) error {
+	*cursor = mergeCursor{Order: order}
+	if s == "" {
+		return nil
+	}
+	if err := openCursor(s, cursor); err != nil {
+		return err
+	}
+	if cursor.Order != order {
+		return errInvalidCursor
+	}
+	if time.Since(time.Unix(cursor.IssuedAt, 0)) > cursorMaxAge {
+		return errCursorExpired
+	}
+	return nil
+}
+
+// parseListIDs parses comma separated List IDs.
+func parseListIDs(s string) ([]uint, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var listIDs []uint
+	for _, field := range strings.Split(s, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("lists parameter is not a list of valid integers")
+		}
+		listIDs = append(listIDs, uint(id))
+	}
+	return listIDs, nil
+}
+
+// mergeListsV2 returns the items of several Lists merged in one order, after
+// a composite cursor that remembers where each List was read to. Like
+// getListItemsV2, the response always has a next_cursor.
+func mergeListsV2(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	listIDs, err := parseListIDs(query.Get("lists"))
+	if err != nil {
+		return err
+	}
+	var groupID uint
+	if s := query.Get("group_id"); s != "" {
+		id, err := strconv.Atoi(s)
+		if err != nil || id <= 0 {
+			return fmt.Errorf("group_id parameter is not a valid integer")
+		}
+		groupID = uint(id)
+	}
+	order := query.Get("order")
+	if order == "" {
+		order = MergeNewest
+	}
+	if _, ok := mergeOrders[order]; !ok {
+		return fmt.Errorf("order parameter is invalid, expected %s, %s, %s or %s", MergeNewest, MergeOldest, MergeHighest, MergeLowest)
+	}
+
+	limit := defaultViewLimit
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			return fmt.Errorf("limit parameter is not a valid integer")
+		}
+		if limit > maxViewLimit {
+			limit = maxViewLimit
+		}
+	}
+
+	p := principalFromContext(r.Context())
+	listIDs, err = collectListIDs(p, listIDs, groupID)
+	if err != nil {
+		return err
+	}
+	if len(listIDs) == 0 {
+		return fmt.Errorf("lists parameter is invalid, lists or group_id must name at least one list")
+	}
+	if len(listIDs) > maxMergeLists {
+		return fmt.Errorf("lists parameter is invalid, at most %d lists can be merged", maxMergeLists)
+	}
+	if err := authorizeLists(p, listIDs, ScopeRead, false); err != nil {
+		return err
+	}
+
+	var cursor mergeCursor
+	if err := decodeMergeCursor(query.Get("cursor"), order, &cursor); err != nil {
+		return err
+	}
+
+	merged, hasMore, err := mergeLists(db, listIDs, &cursor, nil, limit)
+	if err != nil {
+		return fmt.Errorf("error merging lists: %v", err)
+	}
+	items := []map[string]interface{}{}
+	for _, item := range merged {
+		items = append(items, map[string]interface{}{
+			"list_id":    item.ListID,
+			"title":      item.Title,
+			"author":     item.Author,
+			"content":    item.Content,
+			"score":      item.Score,
+			"created_at": item.CreatedAt,
+		})
+	}
+
+	cursor.IssuedAt = time.Now().Unix()
+	return writeJSON(w, http.StatusOK, map[string]interface{}{
+		"items":       items,
+		"next_cursor": sealCursor(cursor),
+		"has_more":    hasMore,
+	})
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type mergeResponse struct {
+	Items []struct {
+		ListID uint   `json:"list_id"`
+		Title  string `json:"title"`
+	} `json:"items"`
+	NextCursor string `json:"next_cursor"`
+	HasMore    bool   `json:"has_more"`
+}
+
+// readMerged reads the merged lists page by page and returns the titles of
+// each page, and the cursor after the last one.
+func readMerged(t *testing.T, do func(url string) *httptest.ResponseRecorder, url string, cursor string) ([][]string, string) {
+	t.Helper()
+	var pages [][]string
+	for {
+		rec := do(url + "&cursor=" + cursor)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d but got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+		}
+		var res mergeResponse
+		if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+			t.Fatalf("Error decoding response: %v", err)
+		}
+		titles := []string{}
+		for _, item := range res.Items {
+			titles = append(titles, fmt.Sprintf("%d:%s", item.ListID, item.Title))
+		}
+		pages = append(pages, titles)
+		cursor = res.NextCursor
+		if !res.HasMore {
+			return pages, cursor
+		}
+	}
+}
+
+func TestMergeListsV2(t *testing.T) {
+	useTestDB(t)
+	router := newRouter()
+	do := func(url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+		return rec
+	}
+
+	// Items 1 to 8 go to lists 3, 4 and 5 in turn, list 5 gets every third
+	for i, listID := range []uint{3, 4, 5, 3, 4, 3, 4, 3} {
+		appendTitles(t, listID, i+1, i+1)
+	}
+
+	pages, _ := readMerged(t, do, "/v2/merge?lists=3,4,5,6&limit=3", "")
+	expected := [][]string{{"3:8", "4:7", "3:6"}, {"4:5", "3:4", "5:3"}, {"4:2", "3:1"}}
+	if !reflect.DeepEqual(pages, expected) {
+		t.Errorf("Expected %v but got %v", expected, pages)
+	}
+
+	pages, cursor := readMerged(t, do, "/v2/merge?lists=5,4,3&limit=5&order=oldest", "")
+	expected = [][]string{{"3:1", "4:2", "5:3", "3:4", "4:5"}, {"3:6", "4:7", "3:8"}}
+	if !reflect.DeepEqual(pages, expected) {
+		t.Errorf("Expected %v but got %v", expected, pages)
+	}
+
+	// The cursor remembers where each list of the last page was read to
+	var decoded mergeCursor
+	if err := openCursor(cursor, &decoded); err != nil {
+		t.Fatalf("Error decoding cursor: %v", err)
+	}
+	var lists []uint
+	for _, position := range decoded.Lists {
+		lists = append(lists, position.ListID)
+	}
+	if !reflect.DeepEqual(lists, []uint{3, 4}) || decoded.Merge.ArticleID == 0 {
+		t.Errorf("Unexpected cursor %+v", decoded)
+	}
+
+	// New items show up after the cursor of the oldest first order
+	appendTitles(t, 5, 9, 9)
+	pages, _ = readMerged(t, do, "/v2/merge?lists=5,4,3&order=oldest", cursor)
+	if expected := [][]string{{"5:9"}}; !reflect.DeepEqual(pages, expected) {
+		t.Errorf("Expected %v but got %v", expected, pages)
+	}
+
+	// Lists can come from a group
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("PUT", "/v1/groups/2", strings.NewReader(`{"list_ids": [4, 5]}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d but got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	pages, _ = readMerged(t, do, "/v2/merge?group_id=2&lists=5", "")
+	if expected := [][]string{{"5:9", "4:7", "4:5", "5:3", "4:2"}}; !reflect.DeepEqual(pages, expected) {
+		t.Errorf("Expected %v but got %v", expected, pages)
+	}
+
+	testCases := []struct {
+		name         string
+		url          string
+		expectedCode int
+	}{
+		{"No lists", "/v2/merge", http.StatusBadRequest},
+		{"Invalid lists", "/v2/merge?lists=3,x", http.StatusBadRequest},
+		{"Invalid order", "/v2/merge?lists=3&order=random", http.StatusBadRequest},
+		{"Cursor of another order", "/v2/merge?lists=3&order=newest&cursor=" + cursor, http.StatusBadRequest},
+		{"Invalid cursor", "/v2/merge?lists=3&cursor=abc", http.StatusBadRequest},
+		{"Missing group", "/v2/merge?group_id=7", http.StatusNotFound},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if rec := do(tc.url); rec.Code != tc.expectedCode {
+				t.Errorf("Expected status code %d but got %d: %s", tc.expectedCode, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestMergeListsV2Authorization(t *testing.T) {
+	useTestDB(t)
+	useAuth(t)
+	router := newRouter()
+
+	reader, _ := mintAPIKey(t, "reader", ScopeRead, ScopeWrite)
+	other, _ := mintAPIKey(t, "other", ScopeRead, ScopeWrite)
+	do := func(key, method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	article := `{"title": "Title", "author": "Author", "content": "Content"}`
+	do(reader, "POST", "/v1/lists/3/items", article)
+	do(other, "POST", "/v1/lists/4/items", article)
+
+	if rec := do(reader, "GET", "/v2/merge?lists=3", ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected status code %d but got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	rec := do(reader, "GET", "/v2/merge?lists=3,4", "")
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "lists 4") {
+		t.Errorf("Expected list 4 to be forbidden, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// openAPIDocument describes every route of the HTTP API. Requests are
+// validated against it by validateRequest.
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// apiSpec is the parsed openAPIDocument.
+var apiSpec = mustParseOpenAPISpec(openAPIDocument)
+
+// openAPISpec is the part of an OpenAPI 3 document that is needed to validate
+// requests.
+type openAPISpec struct {
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components struct {
+		Parameters map[string]*openAPIParameter `json:"parameters"`
+		Schemas    map[string]*openAPISchema    `json:"schemas"`
+	} `json:"components"`
+}
+
+type openAPIOperation struct {
+	OperationID string              `json:"operationId"`
+	Parameters  []*openAPIParameter `json:"parameters"`
+	// Security lists the scopes of API key the operation takes, none if it
+	// is public
+	Security    []map[string][]string `json:"security"`
+	RequestBody *struct {
+		Required bool `json:"required"`
+		Content  map[string]struct {
+			Schema *openAPISchema `json:"schema"`
+		} `json:"content"`
+	} `json:"requestBody"`
+}
+
+type openAPIParameter struct {
+	Ref      string         `json:"$ref"`
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required"`
+	Schema   *openAPISchema `json:"schema"`
+}
+
+type openAPISchema struct {
+	Ref        string                    `json:"$ref"`
+	Type       string                    `json:"type"`
+	Format     string                    `json:"format"`
+	Enum       []string                  `json:"enum"`
+	Minimum    *float64                  `json:"minimum"`
+	MinLength  *int                      `json:"minLength"`
+	MaxLength  *int                      `json:"maxLength"`
+	Properties map[string]*openAPISchema `json:"properties"`
+	Required   []string                  `json:"required"`
+	Items      *openAPISchema            `json:"items"`
+	OneOf      []*openAPISchema          `json:"oneOf"`
+}
+
+func mustParseOpenAPISpec(document []byte) *openAPISpec {
+	var spec openAPISpec
+	if err := json.Unmarshal(document, &spec); err != nil {
+		panic(fmt.Sprintf("invalid OpenAPI document: %v", err))
+	}
+	return &spec
+}
+
+// parameter resolves a reference to a parameter of the components.
+func (spec *openAPISpec) parameter(p *openAPIParameter) *openAPIParameter {
+	if strings.HasPrefix(p.Ref, "#/components/parameters/") {
+		return spec.Components.Parameters[strings.TrimPrefix(p.Ref, "#/components/parameters/")]
+	}
+	return p
+}
+
+// schema resolves a reference to a schema of the components.
+func (spec *openAPISpec) schema(s *openAPISchema) *openAPISchema {
+	if strings.HasPrefix(s.Ref, "#/components/schemas/") {
+		return spec.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	}
+	return s
+}
+
+// operation finds the operation of the route the request was matched to, or
+// nil if the spec does not have one.
+func (spec *openAPISpec) operation(r *http.Request) *openAPIOperation {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return nil
+	}
+	path, err := route.GetPathTemplate()
+	if err != nil {
+		return nil
+	}
+	return spec.Paths[path][strings.ToLower(r.Method)]
+}
+
+// validateParameters checks the path, query and header parameters of the
+// request. An empty parameter is treated as a missing one.
+func (spec *openAPISpec) validateParameters(op *openAPIOperation, r *http.Request) error {
+	for _, p := range op.Parameters {
+		p = spec.parameter(p)
+		var value, kind string
+		switch p.In {
+		case "path":
+			value, kind = mux.Vars(r)[p.Name], "parameter"
+		case "query":
+			value, kind = r.URL.Query().Get(p.Name), "parameter"
+		case "header":
+			value, kind = r.Header.Get(p.Name), "header"
+		}
+		if value == "" {
+			if p.Required {
+				return fmt.Errorf("%s %s is missing", p.Name, kind)
+			}
+			continue
+		}
+		if p.Schema == nil {
+			continue
+		}
+		if err := spec.validateParameter(value, spec.schema(p.Schema)); err != nil {
+			return fmt.Errorf("%s %s %v", p.Name, kind, err)
+		}
+	}
+	return nil
+}
+
+// validateParameter checks the value of a parameter against its schema.
+func (spec *openAPISpec) validateParameter(value string, s *openAPISchema) error {
+	switch s.Type {
+	case "integer":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("is not a valid integer")
+		}
+		if s.Minimum != nil && float64(n) < *s.Minimum {
+			return fmt.Errorf("must be at least %v", *s.Minimum)
+		}
+	case "boolean":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("is not a valid boolean")
+		}
+	case "string":
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, value); err != nil {
+				return fmt.Errorf("is invalid, expected an RFC 3339 time")
+			}
+		}
+		return validateString(value, s)
+	}
+	return nil
+}
+
+func validateString(value string, s *openAPISchema) error {
+	if s.MinLength != nil && utf8.RuneCountInString(strings.TrimSpace(value)) < *s.MinLength {
+		if *s.MinLength == 1 {
+			return fmt.Errorf("is required")
+		}
+		return fmt.Errorf("must be at least %d characters", *s.MinLength)
+	}
+	if s.MaxLength != nil && utf8.RuneCountInString(value) > *s.MaxLength {
+		return fmt.Errorf("must be at most %d characters", *s.MaxLength)
+	}
+	if len(s.Enum) > 0 {
+		for _, allowed := range s.Enum {
+			if value == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(s.Enum, ", "))
+	}
+	return nil
+}
+
+// validateBody checks the JSON body of the request against the schema of the
+// operation, returning a *ValidationError with every field that does not
+// match. The body is read and put back for the handler.
+func (spec *openAPISpec) validateBody(op *openAPIOperation, w http.ResponseWriter, r *http.Request) error {
+	if op.RequestBody == nil {
+		return nil
+	}
+	media, ok := op.RequestBody.Content["application/json"]
+	if !ok || media.Schema == nil {
+		return nil
+	}
+
+	body, err := readBody(w, r)
+	if err != nil {
+		return err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if len(bytes.TrimSpace(body)) == 0 {
+		if op.RequestBody.Required {
+			return &ValidationError{Fields: []FieldError{{"body", "is required"}}}
+		}
+		return nil
+	}
+
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Errorf("invalid request body: %v", err)
+	}
+	var errs []FieldError
+	spec.validateValue(value, media.Schema, "", &errs)
+	if len(errs) > 0 {
+		return &ValidationError{Fields: errs}
+	}
+	return nil
+}
+
+// jsonType is the schema type of a decoded JSON value.
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	}
+	return "null"
+}
+
+// validateValue checks a decoded JSON value against a schema, appending the
+// fields that do not match to errs. at is the path to the value, empty for
+// the whole body. Alternatives of a oneOf are told apart by their type.
+func (spec *openAPISpec) validateValue(value interface{}, s *openAPISchema, at string, errs *[]FieldError) {
+	s = spec.schema(s)
+	field := at
+	if field == "" {
+		field = "body"
+	}
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, FieldError{field, fmt.Sprintf(format, args...)})
+	}
+
+	if len(s.OneOf) > 0 {
+		var types []string
+		for _, alternative := range s.OneOf {
+			alternative = spec.schema(alternative)
+			if alternative.Type == jsonType(value) || (alternative.Type == "number" && jsonType(value) == "integer") {
+				spec.validateValue(value, alternative, at, errs)
+				return
+			}
+			types = append(types, alternative.Type)
+		}
+		fail("must be one of the types %s", strings.Join(types, ", "))
+		return
+	}
+
+	valueType := jsonType(value)
+	if s.Type != "" && s.Type != valueType && !(s.Type == "number" && valueType == "integer") {
+		article := "a"
+		if s.Type == "object" || s.Type == "array" || s.Type == "integer" {
+			article = "an"
+		}
+		fail("must be %s %s", article, s.Type)
+		return
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				*errs = append(*errs, FieldError{fieldPath(at, name), "is required"})
+			}
+		}
+		names := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if property, ok := v[name]; ok {
+				spec.validateValue(property, s.Properties[name], fieldPath(at, name), errs)
+			}
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				spec.validateValue(item, s.Items, fmt.Sprintf("%s[%d]", at, i), errs)
+			}
+		}
+	case string:
+		if err := validateString(v, s); err != nil {
+			fail("%v", err)
+		}
+	case json.Number:
+		if n, err := v.Float64(); err == nil && s.Minimum != nil && n < *s.Minimum {
+			fail("must be at least %v", *s.Minimum)
+		}
+	}
+}
+
+// validateRequest is a middleware that answers 400 to requests whose
+// parameters or body do not match the OpenAPI spec of their route.
+func validateRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if op := apiSpec.operation(r); op != nil {
+			err := apiSpec.validateParameters(op, r)
+			if err == nil {
+				err = apiSpec.validateBody(op, w, r)
+			}
+			if err != nil {
+				if !writeRequestError(w, err) {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+				}
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIDocument)
+}
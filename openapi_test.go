@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestOpenAPISpecMatchesRoutes(t *testing.T) {
+	routes := make(map[string]bool)
+	err := newRouter().Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return err
+		}
+		for _, method := range methods {
+			routes[strings.ToLower(method)+" "+path] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error walking routes: %v", err)
+	}
+
+	documented := make(map[string]bool)
+	for path, operations := range apiSpec.Paths {
+		for method, op := range operations {
+			documented[method+" "+path] = true
+			if op.OperationID == "" {
+				t.Errorf("%s %s has no operationId", method, path)
+			}
+			for _, p := range op.Parameters {
+				if apiSpec.parameter(p) == nil {
+					t.Errorf("%s %s refers to unknown parameter %s", method, path, p.Ref)
+				}
+			}
+		}
+	}
+
+	var missing, extra []string
+	for route := range routes {
+		if !documented[route] {
+			missing = append(missing, route)
+		}
+	}
+	for route := range documented {
+		if !routes[route] {
+			extra = append(extra, route)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(extra)
+	if len(missing) > 0 {
+		t.Errorf("Routes missing from openapi.json: %v", missing)
+	}
+	if len(extra) > 0 {
+		t.Errorf("Operations in openapi.json without a route: %v", extra)
+	}
+}
+
+func TestServeOpenAPI(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, httptest.NewRequest("GET", "/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d but got %d", http.StatusOK, rec.Code)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("Error decoding document: %v", err)
+	}
+	if doc["openapi"] != "3.0.3" {
+		t.Errorf("Unexpected openapi version %v", doc["openapi"])
+	}
+}
+
+func TestValidateRequest(t *testing.T) {
+	router := newRouter()
+
+	testCases := []struct {
+		name         string
+		method       string
+		url          string
+		body         string
+		header       map[string]string
+		expectedBody string
+	}{
+		{"Missing query parameter", "GET", "/list/get", "", nil, "list_id parameter is missing"},
+		{"Invalid integer", "GET", "/page/get?page_id=abc", "", nil, "page_id parameter is not a valid integer"},
+		{"Path parameter below minimum", "GET", "/v1/lists/0", "", nil, "id parameter must be at least 1"},
+		{"Invalid enum", "GET", "/webhooks/1/deliveries?status=lost", "", nil, "status parameter must be one of pending, delivered, dead"},
+		{"Invalid date-time", "GET", "/lists/1/articles?created_after=yesterday", "", nil, "created_after parameter is invalid"},
+		{"Missing required string", "GET", "/search?q=", "", nil, "q parameter is missing"},
+		{"Header too long", "POST", "/page/set", `{}`, map[string]string{"Idempotency-Key": strings.Repeat("k", 256)}, "Idempotency-Key header must be at most 255 characters"},
+		{"Missing body", "POST", "/v1/lists/1/items", "", nil, `{"field":"body","message":"is required"}`},
+		{"Malformed body", "POST", "/page/set", `{"title":`, nil, "invalid request body"},
+		{"Wrong property type", "POST", "/page/set", `{"title": 5}`, nil, `{"field":"title","message":"must be a string"}`},
+		{"Wrong item type", "POST", "/page/update?page_id=1", `[{"title": "a"}, {"author": true}]`, nil, `{"field":"[1].author","message":"must be a string"}`},
+		{"Missing required property", "POST", "/lists/1/webhooks", `{"events": ["append"]}`, nil, `{"field":"url","message":"is required"}`},
+		{"Invalid array item", "POST", "/lists/1/webhooks", `{"url": "http://example.com", "events": ["nope"]}`, nil, `{"field":"events[0]","message":"must be one of`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+			for k, v := range tc.header {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("Expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), tc.expectedBody) {
+				t.Errorf("Expected body to contain %q but got %q", tc.expectedBody, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestValidateRequestKeepsBody(t *testing.T) {
+	useTestDB(t)
+	router := newRouter()
+
+	req := httptest.NewRequest("POST", "/v1/lists/1/items", strings.NewReader(`{"title": "Kept", "author": "A", "content": "C"}`))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d but got %d: %s", http.StatusCreated, rec.Code, rec.Body.String())
+	}
+
+	var articles []Article
+	if err := db.Where("title = ?", "Kept").Find(&articles).Error; err != nil {
+		t.Fatalf("Error fetching articles: %v", err)
+	}
+	if len(articles) != 1 || articles[0].Author != "A" {
+		t.Errorf("Unexpected articles %+v", articles)
+	}
+}
@@ -1,6 +1,11 @@
 package main
 
-import "gorm.io/gorm"
+import (
+	"database/sql"
+	"time"
+
+	"gorm.io/gorm"
+)
 
 func getPageByID(db *gorm.DB, id uint, page *Page) error {
 	if err := db.Table("pages").First(page, id).Error; err != nil {
@@ -14,6 +19,31 @@ func getLastPage(db *gorm.DB, lastPage *Page) error {
 	return db.Table("pages").Last(lastPage).Error
 }
 
+// GetLastPageByListID gets the tail of the List, the Page that does not point
+// to a next Page.
+func getLastPageByListID(db *gorm.DB, listID uint, lastPage *Page) error {
+	return db.Table("pages").Where("list_id = ? AND next_page_id = 0", listID).Order("id DESC").First(lastPage).Error
+}
+
+// GetHeadPage gets the Page the List points to. It returns
+// gorm.ErrRecordNotFound if the List has no pages.
+func getHeadPage(db *gorm.DB, list *List, head *Page) error {
+	if list.NextPageID == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return getPageByID(db, list.NextPageID, head)
+}
+
+// GetPreviousPage gets the Page of the List that points to the given Page.
+func getPreviousPage(db *gorm.DB, listID uint, pageID uint, previous *Page) error {
+	return db.Table("pages").Where("list_id = ? AND next_page_id = ?", listID, pageID).First(previous).Error
+}
+
+// CountPagesByListID counts the Pages of the given List.
+func countPagesByListID(db *gorm.DB, listID uint, count *int64) error {
+	return db.Table("pages").Where("list_id = ?", listID).Count(count).Error
+}
+
 func getArticlesByPageID(db *gorm.DB, pageID uint, articles *[]Article) error {
 	if err := db.Table("articles").Where("page_id = ?", pageID).Find(articles).Error; err != nil {
 		return err
@@ -21,6 +51,74 @@ func getArticlesByPageID(db *gorm.DB, pageID uint, articles *[]Article) error {
 	return nil
 }
 
+// GetPageWithArticles gets the Page and its Articles with a single query, in
+// the order of its List: newest first in prepend mode, highest score first
+// in sorted mode, and oldest first otherwise.
+func getPageWithArticles(db *gorm.DB, id uint, page *Page) error {
+	rows, err := db.Table("pages").
+		Select("pages.id, pages.created_at, pages.updated_at, pages.list_id, pages.next_page_id, pages.version, lists.mode, "+
+			"articles.id, articles.created_at, articles.updated_at, articles.title, articles.author, articles.content, articles.score").
+		Joins("LEFT JOIN lists ON lists.id = pages.list_id").
+		Joins("LEFT JOIN articles ON articles.page_id = pages.id").
+		Where("pages.id = ?", id).
+		// Articles of unsorted Lists all score 0, which keeps them in the
+		// order they were added
+		Order("articles.score DESC").
+		Order("articles.id").
+		Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	found := false
+	*page = Page{}
+	var mode sql.NullString
+	for rows.Next() {
+		// The article columns are NULL for a page without articles
+		var articleID sql.NullInt64
+		var createdAt, updatedAt sql.NullTime
+		var title, author, content sql.NullString
+		var score sql.NullFloat64
+		err := rows.Scan(&page.ID, &page.CreatedAt, &page.UpdatedAt, &page.ListID, &page.NextPageID, &page.Version, &mode,
+			&articleID, &createdAt, &updatedAt, &title, &author, &content, &score)
+		if err != nil {
+			return err
+		}
+		found = true
+
+		if articleID.Valid {
+			page.Articles = append(page.Articles, Article{
+				ID:        uint(articleID.Int64),
+				CreatedAt: createdAt.Time,
+				UpdatedAt: updatedAt.Time,
+				Title:     title.String,
+				Author:    author.String,
+				Content:   content.String,
+				Score:     score.Float64,
+				PageID:    page.ID,
+			})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if !found {
+		return gorm.ErrRecordNotFound
+	}
+	if mode.String == ListModePrepend {
+		reverseArticles(page.Articles)
+	}
+	return nil
+}
+
+// reverseArticles reverses the order of the articles in place.
+func reverseArticles(articles []Article) {
+	for i, j := 0, len(articles)-1; i < j; i, j = i+1, j-1 {
+		articles[i], articles[j] = articles[j], articles[i]
+	}
+}
+
 // PreloadArticles preloads the Articles associated with the Page in the database.
 func preloadArticles(db *gorm.DB, page *Page) error {
 	return db.Preload("Articles").First(page, page.ID).Error
@@ -31,6 +129,14 @@ func createPage(db *gorm.DB, page *Page) error {
 	return db.Table("pages").Create(page).Error
 }
 
+// DeletePageByID deletes the Page and its Articles.
+func deletePageByID(db *gorm.DB, pageID uint) error {
+	if err := deleteArticlesByPageID(db, pageID); err != nil {
+		return err
+	}
+	return db.Where("id = ?", pageID).Delete(&Page{}).Error
+}
+
 // UpdateLastPageNextPageID updates the NextPageID of the last Page in the database.
 func updateLastPageNextPageID(db *gorm.DB, lastPage *Page, newPageID uint) error {
 	return db.Model(lastPage).UpdateColumn("next_page_id", newPageID).Error
@@ -54,3 +160,24 @@ func deletePagesByListID(db *gorm.DB, listID uint) error {
 
 	return nil
 }
+
+// IncrementPageVersion bumps the version of the Page after it has changed.
+func incrementPageVersion(db *gorm.DB, pageID uint) error {
+	return db.Table("pages").Where("id = ?", pageID).UpdateColumns(map[string]interface{}{"version": gorm.Expr("version + 1"), "updated_at": time.Now()}).Error
+}
+
+// BumpPageVersion moves the Page from the version it was loaded with to the
+// next one. It returns errVersionConflict if the Page has been changed since.
+func bumpPageVersion(db *gorm.DB, page *Page) error {
+	now := time.Now()
+	res := db.Table("pages").Where("id = ? AND version = ?", page.ID, page.Version).UpdateColumns(map[string]interface{}{"version": page.Version + 1, "updated_at": now})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errVersionConflict
+	}
+	page.Version++
+	page.UpdatedAt = now
+	return nil
+}
@@ -310,3 +310,87 @@ func TestDeletePagesByListID(t *testing.T) {
 		t.Errorf("Expected 0 pages after deletion, but got %d", count)
 	}
 }
+
+func TestGetPageWithArticles(t *testing.T) {
+	// Initialize a new in-memory SQLite database
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Migrate the schema
+	db.AutoMigrate(&List{}, &Page{}, &Article{})
+
+	// Create a Page with two Articles and a Page without any
+	page := &Page{ListID: 1, NextPageID: 2}
+	db.Create(page)
+	emptyPage := &Page{ListID: 1}
+	db.Create(emptyPage)
+	article1 := &Article{Title: "Test Article 1", Author: "John Doe", Content: "Test content 1", PageID: page.ID}
+	db.Create(article1)
+	article2 := &Article{Title: "Test Article 2", Author: "Jane Smith", Content: "Test content 2", PageID: page.ID}
+	db.Create(article2)
+
+	var result Page
+	if err := getPageWithArticles(db, page.ID, &result); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.ID != page.ID || result.ListID != page.ListID || result.NextPageID != page.NextPageID || result.Version != 1 {
+		t.Errorf("Retrieved page does not match original page: %+v", result)
+	}
+	if len(result.Articles) != 2 {
+		t.Fatalf("expected 2 articles, got %d", len(result.Articles))
+	}
+	if result.Articles[0].Title != article1.Title || result.Articles[1].Content != article2.Content {
+		t.Errorf("unexpected articles: %+v", result.Articles)
+	}
+
+	// A page without articles is still found
+	if err := getPageWithArticles(db, emptyPage.ID, &result); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.ID != emptyPage.ID || len(result.Articles) != 0 {
+		t.Errorf("expected empty page %d, got %+v", emptyPage.ID, result)
+	}
+
+	// A missing page is reported as not found
+	if err := getPageWithArticles(db, 99, &result); err != gorm.ErrRecordNotFound {
+		t.Errorf("expected ErrRecordNotFound, got %v", err)
+	}
+}
+
+func TestGetLastPageByListID(t *testing.T) {
+	// Initialize a new in-memory SQLite database
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Migrate the database schema
+	db.AutoMigrate(&List{}, &Page{}, &Article{})
+
+	// Chain two pages in list 1 and add a page to list 2 after them
+	page1 := &Page{ListID: 1}
+	page2 := &Page{ListID: 1}
+	other := &Page{ListID: 2}
+	for _, page := range []*Page{page1, page2, other} {
+		if err := createPage(db, page); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := updateLastPageNextPageID(db, page1, page2.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	var lastPage Page
+	if err := getLastPageByListID(db, 1, &lastPage); err != nil {
+		t.Fatal(err)
+	}
+	if lastPage.ID != page2.ID {
+		t.Errorf("Expected last page %d of list 1, got %d", page2.ID, lastPage.ID)
+	}
+
+	if err := getLastPageByListID(db, 3, &lastPage); err != gorm.ErrRecordNotFound {
+		t.Errorf("Expected ErrRecordNotFound for an empty list, got %v", err)
+	}
+}
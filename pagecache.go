@@ -0,0 +1,143 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+// cachedPages holds fully assembled pages in front of the database. A cache
+// without capacity is disabled: lookups miss and writes are ignored, but
+// invalidations still change its generation.
+var cachedPages = newPageCache(1024)
+
+// pageCache is a bounded LRU cache of pages, including their articles, keyed
+// by page ID.
+type pageCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	items    map[uint]*list.Element
+	// gen changes on every invalidation, so that a page read from the
+	// database before a write is not cached after it
+	gen uint64
+
+	hits   uint64
+	misses uint64
+}
+
+// PageCacheStats is a snapshot of the cache's size and hit counters.
+type PageCacheStats struct {
+	Capacity int    `json:"capacity"`
+	Size     int    `json:"size"`
+	Hits     uint64 `json:"hits"`
+	Misses   uint64 `json:"misses"`
+}
+
+// NewPageCache creates a cache holding up to capacity pages. It is disabled
+// if capacity is not positive.
+func newPageCache(capacity int) *pageCache {
+	if capacity < 0 {
+		capacity = 0
+	}
+	return &pageCache{
+		capacity: capacity,
+		order:    list.New(),
+		items:    make(map[uint]*list.Element),
+	}
+}
+
+// Get copies the cached page with the given ID into page and reports whether
+// it was found.
+func (c *pageCache) get(id uint, page *Page) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[id]
+	if !ok {
+		c.misses++
+		return false
+	}
+	c.order.MoveToFront(elem)
+	c.hits++
+	copyPage(page, elem.Value.(*Page))
+	return true
+}
+
+// Generation returns a token to pass to add after reading a page from the
+// database.
+func (c *pageCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// Add stores a copy of the page, unless the cache was invalidated since gen
+// was taken, evicting the least recently used page if the cache is full.
+func (c *pageCache) add(page *Page, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen || c.capacity == 0 {
+		return
+	}
+
+	cached := new(Page)
+	copyPage(cached, page)
+	if elem, ok := c.items[page.ID]; ok {
+		elem.Value = cached
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.items[page.ID] = c.order.PushFront(cached)
+
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*Page).ID)
+	}
+}
+
+// Invalidate removes the pages with the given IDs.
+func (c *pageCache) invalidate(ids ...uint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	for _, id := range ids {
+		if elem, ok := c.items[id]; ok {
+			c.order.Remove(elem)
+			delete(c.items, id)
+		}
+	}
+}
+
+// InvalidateList removes every page belonging to the given list.
+func (c *pageCache) invalidateList(listID uint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	for id, elem := range c.items {
+		if elem.Value.(*Page).ListID == listID {
+			c.order.Remove(elem)
+			delete(c.items, id)
+		}
+	}
+}
+
+func (c *pageCache) stats() PageCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return PageCacheStats{
+		Capacity: c.capacity,
+		Size:     c.order.Len(),
+		Hits:     c.hits,
+		Misses:   c.misses,
+	}
+}
+
+// copyPage copies src into dst without sharing the Articles slice.
+func copyPage(dst *Page, src *Page) {
+	*dst = *src
+	dst.Articles = append([]Article(nil), src.Articles...)
+}
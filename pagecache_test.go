@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPageCacheEviction(t *testing.T) {
+	cache := newPageCache(2)
+
+	cache.add(&Page{ID: 1, ListID: 1}, cache.generation())
+	cache.add(&Page{ID: 2, ListID: 1}, cache.generation())
+
+	// Touch page 1 so that page 2 becomes the least recently used
+	var page Page
+	if !cache.get(1, &page) {
+		t.Fatalf("Expected page 1 to be cached")
+	}
+	cache.add(&Page{ID: 3, ListID: 2}, cache.generation())
+
+	if cache.get(2, &page) {
+		t.Errorf("Expected page 2 to be evicted")
+	}
+	if !cache.get(1, &page) || !cache.get(3, &page) {
+		t.Errorf("Expected pages 1 and 3 to be cached")
+	}
+
+	stats := cache.stats()
+	if stats.Size != 2 || stats.Hits != 3 || stats.Misses != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	// Invalidating a list removes only its pages
+	cache.invalidateList(1)
+	if cache.get(1, &page) {
+		t.Errorf("Expected page 1 to be invalidated")
+	}
+	if !cache.get(3, &page) {
+		t.Errorf("Expected page 3 of another list to stay cached")
+	}
+}
+
+func TestPageCacheStaleAdd(t *testing.T) {
+	cache := newPageCache(10)
+
+	// A page read before an invalidation must not be cached after it
+	gen := cache.generation()
+	cache.invalidate(1)
+	cache.add(&Page{ID: 1}, gen)
+
+	var page Page
+	if cache.get(1, &page) {
+		t.Errorf("Expected stale page not to be cached")
+	}
+}
+
+func TestPageCacheDisabled(t *testing.T) {
+	cache := newPageCache(0)
+
+	gen := cache.generation()
+	cache.add(&Page{ID: 1}, gen)
+	var page Page
+	if cache.get(1, &page) {
+		t.Errorf("Expected a disabled cache to miss")
+	}
+
+	// Loads keyed on the generation must still tell reads before a write
+	// from reads after it
+	cache.invalidate(1)
+	if cache.generation() == gen {
+		t.Errorf("Expected an invalidation to change the generation")
+	}
+	gen = cache.generation()
+	cache.invalidateList(1)
+	if cache.generation() == gen {
+		t.Errorf("Expected a list invalidation to change the generation")
+	}
+}
+
+func TestHandleGetPageCached(t *testing.T) {
+	useTestDB(t)
+
+	addArticleToPage(Article{Title: "First", Author: "Author", Content: "Content"})
+
+	get := func() string {
+		rec := httptest.NewRecorder()
+		handleGetPage(rec, httptest.NewRequest("GET", "/page/get?page_id=1", nil))
+		return rec.Body.String()
+	}
+
+	get()
+	get()
+	stats := cachedPages.stats()
+	if stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("Expected 1 hit and 1 miss, got %+v", stats)
+	}
+
+	// An append invalidates the cached page
+	addArticleToPage(Article{Title: "Second", Author: "Author", Content: "Content"})
+	if body := get(); !strings.Contains(body, "Second") {
+		t.Errorf("Expected the appended article in %q", body)
+	}
+}
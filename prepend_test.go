@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPrependMode(t *testing.T) {
+	prepend := ListModePrepend
+
+	testCases := []struct {
+		name     string
+		settings ListSettings
+		appended int
+		expected [][]string
+	}{
+		{"Uncapped", ListSettings{Mode: &prepend}, 12, [][]string{
+			{"12", "11"}, {"10", "9", "8", "7", "6"}, {"5", "4", "3", "2", "1"},
+		}},
+		{"Max pages", ListSettings{Mode: &prepend, MaxPages: newInt64(2)}, 12, [][]string{
+			{"12", "11"}, {"10", "9", "8", "7", "6"},
+		}},
+		{"Max items", ListSettings{Mode: &prepend, MaxItems: newInt64(8)}, 12, [][]string{
+			{"12", "11"}, {"10", "9", "8", "7", "6"}, {"5"},
+		}},
+		{"Max items on one page", ListSettings{Mode: &prepend, MaxItems: newInt64(3)}, 5, [][]string{
+			{"5", "4", "3"},
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			useTestDB(t)
+			if _, err := updateListSettings(7, tc.settings, nil); err != nil {
+				t.Fatalf("Error setting up list: %v", err)
+			}
+			appendTitles(t, 7, 1, tc.appended)
+
+			if titles := listTitles(t, 7); !reflect.DeepEqual(titles, tc.expected) {
+				t.Errorf("Expected %v but got %v", tc.expected, titles)
+			}
+			var tail Page
+			if err := getLastPageByListID(db, 7, &tail); err != nil {
+				t.Fatalf("Error fetching the tail: %v", err)
+			}
+			var tailPage Page
+			if err := loadPage(tail.ID, &tailPage); err != nil || len(tailPage.Articles) != len(tc.expected[len(tc.expected)-1]) {
+				t.Errorf("Expected a single tail, the last page of the chain, got %+v (%v)", tailPage, err)
+			}
+		})
+	}
+}
+
+func TestPrependModeHeadSwap(t *testing.T) {
+	useTestDB(t)
+	router := newRouter()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("PATCH", "/v1/lists/7", strings.NewReader(`{"mode": "prepend"}`)))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"mode":"prepend"`) {
+		t.Fatalf("Expected the list to be in prepend mode, got %d: %s", rec.Code, rec.Body.String())
+	}
+	appendTitles(t, 7, 1, NumberOfArticleInOnePage)
+	var before List
+	if err := getListByID(db, 7, &before); err != nil {
+		t.Fatalf("Error fetching list: %v", err)
+	}
+
+	_, events, cancel := listEvents.subscribe(7, 0)
+	defer cancel()
+	page, err := appendArticle(7, Article{Title: "Newest", Author: "Author", Content: "Content"})
+	if err != nil {
+		t.Fatalf("Error appending article: %v", err)
+	}
+
+	// The list points at the new head, which points at the old one
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/list/get?list_id=7", nil))
+	var head struct {
+		NextPageID uint `json:"next_page_id"`
+	}
+	json.NewDecoder(rec.Body).Decode(&head)
+	if head.NextPageID != page.ID || page.NextPageID != before.NextPageID {
+		t.Errorf("Expected page %d to be the head in front of page %d, got head %d and page %+v", page.ID, before.NextPageID, head.NextPageID, page)
+	}
+
+	var published []string
+	for len(events) > 0 {
+		event := <-events
+		published = append(published, event.Type)
+		if event.Type == EventHeadSwap && event.PageID != page.ID {
+			t.Errorf("Expected the head swap to point at page %d, got %d", page.ID, event.PageID)
+		}
+	}
+	if expected := []string{EventHeadSwap, EventAppend}; !reflect.DeepEqual(published, expected) {
+		t.Errorf("Expected events %v but got %v", expected, published)
+	}
+
+	testCases := []struct {
+		name         string
+		body         string
+		expectedCode int
+	}{
+		{"Same mode", `{"mode": "prepend"}`, http.StatusOK},
+		{"Other mode with pages", `{"mode": "append"}`, http.StatusConflict},
+		{"Unknown mode", `{"mode": "random"}`, http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest("PATCH", "/v1/lists/7", strings.NewReader(tc.body)))
+			if rec.Code != tc.expectedCode {
+				t.Errorf("Expected status code %d but got %d: %s", tc.expectedCode, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	// The mode can change once the list is empty again
+	if _, err := deleteList(7, nil); err != nil {
+		t.Fatalf("Error deleting list: %v", err)
+	}
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("PATCH", "/v1/lists/7", strings.NewReader(`{"mode": "append"}`)))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status code %d but got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+}
+
+func TestPrependModeItems(t *testing.T) {
+	useTestDB(t)
+	router := newRouter()
+	prepend := ListModePrepend
+	if _, err := updateListSettings(7, ListSettings{Mode: &prepend}, nil); err != nil {
+		t.Fatalf("Error setting up list: %v", err)
+	}
+	appendTitles(t, 7, 1, 12)
+
+	// Cursor reads follow the chain, newest first
+	var titles []string
+	cursor := ""
+	for {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", "/v2/lists/7/items?limit=5&cursor="+cursor, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d but got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+		}
+		var res struct {
+			Items []struct {
+				Title string `json:"title"`
+			} `json:"items"`
+			NextCursor string `json:"next_cursor"`
+			HasMore    bool   `json:"has_more"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+			t.Fatalf("Error decoding response: %v", err)
+		}
+		for _, item := range res.Items {
+			titles = append(titles, item.Title)
+		}
+		cursor = res.NextCursor
+		if !res.HasMore {
+			break
+		}
+	}
+	expected := []string{"12", "11", "10", "9", "8", "7", "6", "5", "4", "3", "2", "1"}
+	if !reflect.DeepEqual(titles, expected) {
+		t.Errorf("Expected %v but got %v", expected, titles)
+	}
+
+	// A replaced page keeps the order it was given in
+	var list List
+	if err := getListByID(db, 7, &list); err != nil {
+		t.Fatalf("Error fetching list: %v", err)
+	}
+	_, err := updatePage(list.NextPageID, []Article{{Title: "A"}, {Title: "B"}, {Title: "C"}}, nil)
+	if err != nil {
+		t.Fatalf("Error replacing page: %v", err)
+	}
+	if head := listTitles(t, 7)[0]; !reflect.DeepEqual(head, []string{"A", "B", "C"}) {
+		t.Errorf("Expected the head page to be [A B C] but got %v", head)
+	}
+}
@@ -0,0 +1,561 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// States of a publish job and of its targets. A job is running until every
+// target was appended to or failed, and then ends up done, partial or failed.
+const (
+	PublishRunning       = "running"
+	PublishDone          = "done"
+	PublishPartial       = "partial"
+	PublishFailed        = "failed"
+	PublishTargetPending = "pending"
+	PublishTargetDone    = "done"
+	PublishTargetFailed  = "failed"
+)
+
+var (
+	// publishWorkers is how many targets the publisher appends to at once.
+	publishWorkers = 8
+	// publishBatchSize is how many pending targets the publisher loads at a
+	// time.
+	publishBatchSize = 100
+	// maxPublishTargets is the most Lists one article can be published to,
+	// and the most members of a group.
+	maxPublishTargets = 10000
+	// maxPublishFailures is how many failed targets are listed with a job.
+	maxPublishFailures = 100
+	// publishWake wakes the publisher when there are new pending targets.
+	publishWake = make(chan struct{}, 1)
+)
+
+// createPublishJob creates the job with a pending target for each List.
+func createPublishJob(db *gorm.DB, job *PublishJob, listIDs []uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Table("publish_jobs").Create(job).Error; err != nil {
+			return err
+		}
+		targets := make([]PublishTarget, len(listIDs))
+		for i, listID := range listIDs {
+			targets[i] = PublishTarget{JobID: job.ID, ListID: listID, Status: PublishTargetPending}
+		}
+		return tx.Table("publish_targets").CreateInBatches(targets, 500).Error
+	})
+}
+
+// getPublishJobByID gets the job along with its progress.
+func getPublishJobByID(db *gorm.DB, id uint, job *PublishJob) error {
+	if err := db.Table("publish_jobs").First(job, id).Error; err != nil {
+		return err
+	}
+	return countPublishTargets(db, job)
+}
+
+// countPublishTargets fills in the status and the counters of the job from
+// its targets, and when they last changed.
+func countPublishTargets(db *gorm.DB, job *PublishJob) error {
+	var counts []struct {
+		Status string
+		Count  int
+	}
+	err := db.Table("publish_targets").Select("status, COUNT(*) AS count").
+		Where("job_id = ?", job.ID).Group("status").Scan(&counts).Error
+	if err != nil {
+		return err
+	}
+	job.Succeeded, job.Failed = 0, 0
+	for _, count := range counts {
+		switch count.Status {
+		case PublishTargetDone:
+			job.Succeeded = count.Count
+		case PublishTargetFailed:
+			job.Failed = count.Count
+		}
+	}
+	switch {
+	case job.Succeeded+job.Failed < job.Total:
+		job.Status = PublishRunning
+	case job.Failed == 0:
+		job.Status = PublishDone
+	case job.Succeeded == 0:
+		job.Status = PublishFailed
+	default:
+		job.Status = PublishPartial
+	}
+
+	var latest []PublishTarget
+	err = db.Table("publish_targets").Where("job_id = ?", job.ID).Order("updated_at DESC").Limit(1).Find(&latest).Error
+	if err != nil {
+		return err
+	}
+	if len(latest) > 0 && latest[0].UpdatedAt.After(job.UpdatedAt) {
+		job.UpdatedAt = latest[0].UpdatedAt
+	}
+	return nil
+}
+
+// GetPendingPublishTargets gets up to limit pending targets, oldest first.
+func getPendingPublishTargets(db *gorm.DB, limit int, targets *[]PublishTarget) error {
+	return db.Table("publish_targets").Where("status = ?", PublishTargetPending).
+		Order("id").Limit(limit).Find(targets).Error
+}
+
+// GetPublishTargets gets up to limit targets of the job in the status.
+func getPublishTargets(db *gorm.DB, jobID uint, status string, limit int, targets *[]PublishTarget) error {
+	return db.Table("publish_targets").Where("job_id = ? AND status = ?", jobID, status).
+		Order("id").Limit(limit).Find(targets).Error
+}
+
+// finishPublishTarget saves the target once it was appended to or failed.
+func finishPublishTarget(db *gorm.DB, target *PublishTarget) error {
+	return db.Table("publish_targets").Save(target).Error
+}
+
+// retryPublishTargets makes the failed targets of the job pending again and
+// returns how many there were.
+func retryPublishTargets(db *gorm.DB, jobID uint) (int64, error) {
+	res := db.Table("publish_targets").Where("job_id = ? AND status = ?", jobID, PublishTargetFailed).
+		UpdateColumns(map[string]interface{}{"status": PublishTargetPending, "error": "", "updated_at": time.Now()})
+	return res.RowsAffected, res.Error
+}
+
+func getListGroupByID(db *gorm.DB, id uint, group *ListGroup) error {
+	return db.Table("list_groups").First(group, id).Error
+}
+
+// getListGroupMembers gets the IDs of the Lists in the group.
+func getListGroupMembers(db *gorm.DB, groupID uint, listIDs *[]uint) error {
+	return db.Table("list_group_members").Where("group_id = ?", groupID).Order("list_id").Pluck("list_id", listIDs).Error
+}
+
+// saveListGroup saves the group and replaces its members.
+func saveListGroup(db *gorm.DB, group *ListGroup, listIDs []uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Table("list_groups").Save(group).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("group_id = ?", group.ID).Delete(&ListGroupMember{}).Error; err != nil {
+			return err
+		}
+		if len(listIDs) == 0 {
+			return nil
+		}
+		members := make([]ListGroupMember, len(listIDs))
+		for i, listID := range listIDs {
+			members[i] = ListGroupMember{GroupID: group.ID, ListID: listID}
+		}
+		return tx.Table("list_group_members").CreateInBatches(members, 500).Error
+	})
+}
+
+// deleteListGroup deletes the group and its members.
+func deleteListGroup(db *gorm.DB, id uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("group_id = ?", id).Delete(&ListGroupMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&ListGroup{}, id).Error
+	})
+}
+
+// wakePublisher tells the publisher there are new pending targets, without
+// waiting for it.
+func wakePublisher() {
+	select {
+	case publishWake <- struct{}{}:
+	default:
+	}
+}
+
+// publishToTarget appends the article of the job to the List of the target.
+func publishToTarget(job *PublishJob, target *PublishTarget) error {
+	if job == nil {
+		target.Status = PublishTargetFailed
+		target.Error = "publish job was deleted"
+		return finishPublishTarget(db, target)
+	}
+	// The target is saved in the transaction of the append, so a target
+	// that could not be saved is not appended to again on the next pass
+	article := Article{Title: job.Title, Author: job.Author, Content: job.Content}
+	_, err := appendArticleWith(target.ListID, article, func(tx *gorm.DB, page *Page) error {
+		done := *target
+		done.Status = PublishTargetDone
+		done.PageID = page.ID
+		return finishPublishTarget(tx, &done)
+	})
+	if err == nil {
+		return nil
+	}
+	target.Status = PublishTargetFailed
+	target.Error = err.Error()
+	return finishPublishTarget(db, target)
+}
+
+// publishPending appends to the pending targets, publishWorkers at a time,
+// until there are none left. Targets left pending when the server stopped
+// are picked up the next time it runs.
+func publishPending() error {
+	for {
+		var targets []PublishTarget
+		if err := getPendingPublishTargets(db, publishBatchSize, &targets); err != nil {
+			return fmt.Errorf("failed to fetch publish targets: %v", err)
+		}
+		if len(targets) == 0 {
+			return nil
+		}
+
+		jobs := make(map[uint]*PublishJob)
+		for _, target := range targets {
+			if _, ok := jobs[target.JobID]; ok {
+				continue
+			}
+			job := &PublishJob{}
+			if err := getPublishJobByID(db, target.JobID, job); err != nil {
+				if !errors.Is(err, gorm.ErrRecordNotFound) {
+					return fmt.Errorf("failed to fetch publish job: %v", err)
+				}
+				job = nil
+			}
+			jobs[target.JobID] = job
+		}
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		var firstErr error
+		queue := make(chan *PublishTarget)
+		for i := 0; i < publishWorkers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for target := range queue {
+					if err := publishToTarget(jobs[target.JobID], target); err != nil {
+						mu.Lock()
+						if firstErr == nil {
+							firstErr = err
+						}
+						mu.Unlock()
+					}
+				}
+			}()
+		}
+		for i := range targets {
+			queue <- &targets[i]
+		}
+		close(queue)
+		wg.Wait()
+
+		// Targets that could not be saved are still pending, so stop
+		// rather than load them again
+		if firstErr != nil {
+			return fmt.Errorf("failed to save publish target: %v", firstErr)
+		}
+	}
+}
+
+// runPublisher appends to pending targets when it is woken up, and at the
+// given interval, until done is closed.
+func runPublisher(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := publishPending(); err != nil {
+			log.Printf("Error publishing: %v\n", err)
+		}
+		select {
+		case <-done:
+			return
+		case <-publishWake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// subjectOf names the principal that made a request, "" without
+// authentication.
+func subjectOf(p *principal) string {
+	if p == nil {
+		return ""
+	}
+	return p.Subject
+}
+
+// canSeeSubject tells if the principal can see what the subject created.
+func canSeeSubject(p *principal, subject string) bool {
+	return p == nil || p.hasScope(ScopeAdmin) || p.Subject == subject
+}
+
+// collectListIDs collects the Lists named by a request and the members of
+// the group, without duplicates.
+func collectListIDs(p *principal, listIDs []uint, groupID uint) ([]uint, error) {
+	if groupID != 0 {
+		var group ListGroup
+		if err := getListGroupByID(db, groupID, &group); err != nil || !canSeeSubject(p, group.Subject) {
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("group %d not found", groupID)
+		}
+		var members []uint
+		if err := getListGroupMembers(db, groupID, &members); err != nil {
+			return nil, err
+		}
+		listIDs = append(listIDs, members...)
+	}
+
+	seen := make(map[uint]bool)
+	ids := []uint{}
+	for _, listID := range listIDs {
+		if !seen[listID] {
+			seen[listID] = true
+			ids = append(ids, listID)
+		}
+	}
+	return ids, nil
+}
+
+// claimPublishLists makes the API key of the principal the owner of the
+// Lists that do not exist yet, like appending to them one by one would.
+func claimPublishLists(p *principal, listIDs []uint) error {
+	if p == nil || p.Key == nil {
+		return nil
+	}
+	for _, listID := range listIDs {
+		if err := claimListWithinQuota(listID, p.Key.ID); err != nil {
+			return err
+		}
+	}
+	// Lists created since they were authorized may belong to someone else
+	return authorizeLists(p, listIDs, ScopeWrite, false)
+}
+
+func publishJobResponse(job *PublishJob, failures []PublishTarget) map[string]interface{} {
+	failed := []map[string]interface{}{}
+	for _, target := range failures {
+		failed = append(failed, map[string]interface{}{
+			"list_id": target.ListID,
+			"error":   target.Error,
+		})
+	}
+	return map[string]interface{}{
+		"id":         job.ID,
+		"status":     job.Status,
+		"total":      job.Total,
+		"succeeded":  job.Succeeded,
+		"failed":     job.Failed,
+		"pending":    job.Total - job.Succeeded - job.Failed,
+		"failures":   failed,
+		"created_at": job.CreatedAt,
+		"updated_at": job.UpdatedAt,
+	}
+}
+
+func publishV1(w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		Article Article `json:"article"`
+		ListIDs []uint  `json:"list_ids"`
+		GroupID uint    `json:"group_id"`
+	}
+	body, err := readBody(w, r)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return fmt.Errorf("invalid request body: %v", err)
+	}
+	if err := validateArticles([]Article{req.Article}, "article"); err != nil {
+		return err
+	}
+
+	p := principalFromContext(r.Context())
+	listIDs, err := collectListIDs(p, req.ListIDs, req.GroupID)
+	if err != nil {
+		return err
+	}
+	if len(listIDs) == 0 {
+		return fmt.Errorf("invalid request body: list_ids or group_id must name at least one list")
+	}
+	if len(listIDs) > maxPublishTargets {
+		return fmt.Errorf("invalid request body: at most %d lists can be published to at once", maxPublishTargets)
+	}
+	if err := authorizeLists(p, listIDs, ScopeWrite, true); err != nil {
+		return err
+	}
+	// Every List gets an item, which counts against the daily items
+	if ok, retryAfter := limits.chargeItems(clientOf(r), int64(len(listIDs))); !ok {
+		writeTooManyRequests(w, fmt.Sprintf("quota exceeded: %d items a day", maxItemsPerDay), retryAfter)
+		return nil
+	}
+	if err := claimPublishLists(p, listIDs); err != nil {
+		return err
+	}
+
+	job := PublishJob{
+		Subject: subjectOf(p),
+		Title:   req.Article.Title,
+		Author:  req.Article.Author,
+		Content: req.Article.Content,
+		Status:  PublishRunning,
+		Total:   len(listIDs),
+	}
+	if err := createPublishJob(db, &job, listIDs); err != nil {
+		return fmt.Errorf("failed to create publish job: %v", err)
+	}
+	wakePublisher()
+
+	w.Header().Set("Location", fmt.Sprintf("/v1/publish/%d", job.ID))
+	return writeJSON(w, http.StatusAccepted, publishJobResponse(&job, nil))
+}
+
+// loadPublishJob loads the job in the path, if the principal can see it.
+func loadPublishJob(r *http.Request, job *PublishJob) error {
+	jobID, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+	if err := getPublishJobByID(db, jobID, job); err != nil || !canSeeSubject(principalFromContext(r.Context()), job.Subject) {
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		return fmt.Errorf("publish job %d not found", jobID)
+	}
+	return nil
+}
+
+func getPublishJob(w http.ResponseWriter, r *http.Request) error {
+	var job PublishJob
+	if err := loadPublishJob(r, &job); err != nil {
+		return err
+	}
+	var failures []PublishTarget
+	if err := getPublishTargets(db, job.ID, PublishTargetFailed, maxPublishFailures, &failures); err != nil {
+		return fmt.Errorf("error fetching failed targets: %v", err)
+	}
+	return writeJSON(w, http.StatusOK, publishJobResponse(&job, failures))
+}
+
+func retryPublishJob(w http.ResponseWriter, r *http.Request) error {
+	var job PublishJob
+	if err := loadPublishJob(r, &job); err != nil {
+		return err
+	}
+	retried, err := retryPublishTargets(db, job.ID)
+	if err != nil {
+		return fmt.Errorf("failed to retry publish job: %v", err)
+	}
+	if retried == 0 {
+		return fmt.Errorf("publish job %d has no failed targets to retry", job.ID)
+	}
+	wakePublisher()
+
+	var retriedJob PublishJob
+	if err := getPublishJobByID(db, job.ID, &retriedJob); err != nil {
+		return fmt.Errorf("error fetching publish job: %v", err)
+	}
+	w.Header().Set("Location", fmt.Sprintf("/v1/publish/%d", job.ID))
+	return writeJSON(w, http.StatusAccepted, publishJobResponse(&retriedJob, nil))
+}
+
+func groupResponse(group *ListGroup, listIDs []uint) map[string]interface{} {
+	if listIDs == nil {
+		listIDs = []uint{}
+	}
+	return map[string]interface{}{
+		"id":         group.ID,
+		"list_ids":   listIDs,
+		"created_at": group.CreatedAt,
+		"updated_at": group.UpdatedAt,
+	}
+}
+
+// loadListGroup loads the group in the path, if the principal can see it.
+func loadListGroup(r *http.Request, group *ListGroup) error {
+	groupID, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+	if err := getListGroupByID(db, groupID, group); err != nil || !canSeeSubject(principalFromContext(r.Context()), group.Subject) {
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		return fmt.Errorf("group %d not found", groupID)
+	}
+	return nil
+}
+
+func getGroup(w http.ResponseWriter, r *http.Request) error {
+	var group ListGroup
+	if err := loadListGroup(r, &group); err != nil {
+		return err
+	}
+	var listIDs []uint
+	if err := getListGroupMembers(db, group.ID, &listIDs); err != nil {
+		return fmt.Errorf("error fetching group members: %v", err)
+	}
+	return writeJSON(w, http.StatusOK, groupResponse(&group, listIDs))
+}
+
+func saveGroup(w http.ResponseWriter, r *http.Request) error {
+	groupID, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+	var req struct {
+		ListIDs []uint `json:"list_ids"`
+	}
+	body, err := readBody(w, r)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return fmt.Errorf("invalid request body: %v", err)
+	}
+	seen := make(map[uint]bool)
+	listIDs := []uint{}
+	for _, listID := range req.ListIDs {
+		if !seen[listID] {
+			seen[listID] = true
+			listIDs = append(listIDs, listID)
+		}
+	}
+	if len(listIDs) > maxPublishTargets {
+		return fmt.Errorf("invalid request body: a group has at most %d lists", maxPublishTargets)
+	}
+
+	p := principalFromContext(r.Context())
+	group := ListGroup{ID: groupID, Subject: subjectOf(p)}
+	var existing ListGroup
+	if err := getListGroupByID(db, groupID, &existing); err == nil {
+		if !canSeeSubject(p, existing.Subject) {
+			return fmt.Errorf("%w: group %d belongs to someone else", errForbidden, groupID)
+		}
+		group = existing
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("error fetching group: %v", err)
+	}
+	if err := saveListGroup(db, &group, listIDs); err != nil {
+		return fmt.Errorf("failed to save group: %v", err)
+	}
+	return writeJSON(w, http.StatusOK, groupResponse(&group, listIDs))
+}
+
+func removeGroup(w http.ResponseWriter, r *http.Request) error {
+	var group ListGroup
+	if err := loadListGroup(r, &group); err != nil {
+		return err
+	}
+	if err := deleteListGroup(db, group.ID); err != nil {
+		return fmt.Errorf("failed to delete group: %v", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
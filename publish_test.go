@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// publishJob is a publish job as sent to clients.
+type publishJob struct {
+	ID        uint   `json:"id"`
+	Status    string `json:"status"`
+	Total     int    `json:"total"`
+	Succeeded int    `json:"succeeded"`
+	Failed    int    `json:"failed"`
+	Pending   int    `json:"pending"`
+	Failures  []struct {
+		ListID uint   `json:"list_id"`
+		Error  string `json:"error"`
+	} `json:"failures"`
+}
+
+func decodePublishJob(t *testing.T, rec *httptest.ResponseRecorder) publishJob {
+	t.Helper()
+	var job publishJob
+	if err := json.NewDecoder(rec.Body).Decode(&job); err != nil {
+		t.Fatalf("Error decoding publish job: %v", err)
+	}
+	return job
+}
+
+func TestPublishV1(t *testing.T) {
+	useTestDB(t)
+	router := newRouter()
+	article := `{"title": "Title", "author": "Author", "content": "Content"}`
+
+	do := func(method, url, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, url, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := do("PUT", "/v1/groups/9", `{"list_ids": [4, 5, 5]}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"list_ids":[4,5]`) {
+		t.Fatalf("Expected the group to be saved, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Lists named twice are published to once
+	rec = do("POST", "/v1/publish", fmt.Sprintf(`{"article": %s, "list_ids": [3, 4], "group_id": 9}`, article))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("Expected status code %d but got %d: %s", http.StatusAccepted, rec.Code, rec.Body.String())
+	}
+	location := rec.Header().Get("Location")
+	if job := decodePublishJob(t, rec); job.Status != PublishRunning || job.Total != 3 || job.Pending != 3 {
+		t.Errorf("Unexpected job %+v", job)
+	}
+
+	// The appends never write to the job, which every target would wait on
+	jobWrites := 0
+	countJobWrites := func(tx *gorm.DB) {
+		if tx.Statement.Table == "publish_jobs" {
+			jobWrites++
+		}
+	}
+	if err := db.Callback().Update().Before("gorm:update").Register("test:count_job_writes", countJobWrites); err != nil {
+		t.Fatalf("Failed to register update callback: %v", err)
+	}
+	if err := publishPending(); err != nil {
+		t.Fatalf("Error publishing: %v", err)
+	}
+	if jobWrites != 0 {
+		t.Errorf("Expected the job not to be written to, got %d writes", jobWrites)
+	}
+	rec = do("GET", location, "")
+	if job := decodePublishJob(t, rec); job.Status != PublishDone || job.Succeeded != 3 || job.Pending != 0 {
+		t.Errorf("Unexpected job %+v", job)
+	}
+	for _, listID := range []uint{3, 4, 5} {
+		if titles := listTitles(t, listID); !reflect.DeepEqual(titles, [][]string{{"Title"}}) {
+			t.Errorf("Expected list %d to get the article, got %v", listID, titles)
+		}
+	}
+
+	testCases := []struct {
+		name         string
+		method       string
+		url          string
+		body         string
+		expectedCode int
+	}{
+		{"No lists", "POST", "/v1/publish", fmt.Sprintf(`{"article": %s, "list_ids": []}`, article), http.StatusBadRequest},
+		{"No article", "POST", "/v1/publish", `{"list_ids": [3]}`, http.StatusBadRequest},
+		{"Invalid article", "POST", "/v1/publish", `{"article": {"title": "Title"}, "list_ids": [3]}`, http.StatusBadRequest},
+		{"Missing group", "POST", "/v1/publish", fmt.Sprintf(`{"article": %s, "group_id": 10}`, article), http.StatusNotFound},
+		{"Missing job", "GET", "/v1/publish/100", "", http.StatusNotFound},
+		{"Nothing to retry", "POST", location + "/retry", "", http.StatusConflict},
+		{"Unknown group", "GET", "/v1/groups/10", "", http.StatusNotFound},
+		{"Delete group", "DELETE", "/v1/groups/9", "", http.StatusNoContent},
+		{"Deleted group", "GET", "/v1/groups/9", "", http.StatusNotFound},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if rec := do(tc.method, tc.url, tc.body); rec.Code != tc.expectedCode {
+				t.Errorf("Expected status code %d but got %d: %s", tc.expectedCode, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestPublishPartialFailure(t *testing.T) {
+	useTestDB(t)
+	router := newRouter()
+	appendTitles(t, 6, 1, NumberOfArticleInOnePage)
+	useQuotas(t, 0, 1, 0)
+
+	do := func(method, url, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, url, strings.NewReader(body)))
+		return rec
+	}
+
+	// List 6 needs a second page, which its quota does not allow
+	rec := do("POST", "/v1/publish", `{"article": {"title": "New", "author": "Author", "content": "Content"}, "list_ids": [6, 7]}`)
+	location := rec.Header().Get("Location")
+	if err := publishPending(); err != nil {
+		t.Fatalf("Error publishing: %v", err)
+	}
+	job := decodePublishJob(t, do("GET", location, ""))
+	if job.Status != PublishPartial || job.Succeeded != 1 || job.Failed != 1 {
+		t.Errorf("Unexpected job %+v", job)
+	}
+	if len(job.Failures) != 1 || job.Failures[0].ListID != 6 || !strings.Contains(job.Failures[0].Error, "quota exceeded") {
+		t.Errorf("Expected list 6 to fail, got %+v", job.Failures)
+	}
+
+	// Retrying publishes to the failed lists only
+	maxPagesPerList = 0
+	rec = do("POST", location+"/retry", "")
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("Expected status code %d but got %d: %s", http.StatusAccepted, rec.Code, rec.Body.String())
+	}
+	if job := decodePublishJob(t, rec); job.Status != PublishRunning || job.Pending != 1 || job.Failed != 0 {
+		t.Errorf("Unexpected job %+v", job)
+	}
+	if err := publishPending(); err != nil {
+		t.Fatalf("Error publishing: %v", err)
+	}
+	job = decodePublishJob(t, do("GET", location, ""))
+	if job.Status != PublishDone || job.Succeeded != 2 || len(job.Failures) != 0 {
+		t.Errorf("Unexpected job %+v", job)
+	}
+	if titles := listTitles(t, 7); !reflect.DeepEqual(titles, [][]string{{"New"}}) {
+		t.Errorf("Expected list 7 to get the article once, got %v", titles)
+	}
+}
+
+func TestPublishRecordsTargetWithAppend(t *testing.T) {
+	useTestDB(t)
+	router := newRouter()
+	do := func(method, url, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, url, strings.NewReader(body)))
+		return rec
+	}
+
+	// Saving a finished target fails, which undoes its append
+	fail := `CREATE TRIGGER fail_done BEFORE UPDATE ON publish_targets WHEN NEW.status = 'done'
+		BEGIN SELECT RAISE(ABORT, 'disk full'); END`
+	if err := db.Exec(fail).Error; err != nil {
+		t.Fatalf("Error creating trigger: %v", err)
+	}
+	rec := do("POST", "/v1/publish", `{"article": {"title": "New", "author": "Author", "content": "Content"}, "list_ids": [7]}`)
+	location := rec.Header().Get("Location")
+	if err := publishPending(); err != nil {
+		t.Fatalf("Error publishing: %v", err)
+	}
+	if job := decodePublishJob(t, do("GET", location, "")); job.Status != PublishFailed {
+		t.Errorf("Unexpected job %+v", job)
+	}
+	var count int64
+	if err := countArticlesByListID(db, 7, &count); err != nil || count != 0 {
+		t.Errorf("Expected the append to be undone, got %d articles: %v", count, err)
+	}
+
+	if err := db.Exec("DROP TRIGGER fail_done").Error; err != nil {
+		t.Fatalf("Error dropping trigger: %v", err)
+	}
+	do("POST", location+"/retry", "")
+	if err := publishPending(); err != nil {
+		t.Fatalf("Error publishing: %v", err)
+	}
+	if titles := listTitles(t, 7); !reflect.DeepEqual(titles, [][]string{{"New"}}) {
+		t.Errorf("Expected list 7 to get the article once, got %v", titles)
+	}
+}
+
+func TestPublishAuthorization(t *testing.T) {
+	useTestDB(t)
+	useAuth(t)
+	router := newRouter()
+
+	owner, _ := mintAPIKey(t, "owner", ScopeRead, ScopeWrite)
+	publisher, publisherKey := mintAPIKey(t, "publisher", ScopeRead, ScopeWrite)
+	article := `{"title": "Title", "author": "Author", "content": "Content"}`
+
+	do := func(key, method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(owner, "POST", "/v1/lists/5/items", article); rec.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d but got %d: %s", http.StatusCreated, rec.Code, rec.Body.String())
+	}
+
+	// Nothing is published unless every list can be written
+	rec := do(publisher, "POST", "/v1/publish", fmt.Sprintf(`{"article": %s, "list_ids": [5, 6]}`, article))
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "lists 5") {
+		t.Errorf("Expected list 5 to be forbidden, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var list List
+	if err := getListByID(db, 6, &list); err == nil {
+		t.Errorf("Expected list 6 not to be claimed, got %+v", list)
+	}
+
+	// Missing lists are claimed by the publisher, like appends claim them
+	rec = do(publisher, "POST", "/v1/publish", fmt.Sprintf(`{"article": %s, "list_ids": [6, 7]}`, article))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("Expected status code %d but got %d: %s", http.StatusAccepted, rec.Code, rec.Body.String())
+	}
+	location := rec.Header().Get("Location")
+	var claimed List
+	if err := getListByID(db, 7, &claimed); err != nil || claimed.OwnerKeyID != publisherKey.ID {
+		t.Errorf("Expected list 7 to be owned by the publisher, got %+v (%v)", claimed, err)
+	}
+
+	// Jobs and groups are only open to who created them
+	if rec := do(owner, "GET", location, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected the job to be hidden from others, got %d", rec.Code)
+	}
+	if rec := do(publisher, "GET", location, ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected status code %d but got %d", http.StatusOK, rec.Code)
+	}
+	if rec := do(publisher, "PUT", "/v1/groups/1", `{"list_ids": [6, 7]}`); rec.Code != http.StatusOK {
+		t.Errorf("Expected status code %d but got %d", http.StatusOK, rec.Code)
+	}
+	if rec := do(owner, "PUT", "/v1/groups/1", `{"list_ids": [5]}`); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d but got %d", http.StatusForbidden, rec.Code)
+	}
+	rec = do(owner, "POST", "/v1/publish", fmt.Sprintf(`{"article": %s, "group_id": 1}`, article))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected the group to be hidden from others, got %d", rec.Code)
+	}
+}
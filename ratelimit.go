@@ -0,0 +1,386 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// rateLimit is the rate of a token bucket: Rate requests a second, with
+// room for Burst requests at once. A zero Rate is unlimited.
+type rateLimit struct {
+	Rate  float64
+	Burst float64
+}
+
+// newRateLimit lets the bucket hold two seconds of requests.
+func newRateLimit(rate float64) rateLimit {
+	return rateLimit{Rate: rate, Burst: math.Max(1, 2*rate)}
+}
+
+// Quotas bound what one client can store. Zero is unlimited.
+var (
+	// maxListsPerOwner is the most Lists one API key can own
+	maxListsPerOwner int64
+	// maxPagesPerList is the most pages in one List
+	maxPagesPerList int64
+	// maxItemsPerDay is the most items one client can append in a UTC day
+	maxItemsPerDay int64
+)
+
+// itemOperations are the operations that append one item, counted against
+// maxItemsPerDay.
+var itemOperations = map[string]bool{
+	"set":          true,
+	"appendItemV1": true,
+}
+
+// limits rate limits the HTTP API. Each client gets a token bucket per
+// route.
+var limits = newLimiter(newRateLimit(100), newRateLimit(10), nil)
+
+type tokenBucket struct {
+	limit   rateLimit
+	tokens  float64
+	updated time.Time
+}
+
+// limiter keeps the token buckets and the daily item counts of the clients
+// of the HTTP API. A nil limiter is disabled: every request is allowed.
+type limiter struct {
+	mu sync.Mutex
+	// read and write are the limits of the operations that take the read
+	// scope and of the others, unless routes has one for the operation
+	read   rateLimit
+	write  rateLimit
+	routes map[string]rateLimit
+	// address is the limit of all the requests from one IP address, before
+	// they are authenticated
+	address rateLimit
+	// buckets are keyed by client and operationId
+	buckets map[[2]string]*tokenBucket
+	// items counts the items appended by each client on day
+	day   string
+	items map[string]int64
+	now   func() time.Time
+}
+
+func newLimiter(read, write rateLimit, routes map[string]rateLimit) *limiter {
+	return &limiter{
+		read:    read,
+		write:   write,
+		routes:  routes,
+		buckets: make(map[[2]string]*tokenBucket),
+		items:   make(map[string]int64),
+		now:     time.Now,
+	}
+}
+
+// parseRouteRateLimits parses limits like "set=1,appendItemV1=5", in
+// requests a second per operationId.
+func parseRouteRateLimits(s string) (map[string]rateLimit, error) {
+	routes := make(map[string]rateLimit)
+	if s == "" {
+		return routes, nil
+	}
+	for _, entry := range strings.Split(s, ",") {
+		operationID, rate, ok := strings.Cut(entry, "=")
+		r, err := strconv.ParseFloat(rate, 64)
+		if !ok || err != nil || r < 0 {
+			return nil, fmt.Errorf("invalid rate limit %q, expected <operationId>=<requests a second>", entry)
+		}
+		routes[operationID] = newRateLimit(r)
+	}
+	return routes, nil
+}
+
+// limitFor is the rate limit of the operation.
+func (l *limiter) limitFor(op *openAPIOperation) rateLimit {
+	if limit, ok := l.routes[op.OperationID]; ok {
+		return limit
+	}
+	if scope := requiredScope(op); scope == "" || scope == ScopeRead {
+		return l.read
+	}
+	return l.write
+}
+
+// refill adds the tokens earned since the bucket was last used.
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.limit.Burst, b.tokens+now.Sub(b.updated).Seconds()*b.limit.Rate)
+	b.updated = now
+}
+
+// allow takes a token from the bucket of the client for the operation. It
+// returns how long to wait for the next token if there is none.
+func (l *limiter) allow(client string, op *openAPIOperation) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	return l.take([2]string{client, op.OperationID}, l.limitFor(op))
+}
+
+// allowAddress takes a token from the bucket of all the requests of the IP
+// address.
+func (l *limiter) allowAddress(host string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	return l.take([2]string{"ip:" + host, "*"}, l.address)
+}
+
+// take takes a token from the bucket with the key.
+func (l *limiter) take(key [2]string, limit rateLimit) (bool, time.Duration) {
+	if limit.Rate <= 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		l.prune(now)
+		b = &tokenBucket{limit: limit, tokens: limit.Burst, updated: now}
+		l.buckets[key] = b
+	}
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+}
+
+// prune drops the buckets that have refilled, which are the same as new
+// ones, once there are many of them.
+func (l *limiter) prune(now time.Time) {
+	if len(l.buckets) < 10000 {
+		return
+	}
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*b.limit.Rate >= b.limit.Burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// startDay resets the item counts when a new UTC day starts, and returns
+// when the next one starts.
+func (l *limiter) startDay(now time.Time) time.Time {
+	day := now.UTC().Format("2006-01-02")
+	if day != l.day {
+		l.day = day
+		l.items = make(map[string]int64)
+	}
+	y, m, d := now.UTC().Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+}
+
+// chargeItems counts n items appended by the client against maxItemsPerDay.
+// It returns how long until the quota resets if it would be exceeded.
+func (l *limiter) chargeItems(client string, n int64) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	resetsAt := l.startDay(now)
+	if maxItemsPerDay > 0 && l.items[client]+n > maxItemsPerDay {
+		return false, resetsAt.Sub(now)
+	}
+	l.items[client] += n
+	return true, 0
+}
+
+// RouteUsage is what is left of the rate limit of a client on one route.
+type RouteUsage struct {
+	OperationID string  `json:"operation_id"`
+	Rate        float64 `json:"rate"`
+	Burst       float64 `json:"burst"`
+	Remaining   float64 `json:"remaining"`
+}
+
+// Usage is what a client has used of its rate limits and quotas.
+type Usage struct {
+	Client          string       `json:"client"`
+	Routes          []RouteUsage `json:"routes"`
+	ItemsToday      int64        `json:"items_today"`
+	MaxItemsPerDay  int64        `json:"max_items_per_day"`
+	ItemsResetAt    time.Time    `json:"items_reset_at"`
+	Lists           int64        `json:"lists"`
+	MaxLists        int64        `json:"max_lists"`
+	MaxPagesPerList int64        `json:"max_pages_per_list"`
+}
+
+// usage fills in the rate limits and item count of the client.
+func (l *limiter) usage(client string, usage *Usage) {
+	usage.Client = client
+	usage.MaxItemsPerDay = maxItemsPerDay
+	usage.MaxLists = maxListsPerOwner
+	usage.MaxPagesPerList = maxPagesPerList
+	usage.Routes = []RouteUsage{}
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	usage.ItemsResetAt = l.startDay(now)
+	usage.ItemsToday = l.items[client]
+	for key, b := range l.buckets {
+		if key[0] != client {
+			continue
+		}
+		b.refill(now)
+		usage.Routes = append(usage.Routes, RouteUsage{
+			OperationID: key[1],
+			Rate:        b.limit.Rate,
+			Burst:       b.limit.Burst,
+			Remaining:   math.Floor(b.tokens),
+		})
+	}
+	sort.Slice(usage.Routes, func(i, j int) bool {
+		return usage.Routes[i].OperationID < usage.Routes[j].OperationID
+	})
+}
+
+// clientOf names who the request is counted against: its API key, the
+// subject of its JWT, or its IP address.
+func clientOf(r *http.Request) string {
+	if p := principalFromContext(r.Context()); p != nil {
+		if p.Key != nil {
+			return fmt.Sprintf("key:%d", p.Key.ID)
+		}
+		return "sub:" + p.Subject
+	}
+	return "ip:" + hostOf(r)
+}
+
+// hostOf is the IP address the request came from.
+func hostOf(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// writeTooManyRequests answers 429 with the number of seconds to wait in
+// Retry-After, if waiting helps.
+func writeTooManyRequests(w http.ResponseWriter, message string, retryAfter time.Duration) {
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	}
+	http.Error(w, message, http.StatusTooManyRequests)
+}
+
+// limitAddresses is a middleware that answers 429 to IP addresses that went
+// over their rate limit. It runs before authenticate, so that requests with
+// wrong API keys are counted too and keys cannot be guessed at any speed.
+func limitAddresses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, retryAfter := limits.allowAddress(hostOf(r)); !ok {
+			writeTooManyRequests(w, "rate limit exceeded for your address", retryAfter)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitRequests is a middleware that answers 429 to clients that went over
+// the rate limit of the route, or over their daily items. It runs after
+// authorize, so only requests that would be served are counted, and before
+// claimLists, so requests that are turned away do not create Lists.
+func limitRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		op := apiSpec.operation(r)
+		if op == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		client := clientOf(r)
+
+		if ok, retryAfter := limits.allow(client, op); !ok {
+			writeTooManyRequests(w, fmt.Sprintf("rate limit exceeded for %s", op.OperationID), retryAfter)
+			return
+		}
+		if itemOperations[op.OperationID] {
+			if ok, retryAfter := limits.chargeItems(client, 1); !ok {
+				writeTooManyRequests(w, fmt.Sprintf("quota exceeded: %d items a day", maxItemsPerDay), retryAfter)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// claimListWithinQuota makes the API key the owner of the List if it does
+// not exist yet, unless the key owns as many Lists as it may. The key is
+// locked while its Lists are counted, so concurrent claims cannot go past
+// the quota.
+func claimListWithinQuota(listID uint, keyID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if maxListsPerOwner > 0 {
+			var key APIKey
+			if err := getAPIKeyForUpdate(tx, keyID, &key); err != nil {
+				return err
+			}
+			var list List
+			err := getListByID(tx, listID, &list)
+			if err == nil {
+				return nil
+			}
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+			var count int64
+			if err := countListsByOwner(tx, keyID, &count); err != nil {
+				return err
+			}
+			if count >= maxListsPerOwner {
+				return fmt.Errorf("quota exceeded: API key %d owns %d lists", keyID, maxListsPerOwner)
+			}
+		}
+		return claimList(tx, listID, keyID)
+	})
+}
+
+func getUsage(w http.ResponseWriter, r *http.Request) error {
+	var usage Usage
+	client := clientOf(r)
+	limits.usage(client, &usage)
+	if p := principalFromContext(r.Context()); p != nil && p.Key != nil {
+		if err := countListsByOwner(db, p.Key.ID, &usage.Lists); err != nil {
+			return err
+		}
+	}
+	return writeJSON(w, http.StatusOK, usage)
+}
+
+// checkPageQuota fails if the List has as many pages as it may.
+func checkPageQuota(tx *gorm.DB, listID uint) error {
+	if maxPagesPerList <= 0 {
+		return nil
+	}
+	var count int64
+	if err := countPagesByListID(tx, listID, &count); err != nil {
+		return err
+	}
+	if count >= maxPagesPerList {
+		return fmt.Errorf("quota exceeded: list %d has %d pages", listID, maxPagesPerList)
+	}
+	return nil
+}
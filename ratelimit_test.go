@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useLimiter rate limits the HTTP API with l, on a clock the test moves, for
+// the duration of a test.
+func useLimiter(t *testing.T, l *limiter) *time.Time {
+	t.Helper()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	limits = l
+	t.Cleanup(func() { limits = nil })
+	return &now
+}
+
+// useQuotas sets the quotas for the duration of a test.
+func useQuotas(t *testing.T, lists, pages, items int64) {
+	t.Helper()
+	maxListsPerOwner, maxPagesPerList, maxItemsPerDay = lists, pages, items
+	t.Cleanup(func() { maxListsPerOwner, maxPagesPerList, maxItemsPerDay = 0, 0, 0 })
+}
+
+func TestLimiterAllow(t *testing.T) {
+	routes, err := parseRouteRateLimits("set=0.5")
+	if err != nil {
+		t.Fatalf("Error parsing route limits: %v", err)
+	}
+	l := newLimiter(newRateLimit(2), newRateLimit(1), routes)
+	now := useLimiter(t, l)
+
+	read := apiSpec.Paths["/v1/lists/{id}"]["get"]
+	write := apiSpec.Paths["/v1/lists/{id}/items"]["post"]
+	set := apiSpec.Paths["/page/set"]["post"]
+
+	expect := func(client string, op *openAPIOperation, allowed bool, retryAfter time.Duration) {
+		t.Helper()
+		ok, wait := l.allow(client, op)
+		if ok != allowed || wait != retryAfter {
+			t.Errorf("%s %s: expected (%v, %v) but got (%v, %v)", client, op.OperationID, allowed, retryAfter, ok, wait)
+		}
+	}
+
+	// Buckets start full, with two seconds of requests
+	for i := 0; i < 4; i++ {
+		expect("a", read, true, 0)
+	}
+	expect("a", read, false, 500*time.Millisecond)
+	expect("a", write, true, 0)
+	expect("a", write, true, 0)
+	expect("a", write, false, time.Second)
+	expect("a", set, true, 0)
+	expect("a", set, false, 2*time.Second)
+
+	// Other clients have their own buckets
+	expect("b", read, true, 0)
+	expect("b", write, true, 0)
+
+	// Buckets refill over time, up to their burst
+	*now = now.Add(time.Second)
+	expect("a", read, true, 0)
+	expect("a", read, true, 0)
+	expect("a", read, false, 500*time.Millisecond)
+	expect("a", write, true, 0)
+	expect("a", write, false, time.Second)
+	*now = now.Add(time.Hour)
+	for i := 0; i < 4; i++ {
+		expect("a", read, true, 0)
+	}
+	expect("a", read, false, 500*time.Millisecond)
+
+	// An unlimited route is always allowed
+	l.routes["getListV1"] = newRateLimit(0)
+	for i := 0; i < 10; i++ {
+		expect("c", read, true, 0)
+	}
+
+	var usage Usage
+	l.usage("a", &usage)
+	if len(usage.Routes) != 3 || usage.Routes[0].OperationID != "appendItemV1" || usage.Routes[0].Remaining != 2 || usage.Routes[1].Burst != 4 || usage.Routes[2].Burst != 1 {
+		t.Errorf("Unexpected usage %+v", usage.Routes)
+	}
+}
+
+func TestParseRouteRateLimits(t *testing.T) {
+	routes, err := parseRouteRateLimits("set=1,appendItemV1=5")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if routes["set"] != (rateLimit{1, 2}) || routes["appendItemV1"] != (rateLimit{5, 10}) {
+		t.Errorf("Unexpected limits %+v", routes)
+	}
+	for _, s := range []string{"set", "set=x", "set=-1", "set=1,"} {
+		if _, err := parseRouteRateLimits(s); err == nil {
+			t.Errorf("Expected %q to be invalid", s)
+		}
+	}
+}
+
+func TestChargeItems(t *testing.T) {
+	useQuotas(t, 0, 0, 2)
+	l := newLimiter(newRateLimit(0), newRateLimit(0), nil)
+	now := useLimiter(t, l)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.chargeItems("a", 1); !ok {
+			t.Fatalf("Expected item %d to be allowed", i)
+		}
+	}
+	if ok, wait := l.chargeItems("a", 1); ok || wait != 12*time.Hour {
+		t.Errorf("Expected the third item to wait until midnight, got (%v, %v)", ok, wait)
+	}
+	if ok, _ := l.chargeItems("b", 2); !ok {
+		t.Errorf("Expected another client to have its own quota")
+	}
+
+	*now = now.Add(12 * time.Hour)
+	if ok, _ := l.chargeItems("a", 1); !ok {
+		t.Errorf("Expected the quota to reset the next day")
+	}
+}
+
+func TestLimitRequests(t *testing.T) {
+	useTestDB(t)
+	useAuth(t)
+	useLimiter(t, newLimiter(newRateLimit(0), newRateLimit(1), nil))
+	useQuotas(t, 2, 1, 8)
+	router := newRouter()
+
+	key, apiKey := mintAPIKey(t, "producer", ScopeRead, ScopeWrite)
+	other, _ := mintAPIKey(t, "other", ScopeRead, ScopeWrite)
+	article := `{"title": "Title", "author": "Author", "content": "Content"}`
+
+	do := func(key, method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	expect := func(rec *httptest.ResponseRecorder, code int, retryAfter string, what string) {
+		t.Helper()
+		if rec.Code != code {
+			t.Errorf("%s: expected status code %d but got %d: %s", what, code, rec.Code, rec.Body.String())
+		}
+		if got := rec.Header().Get("Retry-After"); got != retryAfter {
+			t.Errorf("%s: expected Retry-After %q but got %q", what, retryAfter, got)
+		}
+	}
+
+	// The write bucket holds two requests and refills one a second
+	expect(do(key, "POST", "/v1/lists/5/items", article), http.StatusCreated, "", "first append")
+	expect(do(key, "POST", "/v1/lists/5/items", article), http.StatusCreated, "", "second append")
+	expect(do(key, "POST", "/v1/lists/5/items", article), http.StatusTooManyRequests, "1", "third append")
+	expect(do(key, "POST", "/v1/lists/9/items", article), http.StatusTooManyRequests, "1", "append to a new list")
+	var list List
+	if err := getListByID(db, 9, &list); err == nil {
+		t.Errorf("Expected a rate limited append not to create the list, got %+v", list)
+	}
+	expect(do(other, "POST", "/v1/lists/6/items", article), http.StatusCreated, "", "other client appends")
+	expect(do(key, "GET", "/v1/lists/5", ""), http.StatusOK, "", "unlimited read")
+
+	// Lists and pages are bounded, and waiting does not help
+	limits.write = newRateLimit(0)
+	limits.buckets = make(map[[2]string]*tokenBucket)
+	expect(do(key, "POST", "/v1/lists/7/items", article), http.StatusCreated, "", "second list")
+	expect(do(key, "POST", "/v1/lists/8/items", article), http.StatusTooManyRequests, "", "third list")
+	for i := 2; i < NumberOfArticleInOnePage; i++ {
+		expect(do(key, "POST", "/v1/lists/5/items", article), http.StatusCreated, "", "filling the page")
+	}
+	expect(do(key, "POST", "/v1/lists/5/items", article), http.StatusTooManyRequests, "", "second page")
+
+	// Items are counted per day, including the ones that hit a quota later,
+	// and clients wait for midnight UTC once they run out
+	expect(do(key, "POST", "/v1/lists/7/items", article), http.StatusTooManyRequests, "43200", "ninth item")
+
+	rec := do(key, "GET", "/v1/usage", "")
+	expect(rec, http.StatusOK, "", "usage")
+	var usage Usage
+	if err := json.NewDecoder(rec.Body).Decode(&usage); err != nil {
+		t.Fatalf("Error decoding usage: %v", err)
+	}
+	if usage.ItemsToday != 8 || usage.MaxItemsPerDay != 8 || usage.Lists != 2 || usage.MaxLists != 2 || usage.MaxPagesPerList != 1 {
+		t.Errorf("Unexpected usage %+v", usage)
+	}
+	if usage.Client != fmt.Sprintf("key:%d", apiKey.ID) {
+		t.Errorf("Expected the usage of the key, got %s", usage.Client)
+	}
+}
+
+func TestLimitAddresses(t *testing.T) {
+	useTestDB(t)
+	useAuth(t)
+	l := newLimiter(newRateLimit(0), newRateLimit(0), nil)
+	l.address = newRateLimit(1)
+	useLimiter(t, l)
+	router := newRouter()
+
+	do := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/v1/lists/5", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Authorization", "Bearer kvl_guess")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Requests with wrong keys are counted before they are authenticated
+	for i, expected := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		if rec := do("192.0.2.1:1234"); rec.Code != expected {
+			t.Errorf("Request %d: expected status code %d but got %d: %s", i+1, expected, rec.Code, rec.Body.String())
+		}
+	}
+	if rec := do("192.0.2.2:1234"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected another address to be counted apart, got %d", rec.Code)
+	}
+}
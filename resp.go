@@ -0,0 +1,629 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// respExpireInterval is how often lists whose EXPIRE time has passed are
+// deleted in the background. Commands also check the list they touch.
+const respExpireInterval = time.Second
+
+// Limits of the commands a client can send. The bulk strings of a command
+// add up to at most maxBodySize bytes, like a request body, or to
+// maxRESPPreAuth bytes before the client authenticated.
+const (
+	maxRESPArgs    = 1024
+	maxRESPLine    = 64 * 1024
+	maxRESPPreAuth = 16 * 1024
+)
+
+// serveRESP accepts connections speaking the Redis protocol (RESP) on the
+// listener and serves the list commands on top of the list store. It returns
+// when the listener is closed.
+func serveRESP(ln net.Listener) error {
+	done := make(chan struct{})
+	defer close(done)
+	go expireListsEvery(respExpireInterval, done)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go handleRESPConn(conn)
+	}
+}
+
+// respConn reads commands from and writes replies to a RESP client.
+type respConn struct {
+	r *bufio.Reader
+	w *bufio.Writer
+	// p is who the client authenticated as with AUTH, nil before that
+	p *principal
+}
+
+// authorize checks that the client has the scope and its access to the
+// List. Without authentication every client can access every List.
+func (c *respConn) authorize(listID uint, scope string, creates bool) error {
+	if !authRequired {
+		return nil
+	}
+	return authorizeList(c.p, listID, scope, creates)
+}
+
+func handleRESPConn(conn net.Conn) {
+	defer conn.Close()
+	c := &respConn{r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+
+	for {
+		args, err := c.readCommand()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				c.writeError(fmt.Sprintf("ERR %v", err))
+				c.w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		if strings.EqualFold(args[0], "QUIT") {
+			c.writeSimple("OK")
+			c.w.Flush()
+			return
+		}
+		runRESPCommand(c, args)
+		if err := c.w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// readCommand reads either a RESP array of bulk strings, as sent by Redis
+// clients, or an inline command, as typed into a plain TCP client.
+func (c *respConn) readCommand() ([]string, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 || n > maxRESPArgs {
+		return nil, fmt.Errorf("protocol error: invalid multibulk length")
+	}
+	// Clients that have not authenticated can only send AUTH and PING, which
+	// are small
+	left := maxBodySize
+	if authRequired && c.p == nil {
+		left = maxRESPPreAuth
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("protocol error: expected '$', got %q", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("protocol error: invalid bulk length")
+		}
+		if int64(size) > left {
+			return nil, fmt.Errorf("protocol error: command too large")
+		}
+		left -= int64(size)
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+// readLine reads a line of at most maxRESPLine bytes.
+func (c *respConn) readLine() (string, error) {
+	var line []byte
+	for {
+		chunk, err := c.r.ReadSlice('\n')
+		if len(line)+len(chunk) > maxRESPLine {
+			return "", fmt.Errorf("protocol error: line too long")
+		}
+		line = append(line, chunk...)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return "", err
+		}
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+func (c *respConn) writeSimple(s string) {
+	fmt.Fprintf(c.w, "+%s\r\n", s)
+}
+
+func (c *respConn) writeError(s string) {
+	fmt.Fprintf(c.w, "-%s\r\n", s)
+}
+
+func (c *respConn) writeInt(n int64) {
+	fmt.Fprintf(c.w, ":%d\r\n", n)
+}
+
+func (c *respConn) writeBulk(s string) {
+	fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(s), s)
+}
+
+func (c *respConn) writeNull() {
+	c.w.WriteString("$-1\r\n")
+}
+
+func (c *respConn) writeArray(items []string) {
+	fmt.Fprintf(c.w, "*%d\r\n", len(items))
+	for _, item := range items {
+		c.writeBulk(item)
+	}
+}
+
+// respArity is the minimum number of arguments, including the command name,
+// of each supported command.
+var respArity = map[string]int{
+	"PING":     1,
+	"AUTH":     2,
+	"RPUSH":    3,
+	"LRANGE":   4,
+	"LLEN":     2,
+	"DEL":      2,
+	"EXPIRE":   3,
+	"PAGE.GET": 2,
+	"HEAD.GET": 2,
+}
+
+func runRESPCommand(c *respConn, args []string) {
+	name := strings.ToUpper(args[0])
+	arity, ok := respArity[name]
+	if !ok {
+		c.writeError(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		return
+	}
+	if len(args) < arity {
+		c.writeError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(args[0])))
+		return
+	}
+
+	if authRequired && c.p == nil && name != "PING" && name != "AUTH" {
+		c.writeError("NOAUTH Authentication required.")
+		return
+	}
+
+	var err error
+	switch name {
+	case "AUTH":
+		err = respAuth(c, args[len(args)-1])
+	case "PING":
+		if len(args) > 1 {
+			c.writeBulk(args[1])
+		} else {
+			c.writeSimple("PONG")
+		}
+	case "RPUSH":
+		err = respRPush(c, args[1], args[2:])
+	case "LRANGE":
+		err = respLRange(c, args[1], args[2], args[3])
+	case "LLEN":
+		err = respLLen(c, args[1])
+	case "DEL":
+		err = respDel(c, args[1:])
+	case "EXPIRE":
+		err = respExpire(c, args[1], args[2])
+	case "PAGE.GET":
+		err = respPageGet(c, args[1])
+	case "HEAD.GET":
+		err = respHeadGet(c, args[1])
+	}
+	if errors.Is(err, errForbidden) {
+		c.writeError(fmt.Sprintf("NOPERM %v", err))
+	} else if err != nil {
+		log.Printf("Error in RESP %s: %v\n", name, err)
+		c.writeError(fmt.Sprintf("ERR %v", err))
+	}
+}
+
+// respAuth authenticates the connection with an API key or JWT. Like Redis,
+// AUTH takes an optional user name before it, which is ignored.
+func respAuth(c *respConn, token string) error {
+	p, err := authenticateToken(token)
+	if errors.Is(err, errUnauthorized) {
+		c.writeError("WRONGPASS " + err.Error())
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	c.p = p
+	c.writeSimple("OK")
+	return nil
+}
+
+// parseRESPID parses a list key or page ID, which must be a positive integer.
+func parseRESPID(s string) (uint, error) {
+	id, err := strconv.ParseUint(s, 10, 0)
+	if err != nil || id == 0 {
+		return 0, fmt.Errorf("key %q is not a valid list or page ID", s)
+	}
+	return uint(id), nil
+}
+
+// parseRESPArticle decodes a pushed value. A JSON object is decoded as an
+// article, anything else becomes the content of an article.
+func parseRESPArticle(value string) (Article, bool) {
+	var article Article
+	if strings.HasPrefix(strings.TrimSpace(value), "{") && json.Unmarshal([]byte(value), &article) == nil {
+		return article, true
+	}
+	return Article{Content: value}, false
+}
+
+// parseRESPArticles decodes pushed values and checks them like the HTTP API
+// checks a request body. Plain values only set the content, so only the
+// content of those is checked.
+func parseRESPArticles(values []string) ([]Article, error) {
+	articles := make([]Article, len(values))
+	var errs []FieldError
+	for i, value := range values {
+		article, isJSON := parseRESPArticle(value)
+		at := fmt.Sprintf("[%d]", i)
+		if isJSON {
+			validateFields(&article, at, &errs)
+		} else if !utf8.ValidString(value) {
+			errs = append(errs, FieldError{fieldPath(at, "content"), "must be valid UTF-8"})
+		} else if strings.TrimSpace(value) == "" {
+			errs = append(errs, FieldError{fieldPath(at, "content"), "is required"})
+		}
+		articles[i] = article
+	}
+	if len(errs) > 0 {
+		return nil, &ValidationError{Fields: errs}
+	}
+	return articles, nil
+}
+
+func encodeRESPArticle(article Article) string {
+	b, _ := json.Marshal(articleFields(article))
+	return string(b)
+}
+
+// errNotExpired vetoes expiring a list that was given a new expiry time, or
+// was deleted, after it was found to be expired.
+var errNotExpired = errors.New("list is no longer expired")
+
+// expireListIfDue deletes the list if its EXPIRE time has passed and reports
+// whether it did.
+func expireListIfDue(listID uint) (bool, error) {
+	var list List
+	if err := getListByID(db, listID, &list); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	if list.ExpiresAt == nil || list.ExpiresAt.After(time.Now()) {
+		return false, nil
+	}
+
+	_, err := deleteList(listID, func(list *List) error {
+		if list == nil || list.ExpiresAt == nil || list.ExpiresAt.After(time.Now()) {
+			return errNotExpired
+		}
+		return nil
+	})
+	if errors.Is(err, errNotExpired) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// expireListsEvery deletes expired lists at the given interval until done is
+// closed.
+func expireListsEvery(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			var lists []List
+			if err := getExpiredLists(db, time.Now(), &lists); err != nil {
+				log.Printf("Error fetching expired lists: %v\n", err)
+				continue
+			}
+			for _, list := range lists {
+				if _, err := expireListIfDue(list.ID); err != nil {
+					log.Printf("Error expiring list %d: %v\n", list.ID, err)
+				}
+			}
+		}
+	}
+}
+
+func respLength(listID uint) (int64, error) {
+	var count int64
+	err := countArticlesByListID(db, listID, &count)
+	return count, err
+}
+
+func respRPush(c *respConn, key string, values []string) error {
+	listID, err := parseRESPID(key)
+	if err != nil {
+		return err
+	}
+	if err := c.authorize(listID, ScopeWrite, true); err != nil {
+		return err
+	}
+	if _, err := expireListIfDue(listID); err != nil {
+		return err
+	}
+
+	articles, err := parseRESPArticles(values)
+	if err != nil {
+		return err
+	}
+	for _, article := range articles {
+		if _, err := appendArticle(listID, article); err != nil {
+			return err
+		}
+	}
+
+	length, err := respLength(listID)
+	if err != nil {
+		return err
+	}
+	c.writeInt(length)
+	return nil
+}
+
+func respLRange(c *respConn, key string, startStr string, stopStr string) error {
+	listID, err := parseRESPID(key)
+	if err != nil {
+		return err
+	}
+	start, err1 := strconv.ParseInt(startStr, 10, 64)
+	stop, err2 := strconv.ParseInt(stopStr, 10, 64)
+	if err1 != nil || err2 != nil {
+		return fmt.Errorf("value is not an integer or out of range")
+	}
+	if err := c.authorize(listID, ScopeRead, false); err != nil {
+		return err
+	}
+	if _, err := expireListIfDue(listID); err != nil {
+		return err
+	}
+
+	length, err := respLength(listID)
+	if err != nil {
+		return err
+	}
+	// Negative indexes count from the end of the list, as in Redis
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if start > stop {
+		c.writeArray(nil)
+		return nil
+	}
+
+	items := []string{}
+	var index int64
+	err = walkList(listID, func(page *Page) bool {
+		// Skip whole pages before the range
+		if index+int64(len(page.Articles)) <= start {
+			index += int64(len(page.Articles))
+			return true
+		}
+		for _, article := range page.Articles {
+			if index >= start && index <= stop {
+				items = append(items, encodeRESPArticle(article))
+			}
+			index++
+		}
+		return index <= stop
+	})
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	c.writeArray(items)
+	return nil
+}
+
+func respLLen(c *respConn, key string) error {
+	listID, err := parseRESPID(key)
+	if err != nil {
+		return err
+	}
+	if err := c.authorize(listID, ScopeRead, false); err != nil {
+		return err
+	}
+	if _, err := expireListIfDue(listID); err != nil {
+		return err
+	}
+	length, err := respLength(listID)
+	if err != nil {
+		return err
+	}
+	c.writeInt(length)
+	return nil
+}
+
+func respDel(c *respConn, keys []string) error {
+	var removed int64
+	for _, key := range keys {
+		listID, err := parseRESPID(key)
+		if err != nil {
+			return err
+		}
+		if err := c.authorize(listID, ScopeWrite, false); err != nil {
+			return err
+		}
+		if _, err := expireListIfDue(listID); err != nil {
+			return err
+		}
+		deleted, err := deleteList(listID, nil)
+		if err != nil {
+			return err
+		}
+		if deleted > 0 {
+			removed++
+		}
+	}
+	c.writeInt(removed)
+	return nil
+}
+
+func respExpire(c *respConn, key string, secondsStr string) error {
+	listID, err := parseRESPID(key)
+	if err != nil {
+		return err
+	}
+	seconds, err := strconv.ParseInt(secondsStr, 10, 64)
+	if err != nil {
+		return fmt.Errorf("value is not an integer or out of range")
+	}
+	if err := c.authorize(listID, ScopeWrite, false); err != nil {
+		return err
+	}
+	if _, err := expireListIfDue(listID); err != nil {
+		return err
+	}
+
+	// Like Redis, only a list that holds items can expire
+	length, err := respLength(listID)
+	if err != nil {
+		return err
+	}
+	if length == 0 {
+		c.writeInt(0)
+		return nil
+	}
+
+	var list List
+	if err := getListByID(db, listID, &list); err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(time.Duration(seconds) * time.Second)
+	if err := updateListExpiresAt(db, &list, &expiresAt); err != nil {
+		return err
+	}
+	// The list changed, even if none of its pages did
+	cachedPages.invalidate()
+	if seconds <= 0 {
+		if _, err := expireListIfDue(listID); err != nil {
+			return err
+		}
+	}
+	c.writeInt(1)
+	return nil
+}
+
+// respPageGet replies with the same JSON document as GET /page/get, or a
+// null reply if there is no such page.
+func respPageGet(c *respConn, idStr string) error {
+	pageID, err := parseRESPID(idStr)
+	if err != nil {
+		return err
+	}
+
+	var page Page
+	if !cachedPages.get(pageID, &page) {
+		if err := loadPage(pageID, &page); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.writeNull()
+				return nil
+			}
+			return err
+		}
+	}
+	if err := c.authorize(page.ListID, ScopeRead, false); err != nil {
+		return err
+	}
+	expired, err := expireListIfDue(page.ListID)
+	if err != nil {
+		return err
+	}
+	if expired {
+		c.writeNull()
+		return nil
+	}
+
+	var articleData []map[string]interface{}
+	for _, article := range page.Articles {
+		articleData = append(articleData, articleFields(article))
+	}
+	b, err := json.Marshal(map[string]interface{}{
+		"articles":     articleData,
+		"next_page_id": page.NextPageID,
+	})
+	if err != nil {
+		return err
+	}
+	c.writeBulk(string(b))
+	return nil
+}
+
+// respHeadGet replies with the ID of the list's first page, like
+// GET /list/get, or a null reply if there is no such list.
+func respHeadGet(c *respConn, key string) error {
+	listID, err := parseRESPID(key)
+	if err != nil {
+		return err
+	}
+	if err := c.authorize(listID, ScopeRead, false); err != nil {
+		return err
+	}
+	if _, err := expireListIfDue(listID); err != nil {
+		return err
+	}
+
+	var list List
+	if err := loadList(listID, &list); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.writeNull()
+			return nil
+		}
+		return err
+	}
+	c.writeInt(int64(list.NextPageID))
+	return nil
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// respTestClient is a plain TCP client that sends commands as RESP arrays
+// and decodes the replies.
+type respTestClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func startRESPTestServer(t *testing.T) *respTestClient {
+	t.Helper()
+	useTestDB(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go serveRESP(ln)
+	t.Cleanup(func() { ln.Close() })
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	return &respTestClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *respTestClient) do(args ...string) interface{} {
+	c.t.Helper()
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c.conn, b.String()); err != nil {
+		c.t.Fatalf("Failed to send %v: %v", args, err)
+	}
+	return c.readReply()
+}
+
+// readReply decodes a reply into a string, an int64, nil or a []interface{}.
+// Error replies are returned as an error.
+func (c *respTestClient) readReply() interface{} {
+	c.t.Helper()
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("Failed to read reply: %v", err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+
+	switch line[0] {
+	case '+':
+		return line[1:]
+	case '-':
+		return fmt.Errorf("%s", line[1:])
+	case ':':
+		n, _ := strconv.ParseInt(line[1:], 10, 64)
+		return n
+	case '$':
+		size, _ := strconv.Atoi(line[1:])
+		if size < 0 {
+			return nil
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			c.t.Fatalf("Failed to read bulk string: %v", err)
+		}
+		return string(buf[:size])
+	case '*':
+		n, _ := strconv.Atoi(line[1:])
+		items := make([]interface{}, n)
+		for i := range items {
+			items[i] = c.readReply()
+		}
+		return items
+	}
+	c.t.Fatalf("Unexpected reply %q", line)
+	return nil
+}
+
+func TestRESPListCommands(t *testing.T) {
+	c := startRESPTestServer(t)
+
+	if reply := c.do("PING"); reply != "PONG" {
+		t.Errorf("PING: got %v", reply)
+	}
+
+	// Push enough items to fill more than one page
+	for i := 1; i <= 7; i++ {
+		value := fmt.Sprintf(`{"title":"Title %d","author":"Author","content":"Content %d"}`, i, i)
+		if reply := c.do("RPUSH", "42", value); reply != int64(i) {
+			t.Fatalf("RPUSH %d: got %v", i, reply)
+		}
+	}
+	if reply := c.do("RPUSH", "42", "plain text"); reply != int64(8) {
+		t.Errorf("RPUSH plain text: got %v", reply)
+	}
+	if reply := c.do("LLEN", "42"); reply != int64(8) {
+		t.Errorf("LLEN: got %v", reply)
+	}
+
+	reply := c.do("LRANGE", "42", "0", "-1")
+	items, ok := reply.([]interface{})
+	if !ok || len(items) != 8 {
+		t.Fatalf("LRANGE 0 -1: got %v", reply)
+	}
+	if items[0] != `{"author":"Author","content":"Content 1","score":0,"title":"Title 1"}` {
+		t.Errorf("LRANGE first item: got %v", items[0])
+	}
+	if items[7] != `{"author":"","content":"plain text","score":0,"title":""}` {
+		t.Errorf("LRANGE last item: got %v", items[7])
+	}
+
+	// A range that spans the page boundary
+	reply = c.do("LRANGE", "42", "4", "5")
+	want := []interface{}{
+		`{"author":"Author","content":"Content 5","score":0,"title":"Title 5"}`,
+		`{"author":"Author","content":"Content 6","score":0,"title":"Title 6"}`,
+	}
+	if !reflect.DeepEqual(reply, want) {
+		t.Errorf("LRANGE 4 5: got %v, want %v", reply, want)
+	}
+	if reply := c.do("LRANGE", "42", "10", "20"); !reflect.DeepEqual(reply, []interface{}{}) {
+		t.Errorf("LRANGE out of range: got %v", reply)
+	}
+
+	head, ok := c.do("HEAD.GET", "42").(int64)
+	if !ok || head == 0 {
+		t.Fatalf("HEAD.GET: got %v", head)
+	}
+	page, ok := c.do("PAGE.GET", strconv.FormatInt(head, 10)).(string)
+	if !ok || !strings.Contains(page, `"title":"Title 1"`) || strings.Contains(page, `"next_page_id":0`) {
+		t.Errorf("PAGE.GET: got %v", page)
+	}
+	if reply := c.do("PAGE.GET", "999"); reply != nil {
+		t.Errorf("PAGE.GET missing page: got %v", reply)
+	}
+
+	if reply := c.do("DEL", "42", "43"); reply != int64(1) {
+		t.Errorf("DEL: got %v", reply)
+	}
+	if reply := c.do("LLEN", "42"); reply != int64(0) {
+		t.Errorf("LLEN after DEL: got %v", reply)
+	}
+	if reply := c.do("HEAD.GET", "42"); reply != int64(0) {
+		t.Errorf("HEAD.GET after DEL: got %v", reply)
+	}
+}
+
+func TestRESPExpire(t *testing.T) {
+	c := startRESPTestServer(t)
+
+	if reply := c.do("EXPIRE", "7", "10"); reply != int64(0) {
+		t.Errorf("EXPIRE on an empty list: got %v", reply)
+	}
+
+	c.do("RPUSH", "7", "a", "b")
+	if reply := c.do("EXPIRE", "7", "100"); reply != int64(1) {
+		t.Errorf("EXPIRE: got %v", reply)
+	}
+	if reply := c.do("LLEN", "7"); reply != int64(2) {
+		t.Errorf("LLEN before expiry: got %v", reply)
+	}
+
+	// Move the expiry into the past
+	if err := db.Model(&List{}).Where("id = ?", 7).Update("expires_at", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatalf("Failed to update expiry: %v", err)
+	}
+	if reply := c.do("LLEN", "7"); reply != int64(0) {
+		t.Errorf("LLEN after expiry: got %v", reply)
+	}
+
+	// A new push starts a list without an expiry
+	c.do("RPUSH", "7", "c")
+	var list List
+	if err := getListByID(db, 7, &list); err != nil {
+		t.Fatalf("Failed to get list: %v", err)
+	}
+	if list.ExpiresAt != nil {
+		t.Errorf("Expected the expiry to be cleared, got %v", list.ExpiresAt)
+	}
+}
+
+func TestRESPErrors(t *testing.T) {
+	c := startRESPTestServer(t)
+
+	if _, ok := c.do("FLUSHALL").(error); !ok {
+		t.Errorf("Expected an error for an unknown command")
+	}
+	if _, ok := c.do("RPUSH", "1").(error); !ok {
+		t.Errorf("Expected an error for a missing argument")
+	}
+	if _, ok := c.do("LLEN", "not-a-list").(error); !ok {
+		t.Errorf("Expected an error for a non-numeric key")
+	}
+
+	// Inline commands work too
+	io.WriteString(c.conn, "PING\r\n")
+	if reply := c.readReply(); reply != "PONG" {
+		t.Errorf("Inline PING: got %v", reply)
+	}
+}
+
+func TestRESPPushValidation(t *testing.T) {
+	c := startRESPTestServer(t)
+
+	testCases := []struct {
+		name   string
+		values []string
+	}{
+		{"Empty object", []string{"{}"}},
+		{"Long title", []string{`{"title": "` + strings.Repeat("a", 256) + `", "author": "Author", "content": "Content"}`}},
+		{"Blank value", []string{"a", " "}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			args := append([]string{"RPUSH", "42"}, tc.values...)
+			err, ok := c.do(args...).(error)
+			if !ok || !strings.Contains(err.Error(), "validation failed") {
+				t.Errorf("Expected a validation error, got %v", err)
+			}
+		})
+	}
+
+	// Nothing is pushed when any value is invalid
+	if reply := c.do("LLEN", "42"); reply != int64(0) {
+		t.Errorf("Expected an empty list, got %v", reply)
+	}
+}
+
+func TestRESPOversizedLengths(t *testing.T) {
+	testCases := []struct {
+		name    string
+		command string
+	}{
+		{"Multibulk length", "*9223372036854775807\r\n"},
+		{"Too many arguments", fmt.Sprintf("*%d\r\n", maxRESPArgs+1)},
+		{"Bulk length", "*1\r\n$9223372036854775807\r\n"},
+		{"Bulk larger than a body", fmt.Sprintf("*1\r\n$%d\r\n", maxBodySize+1)},
+		{"Bulks larger than a body", fmt.Sprintf("*2\r\n$%d\r\n%s\r\n$%d\r\n", maxBodySize/2+1, strings.Repeat("a", int(maxBodySize/2+1)), maxBodySize/2)},
+		{"Line without an end", strings.Repeat("a", 2*maxRESPLine)},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := startRESPTestServer(t)
+			io.WriteString(c.conn, tc.command)
+			err, ok := c.readReply().(error)
+			if !ok || !strings.HasPrefix(err.Error(), "ERR protocol error") {
+				t.Errorf("Expected a protocol error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRESPLimitBeforeAuthentication(t *testing.T) {
+	c := startRESPTestServer(t)
+	useAuth(t)
+
+	// Only small commands are read before AUTH
+	fmt.Fprintf(c.conn, "*3\r\n$5\r\nRPUSH\r\n$1\r\n1\r\n$%d\r\n", maxRESPPreAuth+1)
+	err, ok := c.readReply().(error)
+	if !ok || !strings.HasPrefix(err.Error(), "ERR protocol error") {
+		t.Errorf("Expected a protocol error, got %v", err)
+	}
+}
+
+func TestRESPAuthentication(t *testing.T) {
+	c := startRESPTestServer(t)
+	useAuth(t)
+	owner, _ := mintAPIKey(t, "owner", ScopeRead, ScopeWrite)
+	other, _ := mintAPIKey(t, "other", ScopeRead)
+
+	if reply := c.do("PING"); reply != "PONG" {
+		t.Errorf("Expected PING to work before AUTH, got %v", reply)
+	}
+	if err, ok := c.do("DEL", "5").(error); !ok || !strings.HasPrefix(err.Error(), "NOAUTH") {
+		t.Errorf("Expected NOAUTH before AUTH, got %v", err)
+	}
+	if err, ok := c.do("AUTH", "kvl_wrong").(error); !ok || !strings.HasPrefix(err.Error(), "WRONGPASS") {
+		t.Errorf("Expected WRONGPASS for a wrong key, got %v", err)
+	}
+
+	if reply := c.do("AUTH", owner); reply != "OK" {
+		t.Fatalf("AUTH failed: %v", reply)
+	}
+	if reply := c.do("RPUSH", "5", "first"); reply != int64(1) {
+		t.Errorf("RPUSH: got %v", reply)
+	}
+
+	// Another key needs a grant, and the write scope to write
+	if reply := c.do("AUTH", "default", other); reply != "OK" {
+		t.Fatalf("AUTH failed: %v", reply)
+	}
+	for _, args := range [][]string{{"LLEN", "5"}, {"DEL", "5"}, {"HEAD.GET", "5"}} {
+		if err, ok := c.do(args...).(error); !ok || !strings.HasPrefix(err.Error(), "NOPERM") {
+			t.Errorf("Expected NOPERM for %v, got %v", args, err)
+		}
+	}
+	if err, ok := c.do("RPUSH", "6", "first").(error); !ok || !strings.HasPrefix(err.Error(), "NOPERM") {
+		t.Errorf("Expected NOPERM for RPUSH without the write scope, got %v", err)
+	}
+}
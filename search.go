@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Ways of searching articles, depending on the database.
+const (
+	// SearchPostgres matches a tsvector of the articles, backed by a GIN index.
+	SearchPostgres = "postgres"
+	// SearchFTS5 matches the articles_fts table, an FTS5 index of the
+	// articles kept up to date by triggers.
+	SearchFTS5 = "fts5"
+	// SearchLike scans the articles. It is used on SQLite builds without
+	// FTS5, which needs the sqlite_fts5 build tag.
+	SearchLike = "like"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// searchMode is how searchArticles finds articles, set by setupSearch.
+var searchMode = SearchLike
+
+// articleDocument is the text of an article that is searched, on Postgres.
+const articleDocument = "to_tsvector('english', coalesce(articles.title, '') || ' ' || coalesce(articles.author, '') || ' ' || coalesce(articles.content, ''))"
+
+// articlePosition is the position of an article on its page, counting from 0,
+// in the order the page is read in: newest first in prepend mode, highest
+// score first in sorted mode, and oldest first otherwise.
+const articlePosition = "(SELECT COUNT(*) FROM articles AS earlier WHERE earlier.page_id = articles.page_id AND " +
+	"CASE WHEN lists.mode = '" + ListModePrepend + "' THEN earlier.id > articles.id " +
+	"WHEN lists.mode = '" + ListModeSorted + "' THEN earlier.score > articles.score OR (earlier.score = articles.score AND earlier.id < articles.id) " +
+	"ELSE earlier.id < articles.id END)"
+
+// SearchResult is an article that matches a search, with where to find it.
+type SearchResult struct {
+	ArticleID uint   `json:"article_id"`
+	ListID    uint   `json:"list_id"`
+	PageID    uint   `json:"page_id"`
+	Position  int    `json:"position"`
+	Title     string `json:"title"`
+	Author    string `json:"author"`
+	Content   string `json:"content"`
+}
+
+// setupSearch creates the full-text index of the articles and sets
+// searchMode accordingly. It must run after the articles table is migrated.
+func setupSearch(db *gorm.DB) error {
+	if db.Dialector.Name() == "postgres" {
+		searchMode = SearchPostgres
+		return db.Exec("CREATE INDEX IF NOT EXISTS idx_articles_search ON articles USING GIN (" + articleDocument + ")").Error
+	}
+
+	var exists int64
+	if err := db.Raw("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'articles_fts'").Scan(&exists).Error; err != nil {
+		return err
+	}
+	if exists == 0 {
+		err := db.Exec("CREATE VIRTUAL TABLE articles_fts USING fts5(title, author, content, content='articles', content_rowid='id', tokenize='porter unicode61')").Error
+		if err != nil {
+			if strings.Contains(err.Error(), "no such module") {
+				log.Printf("SQLite was built without FTS5, searching articles without an index\n")
+				searchMode = SearchLike
+				return nil
+			}
+			return err
+		}
+	}
+
+	// Keep the index in sync with the articles
+	statements := []string{
+		`CREATE TRIGGER IF NOT EXISTS articles_fts_insert AFTER INSERT ON articles BEGIN
+			INSERT INTO articles_fts(rowid, title, author, content) VALUES (new.id, new.title, new.author, new.content);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS articles_fts_delete AFTER DELETE ON articles BEGIN
+			INSERT INTO articles_fts(articles_fts, rowid, title, author, content) VALUES ('delete', old.id, old.title, old.author, old.content);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS articles_fts_update AFTER UPDATE ON articles BEGIN
+			INSERT INTO articles_fts(articles_fts, rowid, title, author, content) VALUES ('delete', old.id, old.title, old.author, old.content);
+			INSERT INTO articles_fts(rowid, title, author, content) VALUES (new.id, new.title, new.author, new.content);
+		END`,
+	}
+	if exists == 0 {
+		// Index the articles written before the index existed
+		statements = append(statements, "INSERT INTO articles_fts(articles_fts) VALUES ('rebuild')")
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	searchMode = SearchFTS5
+	return nil
+}
+
+// searchTerms splits a query into words.
+func searchTerms(query string) []string {
+	return strings.Fields(query)
+}
+
+// fts5Query turns the words of a query into an FTS5 query that matches
+// articles containing all of them, so that characters with a meaning in the
+// FTS5 syntax are searched for literally.
+func fts5Query(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+	}
+	return strings.Join(quoted, " ")
+}
+
+// SearchArticles gets up to limit articles, after skipping offset, that
+// contain every word of the query in their title, author or content, best
+// matches first. A listID of 0 searches every list.
+func searchArticles(db *gorm.DB, query string, listID uint, limit int, offset int, results *[]SearchResult) error {
+	*results = nil
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	columns := "articles.id AS article_id, pages.list_id, articles.page_id, " + articlePosition + " AS position, articles.title, articles.author, articles.content"
+	q := db.Table("articles").
+		Select(columns).
+		Joins("JOIN pages ON pages.id = articles.page_id").
+		Joins("LEFT JOIN lists ON lists.id = pages.list_id")
+
+	switch searchMode {
+	case SearchPostgres:
+		q = q.Select(columns+", ts_rank("+articleDocument+", plainto_tsquery('english', ?)) AS rank", query).
+			Where(articleDocument+" @@ plainto_tsquery('english', ?)", query).
+			Order("rank DESC")
+	case SearchFTS5:
+		q = q.Joins("JOIN articles_fts ON articles_fts.rowid = articles.id").
+			Where("articles_fts MATCH ?", fts5Query(terms)).
+			Order("articles_fts.rank")
+	default:
+		for _, term := range terms {
+			pattern := "%" + escapeLike(strings.ToLower(term)) + "%"
+			q = q.Where("(LOWER(articles.title) LIKE ? ESCAPE '\\' OR LOWER(articles.author) LIKE ? ESCAPE '\\' OR LOWER(articles.content) LIKE ? ESCAPE '\\')", pattern, pattern, pattern)
+		}
+	}
+	if listID != 0 {
+		q = q.Where("pages.list_id = ?", listID)
+	}
+	return q.Order("articles.id").Limit(limit).Offset(offset).Scan(results).Error
+}
+
+func search(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+
+	q := query.Get("q")
+	if strings.TrimSpace(q) == "" {
+		return fmt.Errorf("q parameter is missing")
+	}
+
+	var listID int
+	if idStr := query.Get("list_id"); idStr != "" {
+		var err error
+		listID, err = strconv.Atoi(idStr)
+		if err != nil || listID < 0 {
+			return fmt.Errorf("list_id parameter is not a valid integer")
+		}
+	}
+
+	limit := defaultSearchLimit
+	if limitStr := query.Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			return fmt.Errorf("limit parameter is not a valid integer")
+		}
+		if limit > maxSearchLimit {
+			limit = maxSearchLimit
+		}
+	}
+
+	var offset int
+	if offsetStr := query.Get("offset"); offsetStr != "" {
+		var err error
+		offset, err = strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			return fmt.Errorf("offset parameter is not a valid integer")
+		}
+	}
+
+	var results []SearchResult
+	if err := searchArticles(db, q, uint(listID), limit, offset, &results); err != nil {
+		return fmt.Errorf("error searching articles: %v", err)
+	}
+	if results == nil {
+		results = []SearchResult{}
+	}
+
+	res := map[string]interface{}{
+		"results": results,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		return fmt.Errorf("error encoding JSON response: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+//go:build sqlite_fts5
+
+package main
+
+import "testing"
+
+func TestSearchFTS5(t *testing.T) {
+	useTestDB(t)
+	if searchMode != SearchFTS5 {
+		t.Fatalf("Expected searches to use FTS5, got %s", searchMode)
+	}
+
+	for _, title := range []string{"Running shoes", "Runs scored"} {
+		if _, err := appendArticle(4, Article{Title: title, Author: "Author", Content: "Content"}); err != nil {
+			t.Fatalf("Failed to append article: %v", err)
+		}
+	}
+
+	// The index stems words, which a scan of the articles would not
+	var results []SearchResult
+	if err := searchArticles(db, "runs", 4, maxSearchLimit, 0, &results); err != nil {
+		t.Fatalf("Failed to search articles: %v", err)
+	}
+	if len(results) != 2 {
+		t.Errorf("Expected both articles to match, got %+v", results)
+	}
+}
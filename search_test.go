@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestSearchArticles(t *testing.T) {
+	useTestDB(t)
+
+	articles := []Article{
+		{Title: "Go generics", Author: "Alice", Content: "Type parameters in practice"},
+		{Title: "Postgres tuning", Author: "Bob", Content: "Indexes and vacuum"},
+		{Title: "Rust ownership", Author: "Alice", Content: "Borrowing explained"},
+		{Title: "Go concurrency", Author: "Carol", Content: "Channels and goroutines"},
+		{Title: "Gardening", Author: "Dave", Content: "Tomatoes"},
+		{Title: "Go modules", Author: "Bob", Content: "Versioning dependencies"},
+	}
+	for _, article := range articles {
+		if _, err := appendArticle(4, article); err != nil {
+			t.Fatalf("Failed to append article: %v", err)
+		}
+	}
+	appendArticle(9, Article{Title: "Go on another list", Author: "Eve"})
+
+	testCases := []struct {
+		name     string
+		query    string
+		listID   uint
+		expected []string
+	}{
+		{"title", "modules", 4, []string{"Go modules"}},
+		{"author", "alice", 4, []string{"Go generics", "Rust ownership"}},
+		{"content", "tomatoes", 4, []string{"Gardening"}},
+		{"every word", "go bob", 4, []string{"Go modules"}},
+		{"every list", "alice go", 0, []string{"Go generics"}},
+		{"other list", "another", 9, []string{"Go on another list"}},
+		{"no match", "python", 0, nil},
+		{"query syntax", `"go" OR -`, 4, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var results []SearchResult
+			if err := searchArticles(db, tc.query, tc.listID, maxSearchLimit, 0, &results); err != nil {
+				t.Fatalf("Failed to search articles: %v", err)
+			}
+			if len(results) != len(tc.expected) {
+				t.Fatalf("Expected %d results but got %+v", len(tc.expected), results)
+			}
+			// The order of equally good matches depends on the database
+			var titles []string
+			for _, result := range results {
+				titles = append(titles, result.Title)
+			}
+			sort.Strings(titles)
+			for i, title := range titles {
+				if title != tc.expected[i] {
+					t.Errorf("Expected result %d to be %q but got %q", i, tc.expected[i], title)
+				}
+			}
+		})
+	}
+
+	// Results point at the page and position of the article
+	var results []SearchResult
+	searchArticles(db, "modules", 4, maxSearchLimit, 0, &results)
+	var page Page
+	if err := getPageWithArticles(db, results[0].PageID, &page); err != nil {
+		t.Fatalf("Failed to get page: %v", err)
+	}
+	if results[0].ListID != 4 || page.Articles[results[0].Position].ID != results[0].ArticleID {
+		t.Errorf("Result %+v does not point at the article on page %+v", results[0], page)
+	}
+
+	// Updated and deleted articles are no longer found
+	if _, err := updatePage(results[0].PageID, []Article{{Title: "Replaced"}}, nil); err != nil {
+		t.Fatalf("Failed to update page: %v", err)
+	}
+	searchArticles(db, "modules", 4, maxSearchLimit, 0, &results)
+	if len(results) != 0 {
+		t.Errorf("Expected no results after the update, got %+v", results)
+	}
+	deleteList(4, nil)
+	searchArticles(db, "alice", 0, maxSearchLimit, 0, &results)
+	if len(results) != 0 {
+		t.Errorf("Expected no results after the delete, got %+v", results)
+	}
+}
+
+func TestSearchWildcards(t *testing.T) {
+	useTestDB(t)
+	for _, title := range []string{"100% done", "1000 done", "snake_case", "snakeXcase"} {
+		if _, err := appendArticle(4, Article{Title: title, Author: "Author", Content: "Content"}); err != nil {
+			t.Fatalf("Failed to append article: %v", err)
+		}
+	}
+
+	// The wildcards of LIKE are searched for literally
+	for query, expected := range map[string]string{"100%": "100% done", "snake_case": "snake_case"} {
+		var results []SearchResult
+		if err := searchArticles(db, query, 4, maxSearchLimit, 0, &results); err != nil {
+			t.Fatalf("Failed to search articles: %v", err)
+		}
+		if len(results) != 1 || results[0].Title != expected {
+			t.Errorf("Expected %q to find %q only, got %+v", query, expected, results)
+		}
+	}
+}
+
+func TestSearchPositionPrepend(t *testing.T) {
+	useTestDB(t)
+	prepend := ListModePrepend
+	if _, err := updateListSettings(7, ListSettings{Mode: &prepend}, nil); err != nil {
+		t.Fatalf("Error setting up list: %v", err)
+	}
+	for _, title := range []string{"Oldest", "Middle", "Newest"} {
+		if _, err := appendArticle(7, Article{Title: title, Author: "Author", Content: "Content"}); err != nil {
+			t.Fatalf("Failed to append article: %v", err)
+		}
+	}
+
+	// Positions follow the page as it is read, newest first
+	for _, title := range []string{"Oldest", "Middle", "Newest"} {
+		var results []SearchResult
+		if err := searchArticles(db, title, 7, maxSearchLimit, 0, &results); err != nil || len(results) != 1 {
+			t.Fatalf("Expected one result for %s, got %+v (%v)", title, results, err)
+		}
+		var page Page
+		if err := getPageWithArticles(db, results[0].PageID, &page); err != nil {
+			t.Fatalf("Failed to get page: %v", err)
+		}
+		if page.Articles[results[0].Position].Title != title {
+			t.Errorf("Expected position %d to hold %s, got %s", results[0].Position, title, page.Articles[results[0].Position].Title)
+		}
+	}
+}
+
+func TestSearchPositionSorted(t *testing.T) {
+	useTestDB(t)
+	sorted := ListModeSorted
+	if _, err := updateListSettings(7, ListSettings{Mode: &sorted}, nil); err != nil {
+		t.Fatalf("Error setting up list: %v", err)
+	}
+	articles := []Article{
+		{Title: "Low", Author: "Author", Content: "Content", Score: 1},
+		{Title: "High", Author: "Author", Content: "Content", Score: 9},
+		{Title: "Tied", Author: "Author", Content: "Content", Score: 5},
+		{Title: "Later", Author: "Author", Content: "Content", Score: 5},
+	}
+	for _, article := range articles {
+		if _, err := appendArticle(7, article); err != nil {
+			t.Fatalf("Failed to append article: %v", err)
+		}
+	}
+
+	// Positions follow the page as it is read, highest score first
+	for _, article := range articles {
+		var results []SearchResult
+		if err := searchArticles(db, article.Title, 7, maxSearchLimit, 0, &results); err != nil || len(results) != 1 {
+			t.Fatalf("Expected one result for %s, got %+v (%v)", article.Title, results, err)
+		}
+		var page Page
+		if err := getPageWithArticles(db, results[0].PageID, &page); err != nil {
+			t.Fatalf("Failed to get page: %v", err)
+		}
+		if page.Articles[results[0].Position].Title != article.Title {
+			t.Errorf("Expected position %d to hold %s, got %s", results[0].Position, article.Title, page.Articles[results[0].Position].Title)
+		}
+	}
+}
+
+func TestHandleSearch(t *testing.T) {
+	useTestDB(t)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/search", handleSearch).Methods("GET")
+
+	for i := 0; i < 3; i++ {
+		appendArticle(2, Article{Title: "Weekly report", Author: "Alice", Content: "Numbers"})
+	}
+
+	testCases := []struct {
+		name         string
+		url          string
+		expectedCode int
+		expectedLen  int
+	}{
+		{"match", "/search?q=report&list_id=2", http.StatusOK, 3},
+		{"limit", "/search?q=report&limit=2", http.StatusOK, 2},
+		{"offset", "/search?q=report&limit=2&offset=2", http.StatusOK, 1},
+		{"other list", "/search?q=report&list_id=3", http.StatusOK, 0},
+		{"missing query", "/search?list_id=2", http.StatusBadRequest, 0},
+		{"invalid list", "/search?q=report&list_id=abc", http.StatusBadRequest, 0},
+		{"invalid limit", "/search?q=report&limit=-1", http.StatusBadRequest, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.url, nil)
+			res := httptest.NewRecorder()
+			router.ServeHTTP(res, req)
+
+			if res.Code != tc.expectedCode {
+				t.Fatalf("Expected status code %d but got %d", tc.expectedCode, res.Code)
+			}
+			if tc.expectedCode != http.StatusOK {
+				return
+			}
+			var body struct {
+				Results []SearchResult `json:"results"`
+			}
+			if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if len(body.Results) != tc.expectedLen {
+				t.Errorf("Expected %d results but got %d", tc.expectedLen, len(body.Results))
+			}
+		})
+	}
+}
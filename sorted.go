@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// errSortedPage is returned when replacing a page of a sorted List, which
+// could break the order of its articles.
+var errSortedPage = errors.New("pages of sorted lists cannot be replaced, insert the articles instead")
+
+// GetNextSortedArticle gets the first article of the sorted List that comes
+// after a new article with the score. New articles go after the ones with
+// the same score.
+func getNextSortedArticle(db *gorm.DB, listID uint, score float64, article *Article) error {
+	return db.Table("articles").
+		Select("articles.id, articles.page_id, articles.score").
+		Joins("JOIN pages ON pages.id = articles.page_id").
+		Where("pages.list_id = ? AND articles.score < ?", listID, score).
+		Order("articles.score DESC").Order("articles.id").
+		Take(article).Error
+}
+
+// findSortedPage finds the page of the sorted List an article with the score
+// belongs on: the page of the article that comes after it, or the tail if
+// it has the lowest score. It returns gorm.ErrRecordNotFound if the List has
+// no pages.
+func findSortedPage(tx *gorm.DB, list *List, score float64, page *Page) error {
+	var next Article
+	err := getNextSortedArticle(tx, list.ID, score, &next)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return getLastPageByListID(tx, list.ID, page)
+	}
+	if err != nil {
+		return err
+	}
+	return getPageByID(tx, next.PageID, page)
+}
+
+// splitSortedPage splits a page of a sorted List that holds more than
+// NumberOfArticleInOnePage articles: the lower half moves to a new page
+// linked after it. It returns the new page, or a zero Page if the page was
+// not full.
+func splitSortedPage(tx *gorm.DB, list *List, page *Page) (Page, error) {
+	var count int64
+	if err := countArticlesByPageID(tx, page.ID, &count); err != nil {
+		return Page{}, err
+	}
+	if count <= NumberOfArticleInOnePage {
+		return Page{}, nil
+	}
+	if err := checkPageQuota(tx, list.ID); err != nil {
+		return Page{}, err
+	}
+
+	var moved []uint
+	if err := getLowestArticleIDs(tx, page.ID, int(count/2), &moved); err != nil {
+		return Page{}, err
+	}
+	next := Page{ListID: list.ID, NextPageID: page.NextPageID}
+	if err := createPage(tx, &next); err != nil {
+		return Page{}, err
+	}
+	if err := moveArticles(tx, moved, next.ID); err != nil {
+		return Page{}, err
+	}
+	if err := updateLastPageNextPageID(tx, page, next.ID); err != nil {
+		return Page{}, err
+	}
+	if err := incrementPageVersion(tx, page.ID); err != nil {
+		return Page{}, err
+	}
+	err := recordChange(tx, &Change{Type: EventPageCreate, ListID: list.ID, PageID: next.ID},
+		map[string]interface{}{"previous_page_id": page.ID, "next_page_id": next.NextPageID, "moved_article_ids": moved})
+	if err != nil {
+		return Page{}, err
+	}
+	return next, nil
+}
+
+// parseScore parses a bound of a score range. "-inf" and "+inf" leave the
+// range open.
+func parseScore(s string, name string, unbounded float64) (float64, error) {
+	if s == "" {
+		return unbounded, nil
+	}
+	score, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, fmt.Errorf("%s parameter is invalid, expected a number, -inf or +inf", name)
+	}
+	return score, nil
+}
+
+// getListRangeV2 returns the items of a sorted List with scores from
+// min_score to max_score, highest first unless order is lowest, after a
+// cursor. Like getListItemsV2, the response always has a next_cursor.
+func getListRangeV2(w http.ResponseWriter, r *http.Request) error {
+	listID, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+	query := r.URL.Query()
+	var bounds scoreBounds
+	if bounds.Min, err = parseScore(query.Get("min_score"), "min_score", math.Inf(-1)); err != nil {
+		return err
+	}
+	if bounds.Max, err = parseScore(query.Get("max_score"), "max_score", math.Inf(1)); err != nil {
+		return err
+	}
+	order := query.Get("order")
+	if order == "" {
+		order = MergeHighest
+	}
+	if order != MergeHighest && order != MergeLowest {
+		return fmt.Errorf("order parameter is invalid, expected %s or %s", MergeHighest, MergeLowest)
+	}
+
+	limit := defaultViewLimit
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			return fmt.Errorf("limit parameter is not a valid integer")
+		}
+		if limit > maxViewLimit {
+			limit = maxViewLimit
+		}
+	}
+
+	var list List
+	if err := loadList(listID, &list); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("list not found")
+		}
+		return fmt.Errorf("error fetching list: %v", err)
+	}
+	if list.Mode != ListModeSorted {
+		return fmt.Errorf("list %d is not sorted, only sorted lists are read by score", listID)
+	}
+
+	var cursor mergeCursor
+	if err := decodeMergeCursor(query.Get("cursor"), order, &cursor); err != nil {
+		return err
+	}
+	merged, hasMore, err := mergeLists(db, []uint{listID}, &cursor, &bounds, limit)
+	if err != nil {
+		return fmt.Errorf("error fetching items: %v", err)
+	}
+	items := []map[string]interface{}{}
+	for _, item := range merged {
+		items = append(items, map[string]interface{}{
+			"title":      item.Title,
+			"author":     item.Author,
+			"content":    item.Content,
+			"score":      item.Score,
+			"created_at": item.CreatedAt,
+		})
+	}
+
+	cursor.IssuedAt = time.Now().Unix()
+	return writeJSON(w, http.StatusOK, map[string]interface{}{
+		"items":       items,
+		"next_cursor": sealCursor(cursor),
+		"has_more":    hasMore,
+	})
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// appendScores appends articles titled by their scores to the list.
+func appendScores(t *testing.T, listID uint, scores ...float64) {
+	t.Helper()
+	for _, score := range scores {
+		article := Article{Title: fmt.Sprint(score), Author: "Author", Content: "Content", Score: score}
+		if _, err := appendArticle(listID, article); err != nil {
+			t.Fatalf("Error appending article %v: %v", score, err)
+		}
+	}
+}
+
+func TestSortedMode(t *testing.T) {
+	sorted := ListModeSorted
+	scores := []float64{5, 12, 3, 8, 1, 10, 7, 2, 11, 4, 9, 6}
+
+	testCases := []struct {
+		name     string
+		settings ListSettings
+		expected [][]string
+	}{
+		{"Uncapped", ListSettings{Mode: &sorted}, [][]string{
+			{"12", "11", "10", "9", "8"}, {"7", "6", "5", "4"}, {"3", "2", "1"},
+		}},
+		{"Max items", ListSettings{Mode: &sorted, MaxItems: newInt64(7)}, [][]string{
+			{"12", "11", "10", "9", "8"}, {"7", "6"},
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			useTestDB(t)
+			if _, err := updateListSettings(7, tc.settings, nil); err != nil {
+				t.Fatalf("Error setting up list: %v", err)
+			}
+			appendScores(t, 7, scores...)
+
+			if titles := listTitles(t, 7); !reflect.DeepEqual(titles, tc.expected) {
+				t.Errorf("Expected %v but got %v", tc.expected, titles)
+			}
+		})
+	}
+}
+
+func TestSortedModeIgnoresScoresOfOtherLists(t *testing.T) {
+	useTestDB(t)
+	appendScores(t, 7, 1, 3, 2)
+
+	expected := [][]string{{"1", "3", "2"}}
+	if titles := listTitles(t, 7); !reflect.DeepEqual(titles, expected) {
+		t.Errorf("Expected %v but got %v", expected, titles)
+	}
+}
+
+func TestListRangeV2(t *testing.T) {
+	useTestDB(t)
+	router := newRouter()
+	do := func(method, url, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, url, strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := do("PATCH", "/v1/lists/7", `{"mode": "sorted"}`); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d but got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	for _, score := range []float64{2.5, 9, -1, 4, 7, 4} {
+		body := fmt.Sprintf(`{"title": "%v", "author": "Author", "content": "Content", "score": %v}`, score, score)
+		if rec := do("POST", "/v1/lists/7/items", body); rec.Code != http.StatusCreated {
+			t.Fatalf("Expected status code %d but got %d: %s", http.StatusCreated, rec.Code, rec.Body.String())
+		}
+	}
+	appendTitles(t, 8, 1, 1)
+
+	readRange := func(url string) [][]string {
+		t.Helper()
+		var pages [][]string
+		cursor := ""
+		for {
+			rec := do("GET", url+"&cursor="+cursor, "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Expected status code %d but got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+			}
+			var res struct {
+				Items []struct {
+					Title string  `json:"title"`
+					Score float64 `json:"score"`
+				} `json:"items"`
+				NextCursor string `json:"next_cursor"`
+				HasMore    bool   `json:"has_more"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+				t.Fatalf("Error decoding response: %v", err)
+			}
+			titles := []string{}
+			for _, item := range res.Items {
+				if item.Title != fmt.Sprint(item.Score) {
+					t.Errorf("Expected item %s to have its score, got %v", item.Title, item.Score)
+				}
+				titles = append(titles, item.Title)
+			}
+			pages = append(pages, titles)
+			cursor = res.NextCursor
+			if !res.HasMore {
+				return pages
+			}
+		}
+	}
+
+	readCases := []struct {
+		name     string
+		url      string
+		expected [][]string
+	}{
+		{"Whole list", "/v2/lists/7/range?limit=4", [][]string{{"9", "7", "4", "4"}, {"2.5", "-1"}}},
+		{"Bounds", "/v2/lists/7/range?min_score=2.5&max_score=7&limit=2", [][]string{{"7", "4"}, {"4", "2.5"}}},
+		{"Lowest first", "/v2/lists/7/range?order=lowest&max_score=%2Binf&limit=3", [][]string{{"-1", "2.5", "4"}, {"4", "7", "9"}}},
+		{"Open bounds", "/v2/lists/7/range?min_score=-inf&max_score=3", [][]string{{"2.5", "-1"}}},
+		{"Empty range", "/v2/lists/7/range?min_score=10", [][]string{{}}},
+	}
+	for _, tc := range readCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if pages := readRange(tc.url); !reflect.DeepEqual(pages, tc.expected) {
+				t.Errorf("Expected %v but got %v", tc.expected, pages)
+			}
+		})
+	}
+
+	// Sorted lists merge by score like any other
+	rec := do("GET", "/v2/merge?lists=7,8&order=highest", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"score":9`) {
+		t.Errorf("Expected the merge to start with the highest score, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var list List
+	if err := getListByID(db, 7, &list); err != nil {
+		t.Fatalf("Error fetching list: %v", err)
+	}
+	// Pages carry the scores of their articles
+	page := do("GET", fmt.Sprintf("/v1/lists/7/pages/%d", list.NextPageID), "")
+	if page.Code != http.StatusOK || !strings.Contains(page.Body.String(), `"score":9`) {
+		t.Errorf("Expected the page to carry scores, got %d: %s", page.Code, page.Body.String())
+	}
+
+	errorCases := []struct {
+		name         string
+		method       string
+		url          string
+		body         string
+		expectedCode int
+	}{
+		{"Not sorted", "GET", "/v2/lists/8/range", "", http.StatusConflict},
+		{"Missing list", "GET", "/v2/lists/9/range", "", http.StatusNotFound},
+		{"Invalid score", "GET", "/v2/lists/7/range?min_score=high", "", http.StatusBadRequest},
+		{"Invalid order", "GET", "/v2/lists/7/range?order=newest", "", http.StatusBadRequest},
+		{"Replace page", "PUT", fmt.Sprintf("/v1/lists/7/pages/%d", list.NextPageID), `[{"title": "Title", "author": "Author", "content": "Content"}]`, http.StatusConflict},
+	}
+	for _, tc := range errorCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if rec := do(tc.method, tc.url, tc.body); rec.Code != tc.expectedCode {
+				t.Errorf("Expected status code %d but got %d: %s", tc.expectedCode, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestSortedModeItems(t *testing.T) {
+	useTestDB(t)
+	router := newRouter()
+	sorted := ListModeSorted
+	if _, err := updateListSettings(7, ListSettings{Mode: &sorted}, nil); err != nil {
+		t.Fatalf("Error setting up list: %v", err)
+	}
+	appendScores(t, 7, 40, 60, 35, 50, 45)
+
+	readItems := func(cursor string) ([]string, string, bool) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", "/v2/lists/7/items?limit=2&cursor="+cursor, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d but got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+		}
+		var res struct {
+			Items []struct {
+				Title string `json:"title"`
+			} `json:"items"`
+			NextCursor string `json:"next_cursor"`
+			HasMore    bool   `json:"has_more"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+			t.Fatalf("Error decoding response: %v", err)
+		}
+		titles := []string{}
+		for _, item := range res.Items {
+			titles = append(titles, item.Title)
+		}
+		return titles, res.NextCursor, res.HasMore
+	}
+
+	titles, cursor, _ := readItems("")
+	if expected := []string{"60", "50"}; !reflect.DeepEqual(titles, expected) {
+		t.Errorf("Expected %v but got %v", expected, titles)
+	}
+
+	// The split moves 45, 40 and 35 to a new page, the cursor still reads them
+	// once, and skips the new article before it
+	appendScores(t, 7, 55)
+	if pages := listTitles(t, 7); len(pages) != 2 {
+		t.Fatalf("Expected the page to be split, got %v", pages)
+	}
+	var rest []string
+	for hasMore := true; hasMore; {
+		titles, cursor, hasMore = readItems(cursor)
+		rest = append(rest, titles...)
+	}
+	if expected := []string{"45", "40", "35"}; !reflect.DeepEqual(rest, expected) {
+		t.Errorf("Expected %v but got %v", expected, rest)
+	}
+}
+
+func TestSortedModeDropsLowestAppend(t *testing.T) {
+	sorted := ListModeSorted
+	testCases := []struct {
+		name     string
+		settings ListSettings
+		expected [][]string
+	}{
+		{"Max items", ListSettings{Mode: &sorted, MaxItems: newInt64(5)}, [][]string{{"50", "40", "30"}, {"20", "10"}}},
+		{"Max pages", ListSettings{Mode: &sorted, MaxPages: newInt64(1)}, [][]string{{"50", "40", "30"}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			useTestDB(t)
+			if _, err := updateListSettings(7, tc.settings, nil); err != nil {
+				t.Fatalf("Error setting up list: %v", err)
+			}
+			appendScores(t, 7, 10, 20, 30, 40, 50)
+
+			_, events, cancel := listEvents.subscribe(7, 0)
+			defer cancel()
+			page, err := appendArticle(7, Article{Title: "1", Author: "Author", Content: "Content", Score: 1})
+			if err != nil {
+				t.Fatalf("Error appending article: %v", err)
+			}
+			if page.ID != 0 {
+				t.Errorf("Expected no page for a dropped article, got %d", page.ID)
+			}
+			if titles := listTitles(t, 7); !reflect.DeepEqual(titles, tc.expected) {
+				t.Errorf("Expected %v but got %v", tc.expected, titles)
+			}
+			for len(events) > 0 {
+				if event := <-events; event.Type == EventAppend {
+					t.Errorf("Expected no append event, got %+v", event)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// walkList calls fn for every page of the list, from the head along the
+// NextPageID links, until fn returns false. A link to a page that no longer
+// exists ends the list.
+func walkList(listID uint, fn func(page *Page) bool) error {
+	var list List
+	if err := loadList(listID, &list); err != nil {
+		return err
+	}
+
+	seen := make(map[uint]bool)
+	for id := list.NextPageID; id != 0; {
+		if seen[id] {
+			return fmt.Errorf("page %d appears twice in list %d", id, listID)
+		}
+		seen[id] = true
+
+		var page Page
+		if !cachedPages.get(id, &page) {
+			if err := loadPage(id, &page); err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return nil
+				}
+				return err
+			}
+		}
+		if !fn(&page) {
+			return nil
+		}
+		id = page.NextPageID
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// ListSettings changes the caps and the mode of a List. Fields that are nil
+// are left unchanged, and 0 removes a cap.
+type ListSettings struct {
+	MaxItems *int64  `json:"max_items"`
+	MaxPages *int64  `json:"max_pages"`
+	Mode     *string `json:"mode"`
+}
+
+// trimResult tells what trimList changed, to publish once the transaction
+// has committed.
+type trimResult struct {
+	// pages are the pages that lost articles, including the deleted ones
+	pages []uint
+	// relinked are the pages that became the tail of a List trimmed from
+	// its tail
+	relinked []uint
+	oldHead  uint
+	newHead  uint
+}
+
+// trimsFromTail tells if the List drops the articles at its tail when it is
+// over its caps: the oldest in prepend mode, the lowest scores in sorted mode.
+func trimsFromTail(list *List) bool {
+	return list.Mode == ListModePrepend || list.Mode == ListModeSorted
+}
+
+// trimList deletes the oldest pages of a capped List until it holds no more
+// than MaxPages pages and MaxItems articles. The oldest pages are unlinked
+// from the head of the List, or from its tail in prepend mode. Sorted Lists
+// are trimmed from the tail too, dropping the lowest scores. When deleting
+// the whole oldest page would leave fewer than MaxItems articles, only its
+// oldest articles are deleted, or its lowest scored ones. It must be called
+// with the transaction that locked the List.
+func trimList(tx *gorm.DB, list *List) (trimResult, error) {
+	res := trimResult{oldHead: list.NextPageID, newHead: list.NextPageID}
+	if list.MaxItems <= 0 && list.MaxPages <= 0 {
+		return res, nil
+	}
+
+	var pages, items int64
+	if err := countPagesByListID(tx, list.ID, &pages); err != nil {
+		return res, err
+	}
+	if err := countArticlesByListID(tx, list.ID, &items); err != nil {
+		return res, err
+	}
+
+	for pages > 0 {
+		overPages := list.MaxPages > 0 && pages > list.MaxPages
+		overItems := list.MaxItems > 0 && items > list.MaxItems
+		if !overPages && !overItems {
+			break
+		}
+
+		var oldest Page
+		var err error
+		if trimsFromTail(list) {
+			err = getLastPageByListID(tx, list.ID, &oldest)
+		} else {
+			err = getHeadPage(tx, list, &oldest)
+		}
+		if err != nil {
+			return res, err
+		}
+		var count int64
+		if err := countArticlesByPageID(tx, oldest.ID, &count); err != nil {
+			return res, err
+		}
+
+		if !overPages && items-count < list.MaxItems {
+			// Deleting the whole page would leave too few articles
+			var ids []uint
+			getIDs := getOldestArticleIDs
+			if list.Mode == ListModeSorted {
+				getIDs = getLowestArticleIDs
+			}
+			if err := getIDs(tx, oldest.ID, int(items-list.MaxItems), &ids); err != nil {
+				return res, err
+			}
+			if err := deleteArticlesByID(tx, ids); err != nil {
+				return res, err
+			}
+			if err := incrementPageVersion(tx, oldest.ID); err != nil {
+				return res, err
+			}
+			err := recordChange(tx, &Change{Type: EventTrim, ListID: list.ID, PageID: oldest.ID},
+				map[string]interface{}{"article_ids": ids, "page_deleted": false})
+			if err != nil {
+				return res, err
+			}
+			res.pages = append(res.pages, oldest.ID)
+			break
+		}
+		if pages == 1 {
+			// The last page is never deleted, it takes the next append
+			break
+		}
+
+		if err := deletePageByID(tx, oldest.ID); err != nil {
+			return res, err
+		}
+		err = recordChange(tx, &Change{Type: EventTrim, ListID: list.ID, PageID: oldest.ID},
+			map[string]interface{}{"deleted_articles": count, "page_deleted": true})
+		if err != nil {
+			return res, err
+		}
+		res.pages = append(res.pages, oldest.ID)
+		pages--
+		items -= count
+
+		if trimsFromTail(list) {
+			// The page before the old tail becomes the tail
+			var previous Page
+			if err := getPreviousPage(tx, list.ID, oldest.ID, &previous); err != nil {
+				return res, err
+			}
+			if err := updateLastPageNextPageID(tx, &previous, 0); err != nil {
+				return res, err
+			}
+			if err := incrementPageVersion(tx, previous.ID); err != nil {
+				return res, err
+			}
+			res.relinked = append(res.relinked, previous.ID)
+			continue
+		}
+		if err := updateListNextPageID(tx, list, oldest.NextPageID); err != nil {
+			return res, err
+		}
+		if err := recordChange(tx, &Change{Type: EventHeadSwap, ListID: list.ID, PageID: oldest.NextPageID}, nil); err != nil {
+			return res, err
+		}
+		res.newHead = oldest.NextPageID
+	}
+	return res, nil
+}
+
+// publish invalidates the trimmed pages and tells subscribers about them.
+func (res trimResult) publish(listID uint) {
+	cachedPages.invalidate(append(res.relinked, res.pages...)...)
+	for _, pageID := range res.pages {
+		listEvents.publish(ListEvent{Type: EventTrim, ListID: listID, PageID: pageID})
+	}
+	if res.newHead != res.oldHead {
+		listEvents.publish(ListEvent{Type: EventHeadSwap, ListID: listID, PageID: res.newHead})
+	}
+}
+
+// updateListSettings changes the caps and the mode of the List, creating it
+// if it does not exist, and trims it to the new caps in the same transaction. check is
+// called with the current List, nil if it does not exist, and may veto the
+// change by returning an error.
+func updateListSettings(listID uint, settings ListSettings, check func(list *List) error) (List, error) {
+	var list List
+	var trimmed trimResult
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := getListForUpdate(tx, listID, &list)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("error fetching list: %v", err)
+		}
+		found := err == nil
+
+		if check != nil {
+			listOrNil := &list
+			if !found {
+				listOrNil = nil
+			}
+			if err := check(listOrNil); err != nil {
+				return err
+			}
+		}
+		if !found {
+			list = List{ID: listID}
+			if err := createList(tx, &list); err != nil {
+				return fmt.Errorf("error creating list: %v", err)
+			}
+			if err := getListByID(tx, listID, &list); err != nil {
+				return fmt.Errorf("error fetching list: %v", err)
+			}
+		}
+
+		if settings.MaxItems != nil {
+			list.MaxItems = *settings.MaxItems
+		}
+		if settings.MaxPages != nil {
+			list.MaxPages = *settings.MaxPages
+		}
+		if settings.Mode != nil && *settings.Mode != list.Mode {
+			// Pages already in the list are in the order of the old mode
+			if list.NextPageID != 0 {
+				return fmt.Errorf("the mode of list %d can only be changed while it is empty", listID)
+			}
+			list.Mode = *settings.Mode
+		}
+		if err := saveListSettings(tx, &list); err != nil {
+			return fmt.Errorf("failed to update list: %v", err)
+		}
+		trimmed, err = trimList(tx, &list)
+		if err != nil {
+			return fmt.Errorf("failed to trim list: %v", err)
+		}
+		return bumpListVersion(tx, &list)
+	})
+	if err != nil {
+		return list, err
+	}
+	trimmed.publish(listID)
+	return list, nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// listTitles follows the List from its head and returns the titles of its
+// articles, page by page.
+func listTitles(t *testing.T, listID uint) [][]string {
+	t.Helper()
+	var list List
+	if err := getListByID(db, listID, &list); err != nil {
+		t.Fatalf("Error fetching list: %v", err)
+	}
+	var pages [][]string
+	for pageID := list.NextPageID; pageID != 0; {
+		var page Page
+		if err := loadPage(pageID, &page); err != nil {
+			t.Fatalf("Error loading page %d: %v", pageID, err)
+		}
+		titles := []string{}
+		for _, article := range page.Articles {
+			titles = append(titles, article.Title)
+		}
+		pages = append(pages, titles)
+		pageID = page.NextPageID
+	}
+	return pages
+}
+
+func appendTitles(t *testing.T, listID uint, from, to int) {
+	t.Helper()
+	for i := from; i <= to; i++ {
+		article := Article{Title: fmt.Sprint(i), Author: "Author", Content: "Content"}
+		if _, err := appendArticle(listID, article); err != nil {
+			t.Fatalf("Error appending article %d: %v", i, err)
+		}
+	}
+}
+
+func TestTrimList(t *testing.T) {
+	testCases := []struct {
+		name     string
+		settings ListSettings
+		appended int
+		expected [][]string
+	}{
+		{"Uncapped", ListSettings{}, 11, [][]string{
+			{"1", "2", "3", "4", "5"}, {"6", "7", "8", "9", "10"}, {"11"},
+		}},
+		{"Max pages", ListSettings{MaxPages: newInt64(2)}, 11, [][]string{
+			{"6", "7", "8", "9", "10"}, {"11"},
+		}},
+		{"Max items", ListSettings{MaxItems: newInt64(6)}, 11, [][]string{
+			{"6", "7", "8", "9", "10"}, {"11"},
+		}},
+		{"Max items within the head page", ListSettings{MaxItems: newInt64(8)}, 9, [][]string{
+			{"2", "3", "4", "5"}, {"6", "7", "8", "9"},
+		}},
+		{"Max items on one page", ListSettings{MaxItems: newInt64(2)}, 4, [][]string{
+			{"3", "4"},
+		}},
+		{"Both caps", ListSettings{MaxItems: newInt64(12), MaxPages: newInt64(2)}, 16, [][]string{
+			{"11", "12", "13", "14", "15"}, {"16"},
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			useTestDB(t)
+			if _, err := updateListSettings(7, tc.settings, nil); err != nil {
+				t.Fatalf("Error capping list: %v", err)
+			}
+			appendTitles(t, 7, 1, tc.appended)
+
+			if titles := listTitles(t, 7); !reflect.DeepEqual(titles, tc.expected) {
+				t.Errorf("Expected %v but got %v", tc.expected, titles)
+			}
+			var pages int64
+			if err := countPagesByListID(db, 7, &pages); err != nil || pages != int64(len(tc.expected)) {
+				t.Errorf("Expected the trimmed pages to be deleted, got %d pages (%v)", pages, err)
+			}
+		})
+	}
+}
+
+func TestTrimListRecordsChanges(t *testing.T) {
+	useTestDB(t)
+	if _, err := updateListSettings(7, ListSettings{MaxPages: newInt64(1)}, nil); err != nil {
+		t.Fatalf("Error capping list: %v", err)
+	}
+	appendTitles(t, 7, 1, 5)
+	var first List
+	if err := getListByID(db, 7, &first); err != nil {
+		t.Fatalf("Error fetching list: %v", err)
+	}
+	var cached Page
+	if err := loadPage(first.NextPageID, &cached); err != nil {
+		t.Fatalf("Error loading page: %v", err)
+	}
+
+	_, events, cancel := listEvents.subscribe(7, 0)
+	defer cancel()
+	appendTitles(t, 7, 6, 6)
+
+	var list List
+	if err := getListByID(db, 7, &list); err != nil {
+		t.Fatalf("Error fetching list: %v", err)
+	}
+	if list.NextPageID == first.NextPageID {
+		t.Fatalf("Expected the head to move past the trimmed page")
+	}
+	var page Page
+	if err := loadPage(first.NextPageID, &page); err == nil {
+		t.Errorf("Expected the trimmed page to be gone from the cache, got %+v", page)
+	}
+
+	var changes []Change
+	if err := getChangesSince(db, 0, 7, 100, &changes); err != nil {
+		t.Fatalf("Error fetching changes: %v", err)
+	}
+	var types []string
+	for _, change := range changes[len(changes)-4:] {
+		types = append(types, change.Type)
+	}
+	expected := []string{EventPageCreate, EventAppend, EventTrim, EventHeadSwap}
+	if !reflect.DeepEqual(types, expected) {
+		t.Errorf("Expected changes %v but got %v", expected, types)
+	}
+
+	var published []string
+	for len(events) > 0 {
+		published = append(published, (<-events).Type)
+	}
+	expected = []string{EventAppend, EventTrim, EventHeadSwap}
+	if !reflect.DeepEqual(published, expected) {
+		t.Errorf("Expected events %v but got %v", expected, published)
+	}
+}
+
+func TestUpdateListV1(t *testing.T) {
+	useTestDB(t)
+	router := newRouter()
+	appendTitles(t, 7, 1, 9)
+
+	do := func(method, url, body string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do("PATCH", "/v1/lists/7", `{"max_items": -1}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"field":"max_items"`) {
+		t.Errorf("Expected a negative cap to be rejected, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := do("PATCH", "/v1/lists/7", `{"max_items": 3}`, "If-Match", `"999-0"`); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected status code %d but got %d", http.StatusPreconditionFailed, rec.Code)
+	}
+
+	// Lowering the cap trims the list right away
+	rec = do("PATCH", "/v1/lists/7", `{"max_items": 3}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d but got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var res struct {
+		MaxItems int64 `json:"max_items"`
+		MaxPages int64 `json:"max_pages"`
+	}
+	json.NewDecoder(rec.Body).Decode(&res)
+	if res.MaxItems != 3 || res.MaxPages != 0 || rec.Header().Get("ETag") == "" {
+		t.Errorf("Unexpected response %+v", res)
+	}
+	if titles := listTitles(t, 7); !reflect.DeepEqual(titles, [][]string{{"7", "8", "9"}}) {
+		t.Errorf("Expected the list to be trimmed, got %v", titles)
+	}
+
+	// Fields that are left out keep their value, and 0 removes the cap
+	do("PATCH", "/v1/lists/7", `{"max_pages": 4}`)
+	do("PATCH", "/v1/lists/7", `{"max_items": 0}`)
+	var list List
+	if err := getListByID(db, 7, &list); err != nil || list.MaxItems != 0 || list.MaxPages != 4 {
+		t.Errorf("Unexpected caps %+v (%v)", list, err)
+	}
+
+	// A missing list is created with the caps
+	if rec := do("PATCH", "/v1/lists/8", `{"max_pages": 1}`); rec.Code != http.StatusOK {
+		t.Errorf("Expected status code %d but got %d", http.StatusOK, rec.Code)
+	}
+	var created List
+	if err := getListByID(db, 8, &created); err != nil || created.MaxPages != 1 {
+		t.Errorf("Expected list 8 to be created, got %+v (%v)", created, err)
+	}
+}
+
+func newInt64(n int64) *int64 {
+	return &n
+}
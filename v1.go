@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// deprecated marks the responses of a legacy route as deprecated, pointing
+// clients at the route that replaces it. The {id} and {pid} of the successor
+// are filled in from the list_id and page_id query parameters; the Link is
+// left out when the request does not name them.
+func deprecated(successor string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		if link, ok := successorURL(r, successor); ok {
+			w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", link))
+		}
+		next(w, r)
+	}
+}
+
+// successorURL fills in the successor of a legacy route for the request.
+func successorURL(r *http.Request, successor string) (string, bool) {
+	for placeholder, param := range map[string]string{"{id}": "list_id", "{pid}": "page_id"} {
+		if !strings.Contains(successor, placeholder) {
+			continue
+		}
+		id, err := strconv.ParseUint(r.URL.Query().Get(param), 10, 0)
+		if err != nil || id == 0 {
+			return "", false
+		}
+		successor = strings.ReplaceAll(successor, placeholder, strconv.FormatUint(id, 10))
+	}
+	return successor, true
+}
+
+// pathID reads a positive integer ID from the path of the request.
+func pathID(r *http.Request, name string) (uint, error) {
+	id, err := strconv.Atoi(mux.Vars(r)[name])
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("%s is not a valid integer", name)
+	}
+	return uint(id), nil
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		return fmt.Errorf("error encoding JSON response: %v", err)
+	}
+	return nil
+}
+
+func pageResponse(page *Page) map[string]interface{} {
+	articleData := []map[string]interface{}{}
+	for _, article := range page.Articles {
+		articleData = append(articleData, articleFields(article))
+	}
+	return map[string]interface{}{
+		"id":           page.ID,
+		"list_id":      page.ListID,
+		"next_page_id": page.NextPageID,
+		"version":      page.Version,
+		"articles":     articleData,
+	}
+}
+
+func getListV1(w http.ResponseWriter, r *http.Request) error {
+	listID, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+
+	var list List
+	if err := loadList(listID, &list); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("list not found")
+		}
+		return fmt.Errorf("error fetching list: %v", err)
+	}
+
+	setCacheHeaders(w, listETag(&list), list.UpdatedAt)
+	if notModified(r, listETag(&list), list.UpdatedAt) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	return writeJSON(w, http.StatusOK, listResponse(&list))
+}
+
+func listResponse(list *List) map[string]interface{} {
+	return map[string]interface{}{
+		"id":           list.ID,
+		"head_page_id": list.NextPageID,
+		"version":      list.Version,
+		"max_items":    list.MaxItems,
+		"max_pages":    list.MaxPages,
+		"mode":         listMode(list),
+	}
+}
+
+// listMode is the mode of the List, which is empty for a List created in
+// this process before it was read back.
+func listMode(list *List) string {
+	if list.Mode == "" {
+		return ListModeAppend
+	}
+	return list.Mode
+}
+
+func updateListV1(w http.ResponseWriter, r *http.Request) error {
+	listID, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+	// The caps and the mode are validated against the OpenAPI spec
+	var settings ListSettings
+	body, err := readBody(w, r)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, &settings); err != nil {
+		return fmt.Errorf("invalid request body: %v", err)
+	}
+
+	list, err := updateListSettings(listID, settings, func(list *List) error {
+		return listIfMatch(r, list)
+	})
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("ETag", listETag(&list))
+	return writeJSON(w, http.StatusOK, listResponse(&list))
+}
+
+func getPageV1(w http.ResponseWriter, r *http.Request) error {
+	listID, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+	pageID, err := pathID(r, "pid")
+	if err != nil {
+		return err
+	}
+
+	var page Page
+	if !cachedPages.get(pageID, &page) {
+		if err := loadPage(pageID, &page); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("page not found")
+			}
+			return fmt.Errorf("error getting page from database: %v", err)
+		}
+	}
+	if page.ListID != listID {
+		return fmt.Errorf("page not found")
+	}
+
+	setCacheHeaders(w, pageETag(&page), page.UpdatedAt)
+	if notModified(r, pageETag(&page), page.UpdatedAt) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	return writeJSON(w, http.StatusOK, pageResponse(&page))
+}
+
+func replacePageV1(w http.ResponseWriter, r *http.Request) error {
+	listID, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+	pageID, err := pathID(r, "pid")
+	if err != nil {
+		return err
+	}
+	articles, err := decodeArticles(w, r)
+	if err != nil {
+		return err
+	}
+
+	page, err := updatePage(pageID, articles, func(page *Page) error {
+		if page.ListID != listID {
+			return fmt.Errorf("page not found")
+		}
+		if !ifMatch(r, pageETag(page)) {
+			return errVersionConflict
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("ETag", pageETag(&page))
+	return writeJSON(w, http.StatusOK, pageResponse(&page))
+}
+
+func appendItemV1(w http.ResponseWriter, r *http.Request) error {
+	listID, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+	article, err := decodeArticle(w, r)
+	if err != nil {
+		return err
+	}
+
+	page, err := appendArticle(listID, article)
+	if err != nil {
+		return fmt.Errorf("failed to add article: %v", err)
+	}
+
+	// An article the list dropped at once is on no page
+	if page.ID != 0 {
+		w.Header().Set("Location", fmt.Sprintf("/v1/lists/%d/pages/%d", listID, page.ID))
+	}
+	return writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"page_id": page.ID,
+	})
+}
+
+func deleteListV1(w http.ResponseWriter, r *http.Request) error {
+	listID, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+
+	_, err = deleteList(listID, func(list *List) error {
+		if list == nil {
+			return fmt.Errorf("list not found")
+		}
+		return listIfMatch(r, list)
+	})
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
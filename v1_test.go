@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestV1API(t *testing.T) {
+	useTestDB(t)
+	router := newRouter()
+
+	do := func(method, path, body string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		return res
+	}
+
+	// Append an item to a new list
+	res := do("POST", "/v1/lists/7/items", `{"title": "Title", "author": "Author", "content": "Content"}`, nil)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d but got %d: %s", http.StatusCreated, res.Code, res.Body.String())
+	}
+	var created struct {
+		PageID uint `json:"page_id"`
+	}
+	json.Unmarshal(res.Body.Bytes(), &created)
+	location := fmt.Sprintf("/v1/lists/7/pages/%d", created.PageID)
+	if got := res.Header().Get("Location"); got != location {
+		t.Errorf("Expected location %s but got %s", location, got)
+	}
+
+	res = do("GET", "/v1/lists/7", "", nil)
+	var list struct {
+		HeadPageID uint `json:"head_page_id"`
+	}
+	json.Unmarshal(res.Body.Bytes(), &list)
+	if res.Code != http.StatusOK || list.HeadPageID != created.PageID {
+		t.Errorf("Expected the list to start at page %d, got %d: %s", created.PageID, res.Code, res.Body.String())
+	}
+
+	res = do("GET", location, "", nil)
+	if res.Code != http.StatusOK || !bytes.Contains(res.Body.Bytes(), []byte(`"title":"Title"`)) {
+		t.Errorf("Expected the page with the item, got %d: %s", res.Code, res.Body.String())
+	}
+	etag := res.Header().Get("ETag")
+
+	// Replace the items of the page, only if it has not changed
+	res = do("PUT", location, `[{"title": "New", "author": "Author", "content": "Content"}]`, map[string]string{"If-Match": `"0-0"`})
+	if res.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected status code %d but got %d", http.StatusPreconditionFailed, res.Code)
+	}
+	res = do("PUT", location, `[{"title": "New", "author": "Author", "content": "Content"}]`, map[string]string{"If-Match": etag})
+	if res.Code != http.StatusOK || !bytes.Contains(res.Body.Bytes(), []byte(`"title":"New"`)) {
+		t.Errorf("Expected the replaced page, got %d: %s", res.Code, res.Body.String())
+	}
+
+	// Pages are only found under their own list
+	if res = do("GET", fmt.Sprintf("/v1/lists/8/pages/%d", created.PageID), "", nil); res.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d but got %d", http.StatusNotFound, res.Code)
+	}
+	if res = do("PUT", fmt.Sprintf("/v1/lists/8/pages/%d", created.PageID), `{"title": "x", "author": "Author", "content": "Content"}`, nil); res.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d but got %d", http.StatusNotFound, res.Code)
+	}
+
+	if res = do("DELETE", "/v1/lists/7", "", nil); res.Code != http.StatusNoContent {
+		t.Errorf("Expected status code %d but got %d", http.StatusNoContent, res.Code)
+	}
+	if res = do("GET", location, "", nil); res.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d but got %d", http.StatusNotFound, res.Code)
+	}
+
+	testCases := []struct {
+		method       string
+		path         string
+		body         string
+		expectedCode int
+	}{
+		{"GET", "/v1/lists/42", "", http.StatusNotFound},
+		{"GET", "/v1/lists/abc", "", http.StatusBadRequest},
+		{"GET", "/v1/lists/7/pages/abc", "", http.StatusBadRequest},
+		{"POST", "/v1/lists/7/items", "not json", http.StatusBadRequest},
+		{"DELETE", "/v1/lists/42", "", http.StatusNotFound},
+	}
+	for _, tc := range testCases {
+		if res := do(tc.method, tc.path, tc.body, nil); res.Code != tc.expectedCode {
+			t.Errorf("%s %s: expected status code %d but got %d", tc.method, tc.path, tc.expectedCode, res.Code)
+		}
+	}
+}
+
+func TestLegacyRoutesDeprecated(t *testing.T) {
+	useTestDB(t)
+	router := newRouter()
+	addArticleToPage(Article{Title: "Title"})
+
+	testCases := []struct {
+		method    string
+		path      string
+		body      string
+		successor string
+	}{
+		{"GET", "/list/get?list_id=1", "", "/v1/lists/1"},
+		{"GET", "/page/get?page_id=1&list_id=1", "", "/v1/lists/1/pages/1"},
+		{"GET", "/page/get?page_id=1", "", ""},
+		{"POST", "/page/set", `{"title": "Title", "author": "Author", "content": "Content"}`, "/v1/lists/1/items"},
+		{"POST", "/page/update?page_id=1", `{"title": "Title", "author": "Author", "content": "Content"}`, ""},
+		{"DELETE", "/page/delete?list_id=1", "", "/v1/lists/1"},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest(tc.method, tc.path, bytes.NewBufferString(tc.body))
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+
+		if res.Code != http.StatusOK {
+			t.Errorf("%s %s: expected status code %d but got %d", tc.method, tc.path, http.StatusOK, res.Code)
+		}
+		if res.Header().Get("Deprecation") != "true" {
+			t.Errorf("%s %s: expected a Deprecation header", tc.method, tc.path)
+		}
+		// Successors that need IDs the request does not name are left out
+		link := ""
+		if tc.successor != "" {
+			link = fmt.Sprintf(`<%s>; rel="successor-version"`, tc.successor)
+		}
+		if got := res.Header().Get("Link"); got != link {
+			t.Errorf("%s %s: expected Link %s but got %s", tc.method, tc.path, link, got)
+		}
+	}
+}
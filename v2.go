@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// v2ItemFields are the fields of an item of the v2 API. Database IDs of
+// articles and pages are not sent, positions are only given as cursors.
+var v2ItemFields = []string{"title", "author", "content", "created_at"}
+
+func getListV2(w http.ResponseWriter, r *http.Request) error {
+	listID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || listID <= 0 {
+		return fmt.Errorf("list id is not a valid integer")
+	}
+
+	var list List
+	if err := loadList(uint(listID), &list); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("list not found")
+		}
+		return fmt.Errorf("error fetching list: %v", err)
+	}
+	var count int64
+	if err := countArticlesByListID(db, list.ID, &count); err != nil {
+		return fmt.Errorf("error counting items: %v", err)
+	}
+
+	setCacheHeaders(w, listETag(&list), list.UpdatedAt)
+	if notModified(r, listETag(&list), list.UpdatedAt) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	res := map[string]interface{}{
+		"id":         list.ID,
+		"version":    list.Version,
+		"item_count": count,
+		"updated_at": list.UpdatedAt,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		return fmt.Errorf("error encoding JSON response: %v", err)
+	}
+	return nil
+}
+
+// getListItemsV2 returns the items of a list after a cursor. The response
+// always has a next_cursor, which points after the last item sent, so that a
+// client at the end of the list can come back later for new items.
+func getListItemsV2(w http.ResponseWriter, r *http.Request) error {
+	listID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || listID <= 0 {
+		return fmt.Errorf("list id is not a valid integer")
+	}
+
+	limit := defaultViewLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			return fmt.Errorf("limit parameter is not a valid integer")
+		}
+		if limit > maxViewLimit {
+			limit = maxViewLimit
+		}
+	}
+
+	var after listCursor
+	if err := resolveCursor(db, uint(listID), r.URL.Query().Get("cursor"), &after); err != nil {
+		return err
+	}
+
+	var columns []string
+	for _, field := range v2ItemFields {
+		columns = append(columns, viewFields[field])
+	}
+	var articles []Article
+	if err := getFilteredArticles(db, uint(listID), ArticleFilter{}, after, columns, limit+1, &articles); err != nil {
+		return fmt.Errorf("error fetching items: %v", err)
+	}
+
+	hasMore := len(articles) > limit
+	if hasMore {
+		articles = articles[:limit]
+	}
+	items := []map[string]interface{}{}
+	for _, article := range articles {
+		items = append(items, projectArticle(article, v2ItemFields))
+		after.PageID, after.ArticleID, after.Score = article.PageID, article.ID, article.Score
+	}
+
+	res := map[string]interface{}{
+		"items":       items,
+		"next_cursor": encodeCursor(after),
+		"has_more":    hasMore,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		return fmt.Errorf("error encoding JSON response: %v", err)
+	}
+	return nil
+}
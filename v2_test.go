@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func newV2TestRouter() *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/v2/lists/{id}", handleGetListV2).Methods("GET")
+	router.HandleFunc("/v2/lists/{id}/items", handleGetListItemsV2).Methods("GET")
+	return router
+}
+
+type v2ItemsResponse struct {
+	Items      []map[string]interface{} `json:"items"`
+	NextCursor string                   `json:"next_cursor"`
+	HasMore    bool                     `json:"has_more"`
+}
+
+func getTestItems(t *testing.T, router *mux.Router, path string) (int, v2ItemsResponse) {
+	t.Helper()
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, httptest.NewRequest("GET", path, nil))
+	var body v2ItemsResponse
+	if res.Code == http.StatusOK {
+		if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+	}
+	return res.Code, body
+}
+
+func TestGetListItemsV2(t *testing.T) {
+	useTestDB(t)
+	router := newV2TestRouter()
+
+	for i := 1; i <= NumberOfArticleInOnePage+2; i++ {
+		appendArticle(3, Article{Title: fmt.Sprintf("Item %d", i)})
+	}
+
+	// Read the whole list three items at a time
+	var titles []string
+	cursor := ""
+	for {
+		code, body := getTestItems(t, router, "/v2/lists/3/items?limit=3&cursor="+cursor)
+		if code != http.StatusOK {
+			t.Fatalf("Expected status code %d but got %d", http.StatusOK, code)
+		}
+		for _, item := range body.Items {
+			if _, ok := item["page_id"]; ok {
+				t.Errorf("Expected no page IDs in items, got %v", item)
+			}
+			titles = append(titles, item["title"].(string))
+		}
+		cursor = body.NextCursor
+		if !body.HasMore {
+			break
+		}
+	}
+	if len(titles) != NumberOfArticleInOnePage+2 || titles[0] != "Item 1" || titles[len(titles)-1] != "Item 7" {
+		t.Fatalf("Unexpected items %v", titles)
+	}
+
+	// The cursor at the end of the list picks up items appended later, on a
+	// new page
+	for i := 8; i <= 10; i++ {
+		appendArticle(3, Article{Title: fmt.Sprintf("Item %d", i)})
+	}
+	_, body := getTestItems(t, router, "/v2/lists/3/items?cursor="+cursor)
+	if len(body.Items) != 3 || body.Items[0]["title"] != "Item 8" {
+		t.Errorf("Expected the new items, got %v", body.Items)
+	}
+
+	// Deleting the list throws its pages away, so the cursor expires
+	deleteList(3, nil)
+	appendArticle(3, Article{Title: "New item"})
+	if code, _ := getTestItems(t, router, "/v2/lists/3/items?cursor="+cursor); code != http.StatusGone {
+		t.Errorf("Expected status code %d but got %d", http.StatusGone, code)
+	}
+}
+
+func TestGetListItemsV2InvalidCursor(t *testing.T) {
+	useTestDB(t)
+	router := newV2TestRouter()
+
+	appendArticle(3, Article{Title: "Item"})
+	_, body := getTestItems(t, router, "/v2/lists/3/items")
+
+	payload, _, _ := strings.Cut(body.NextCursor, ".")
+	forged := encodeCursor(listCursor{ListID: 3})
+	forgedPayload, _, _ := strings.Cut(forged, ".")
+
+	testCases := []struct {
+		name         string
+		path         string
+		expectedCode int
+	}{
+		{"other list", "/v2/lists/4/items?cursor=" + body.NextCursor, http.StatusBadRequest},
+		{"garbage", "/v2/lists/3/items?cursor=abc", http.StatusBadRequest},
+		{"unsigned", "/v2/lists/3/items?cursor=" + payload, http.StatusBadRequest},
+		{"tampered", "/v2/lists/3/items?cursor=" + forgedPayload + "." + strings.Split(body.NextCursor, ".")[1], http.StatusBadRequest},
+		{"invalid list", "/v2/lists/abc/items", http.StatusBadRequest},
+		{"invalid limit", "/v2/lists/3/items?limit=x", http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if code, _ := getTestItems(t, router, tc.path); code != tc.expectedCode {
+				t.Errorf("Expected status code %d but got %d", tc.expectedCode, code)
+			}
+		})
+	}
+
+	// Cursors are only valid for cursorMaxAge
+	saved := cursorMaxAge
+	cursorMaxAge = -time.Second
+	defer func() { cursorMaxAge = saved }()
+	if code, _ := getTestItems(t, router, "/v2/lists/3/items?cursor="+body.NextCursor); code != http.StatusGone {
+		t.Errorf("Expected status code %d but got %d", http.StatusGone, code)
+	}
+}
+
+func TestGetListV2(t *testing.T) {
+	useTestDB(t)
+	router := newV2TestRouter()
+
+	appendArticle(3, Article{Title: "Item"})
+	appendArticle(3, Article{Title: "Item"})
+
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, httptest.NewRequest("GET", "/v2/lists/3", nil))
+	if res.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d but got %d", http.StatusOK, res.Code)
+	}
+	var body map[string]interface{}
+	json.Unmarshal(res.Body.Bytes(), &body)
+	if body["item_count"] != float64(2) {
+		t.Errorf("Expected 2 items but got %v", body["item_count"])
+	}
+	if res.Header().Get("ETag") == "" {
+		t.Error("Expected an ETag")
+	}
+
+	res = httptest.NewRecorder()
+	router.ServeHTTP(res, httptest.NewRequest("GET", "/v2/lists/42", nil))
+	if res.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d but got %d", http.StatusNotFound, res.Code)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxBodySize is the largest request body accepted, in bytes.
+var maxBodySize int64 = 1 << 20
+
+var errBodyTooLarge = errors.New("request body too large")
+
+// FieldError is a field of a request that failed validation. Field is the
+// path to the field in the request body, like "title" or "[2].author".
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError is the list of fields of a request that failed validation.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	var details []string
+	for _, f := range e.Fields {
+		details = append(details, f.Field+" "+f.Message)
+	}
+	return "validation failed: " + strings.Join(details, "; ")
+}
+
+// fieldPath joins the path of a value with the name of one of its fields.
+func fieldPath(at string, name string) string {
+	if at == "" {
+		return name
+	}
+	return at + "." + name
+}
+
+// validateFields checks the string fields of a struct against the rules in
+// their validate tags, appending failures to errs. The rules are
+// comma separated:
+//
+//	required   the field must not be empty or only whitespace
+//	max=<n>    the field must be at most n characters long
+//
+// Every string field must also be valid UTF-8. Fields are named after their
+// json tag.
+func validateFields(v interface{}, at string, errs *[]FieldError) {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if field.Type.Kind() != reflect.String {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = field.Name
+		}
+		path := fieldPath(at, name)
+		s := value.Field(i).String()
+
+		if !utf8.ValidString(s) {
+			*errs = append(*errs, FieldError{path, "must be valid UTF-8"})
+			continue
+		}
+		for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+			rule, arg, _ := strings.Cut(rule, "=")
+			switch rule {
+			case "required":
+				if strings.TrimSpace(s) == "" {
+					*errs = append(*errs, FieldError{path, "is required"})
+				}
+			case "max":
+				n, _ := strconv.Atoi(arg)
+				if utf8.RuneCountInString(s) > n {
+					*errs = append(*errs, FieldError{path, fmt.Sprintf("must be at most %d characters", n)})
+				}
+			}
+		}
+	}
+}
+
+// validateArticles checks the articles of a request. at is the path to the
+// articles in the request: "" for a single article at the top, or ending in
+// "[]" for an array, whose fields are then named after their index.
+func validateArticles(articles []Article, at string) error {
+	var errs []FieldError
+	for i := range articles {
+		path := at
+		if strings.HasSuffix(at, "[]") {
+			path = fmt.Sprintf("%s[%d]", strings.TrimSuffix(at, "[]"), i)
+		}
+		validateFields(&articles[i], path, &errs)
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Fields: errs}
+	}
+	return nil
+}
+
+// readBody reads the body of the request, failing with errBodyTooLarge past
+// maxBodySize bytes. The body must be valid UTF-8.
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		return nil, bodyReadError(err)
+	}
+	if !utf8.Valid(body) {
+		return nil, &ValidationError{Fields: []FieldError{{"body", "must be valid UTF-8"}}}
+	}
+	return body, nil
+}
+
+// bodyReadError wraps an error from reading a request body, telling apart
+// bodies over maxBodySize.
+func bodyReadError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return fmt.Errorf("%w, the limit is %d bytes", errBodyTooLarge, tooLarge.Limit)
+	}
+	return fmt.Errorf("invalid request body: %v", err)
+}
+
+// writeRequestError answers 400 with the fields that failed validation, or
+// 413 if the body was too large. It returns false for other errors, which
+// are left to the caller.
+func writeRequestError(w http.ResponseWriter, err error) bool {
+	var invalid *ValidationError
+	switch {
+	case errors.As(err, &invalid):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":  "invalid request body",
+			"fields": invalid.Fields,
+		})
+		return true
+	case errors.Is(err, errBodyTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestValidateArticles(t *testing.T) {
+	valid := Article{Title: "Title", Author: "Author", Content: "Content"}
+
+	testCases := []struct {
+		name     string
+		articles []Article
+		at       string
+		expected []FieldError
+	}{
+		{"Valid", []Article{valid}, "", nil},
+		{"Empty", []Article{{}}, "", []FieldError{
+			{"title", "is required"}, {"author", "is required"}, {"content", "is required"},
+		}},
+		{"Whitespace only", []Article{{Title: " \t", Author: "Author", Content: "Content"}}, "", []FieldError{
+			{"title", "is required"},
+		}},
+		{"Longest title", []Article{{Title: strings.Repeat("é", 255), Author: "Author", Content: "Content"}}, "", nil},
+		{"Title too long", []Article{{Title: strings.Repeat("a", 256), Author: "Author", Content: "Content"}}, "", []FieldError{
+			{"title", "must be at most 255 characters"},
+		}},
+		{"Long content", []Article{{Title: "Title", Author: "Author", Content: strings.Repeat("a", 10000)}}, "", nil},
+		{"Invalid UTF-8", []Article{{Title: "Title", Author: "\xff", Content: "Content"}}, "", []FieldError{
+			{"author", "must be valid UTF-8"},
+		}},
+		{"Array", []Article{valid, {Title: "Title", Content: "Content"}}, "[]", []FieldError{
+			{"[1].author", "is required"},
+		}},
+		{"Nested array", []Article{{Title: "Title", Author: "Author"}}, "articles[]", []FieldError{
+			{"articles[0].content", "is required"},
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateArticles(tc.articles, tc.at)
+			if tc.expected == nil {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			invalid, ok := err.(*ValidationError)
+			if !ok {
+				t.Fatalf("Expected a *ValidationError, got %v", err)
+			}
+			if !reflect.DeepEqual(invalid.Fields, tc.expected) {
+				t.Errorf("Expected %v but got %v", tc.expected, invalid.Fields)
+			}
+		})
+	}
+}
+
+// TestArticleSchemaMatchesRules checks that the Article schema of the
+// OpenAPI document has the same rules as the validate tags of Article.
+func TestArticleSchemaMatchesRules(t *testing.T) {
+	schema := apiSpec.Components.Schemas["Article"]
+	required := make(map[string]bool)
+	for _, name := range schema.Required {
+		required[name] = true
+	}
+
+	articleType := reflect.TypeOf(Article{})
+	for i := 0; i < articleType.NumField(); i++ {
+		field := articleType.Field(i)
+		rules := field.Tag.Get("validate")
+		if rules == "" {
+			continue
+		}
+		name := field.Tag.Get("json")
+		property, ok := schema.Properties[name]
+		if !ok {
+			t.Errorf("Article schema has no property %s", name)
+			continue
+		}
+
+		isRequired := false
+		maxLength := 0
+		for _, rule := range strings.Split(rules, ",") {
+			rule, arg, _ := strings.Cut(rule, "=")
+			switch rule {
+			case "required":
+				isRequired = true
+			case "max":
+				maxLength, _ = strconv.Atoi(arg)
+			}
+		}
+		if isRequired != required[name] || isRequired != (property.MinLength != nil && *property.MinLength == 1) {
+			t.Errorf("%s is required in the tags: %v, but not in the schema", name, isRequired)
+		}
+		if (maxLength == 0) != (property.MaxLength == nil) || (property.MaxLength != nil && *property.MaxLength != maxLength) {
+			t.Errorf("%s has a maximum length of %d in the tags but not in the schema", name, maxLength)
+		}
+	}
+}
+
+func TestHandleSetValidation(t *testing.T) {
+	useTestDB(t)
+
+	testCases := []struct {
+		name         string
+		body         string
+		expectedCode int
+		expectedBody string
+	}{
+		{"Empty article", `{}`, http.StatusBadRequest, `{"field":"title","message":"is required"}`},
+		{"Invalid UTF-8", "{\"title\": \"\xff\"}", http.StatusBadRequest, `{"field":"body","message":"must be valid UTF-8"}`},
+		{"Too large", `{"title": "` + strings.Repeat("a", 100) + `"}`, http.StatusRequestEntityTooLarge, "request body too large, the limit is 64 bytes"},
+		{"Valid", `{"title": "Title", "author": "Author", "content": "Content"}`, http.StatusOK, ""},
+	}
+
+	defer func(size int64) { maxBodySize = size }(maxBodySize)
+	maxBodySize = 64
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handleSet(rec, httptest.NewRequest("POST", "/page/set", strings.NewReader(tc.body)))
+			if rec.Code != tc.expectedCode {
+				t.Errorf("Expected status code %d but got %d: %s", tc.expectedCode, rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tc.expectedBody) {
+				t.Errorf("Expected body to contain %q but got %q", tc.expectedBody, rec.Body.String())
+			}
+		})
+	}
+
+	var count int64
+	if err := db.Model(&Article{}).Where("title = '' OR author = '' OR content = ''").Count(&count).Error; err != nil {
+		t.Fatalf("Error counting articles: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("Expected no empty articles to be stored, got %d", count)
+	}
+}
+
+func TestHandleUpdateValidation(t *testing.T) {
+	useTestDB(t)
+	page, err := appendArticle(FirstListKey, Article{Title: "Title", Author: "Author", Content: "Content"})
+	if err != nil {
+		t.Fatalf("Error adding article: %v", err)
+	}
+
+	body := `[{"title": "Title", "author": "Author", "content": "Content"}, {"title": "` + strings.Repeat("a", 256) + `", "author": "Author"}]`
+	rec := httptest.NewRecorder()
+	handleUpdate(rec, httptest.NewRequest("POST", "/page/update?page_id="+strconv.Itoa(int(page.ID)), strings.NewReader(body)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	var res struct {
+		Error  string       `json:"error"`
+		Fields []FieldError `json:"fields"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+	expected := []FieldError{{"[1].title", "must be at most 255 characters"}, {"[1].content", "is required"}}
+	if res.Error != "invalid request body" || !reflect.DeepEqual(res.Fields, expected) {
+		t.Errorf("Expected %v but got %+v", expected, res)
+	}
+
+	var stored Page
+	if err := loadPage(page.ID, &stored); err != nil {
+		t.Fatalf("Error loading page: %v", err)
+	}
+	if len(stored.Articles) != 1 || stored.Articles[0].Title != "Title" {
+		t.Errorf("Expected the page to be unchanged, got %+v", stored.Articles)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultViewLimit = 20
+	maxViewLimit     = 100
+)
+
+// viewFields are the article fields a view can project, with their columns.
+var viewFields = map[string]string{
+	"id":         "articles.id",
+	"page_id":    "articles.page_id",
+	"title":      "articles.title",
+	"author":     "articles.author",
+	"content":    "articles.content",
+	"created_at": "articles.created_at",
+	"updated_at": "articles.updated_at",
+}
+
+// defaultViewFields are sent when a view does not ask for specific fields.
+var defaultViewFields = []string{"id", "page_id", "title", "author", "content", "created_at"}
+
+// filterFields are the text fields a view can filter on.
+var filterFields = []string{"title", "author", "content"}
+
+// ArticleFilter selects the articles of a view. Empty fields match every
+// article.
+type ArticleFilter struct {
+	// Equals maps a field to the value it must have
+	Equals map[string]string
+	// Contains maps a field to a text it must contain, ignoring case
+	Contains      map[string]string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// GetFilteredArticles gets up to limit articles of the List that match the
+// filter, in list order, starting after the cursor. Only the given columns
+// are loaded, along with the ID and page ID of each article. Pages are
+// appended to a List with increasing IDs, so the list order is the order of
+// the page IDs and then of the article IDs. In prepend mode pages are only
+// added in front of the head, so the order is reversed: decreasing page IDs,
+// and the newest articles of each page first. Sorted Lists move articles
+// between pages when they split them, so they are read by score and then by
+// article ID, whatever page the articles are on.
+func getFilteredArticles(db *gorm.DB, listID uint, filter ArticleFilter, after listCursor, columns []string, limit int, articles *[]Article) error {
+	var list List
+	if err := getListByID(db, listID, &list); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	q := db.Table("articles").
+		Select(append([]string{"articles.id", "articles.page_id", "articles.score"}, columns...)).
+		Joins("JOIN pages ON pages.id = articles.page_id").
+		Where("pages.list_id = ?", listID)
+
+	for field, value := range filter.Equals {
+		q = q.Where(viewFields[field]+" = ?", value)
+	}
+	for field, value := range filter.Contains {
+		q = q.Where("LOWER("+viewFields[field]+") LIKE ? ESCAPE '\\'", "%"+escapeLike(strings.ToLower(value))+"%")
+	}
+	if !filter.CreatedAfter.IsZero() {
+		q = q.Where("articles.created_at > ?", filter.CreatedAfter)
+	}
+	if !filter.CreatedBefore.IsZero() {
+		q = q.Where("articles.created_at < ?", filter.CreatedBefore)
+	}
+
+	if list.Mode == ListModeSorted {
+		if after.PageID != 0 {
+			q = q.Where("(articles.score < ? OR (articles.score = ? AND articles.id > ?))", after.Score, after.Score, after.ArticleID)
+		}
+		*articles = nil
+		return q.Order("articles.score DESC").Order("articles.id").Limit(limit).Find(articles).Error
+	}
+
+	direction, compare := "ASC", ">"
+	if list.Mode == ListModePrepend {
+		direction, compare = "DESC", "<"
+	}
+	if after.PageID != 0 {
+		q = q.Where("(articles.page_id "+compare+" ? OR (articles.page_id = ? AND articles.id "+compare+" ?))", after.PageID, after.PageID, after.ArticleID)
+	}
+
+	*articles = nil
+	return q.Order("articles.page_id " + direction).Order("articles.id " + direction).Limit(limit).Find(articles).Error
+}
+
+// projectArticle returns the given fields of the article.
+func projectArticle(article Article, fields []string) map[string]interface{} {
+	res := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		switch field {
+		case "id":
+			res[field] = article.ID
+		case "page_id":
+			res[field] = article.PageID
+		case "title":
+			res[field] = article.Title
+		case "author":
+			res[field] = article.Author
+		case "content":
+			res[field] = article.Content
+		case "created_at":
+			res[field] = article.CreatedAt
+		case "updated_at":
+			res[field] = article.UpdatedAt
+		}
+	}
+	return res
+}
+
+// parseArticleFilter reads the filter of a view from the query parameters.
+func parseArticleFilter(r *http.Request, filter *ArticleFilter) error {
+	query := r.URL.Query()
+	filter.Equals = make(map[string]string)
+	filter.Contains = make(map[string]string)
+	for _, field := range filterFields {
+		if values, ok := query[field]; ok {
+			filter.Equals[field] = values[0]
+		}
+		if values, ok := query[field+"_contains"]; ok && values[0] != "" {
+			filter.Contains[field] = values[0]
+		}
+	}
+
+	var err error
+	if s := query.Get("created_after"); s != "" {
+		if filter.CreatedAfter, err = time.Parse(time.RFC3339, s); err != nil {
+			return fmt.Errorf("created_after parameter is invalid, expected an RFC 3339 time")
+		}
+	}
+	if s := query.Get("created_before"); s != "" {
+		if filter.CreatedBefore, err = time.Parse(time.RFC3339, s); err != nil {
+			return fmt.Errorf("created_before parameter is invalid, expected an RFC 3339 time")
+		}
+	}
+	return nil
+}
+
+// parseViewFields reads the projected fields of a view from the fields
+// query parameter.
+func parseViewFields(r *http.Request) ([]string, error) {
+	s := r.URL.Query().Get("fields")
+	if s == "" {
+		return defaultViewFields, nil
+	}
+	var fields []string
+	seen := make(map[string]bool)
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if _, ok := viewFields[field]; !ok {
+			return nil, fmt.Errorf("fields parameter is invalid, unknown field %q", field)
+		}
+		if !seen[field] {
+			seen[field] = true
+			fields = append(fields, field)
+		}
+	}
+	return fields, nil
+}
+
+func queryList(w http.ResponseWriter, r *http.Request) error {
+	listID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || listID <= 0 {
+		return fmt.Errorf("list id is not a valid integer")
+	}
+
+	var filter ArticleFilter
+	if err := parseArticleFilter(r, &filter); err != nil {
+		return err
+	}
+	fields, err := parseViewFields(r)
+	if err != nil {
+		return err
+	}
+
+	limit := defaultViewLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			return fmt.Errorf("limit parameter is not a valid integer")
+		}
+		if limit > maxViewLimit {
+			limit = maxViewLimit
+		}
+	}
+
+	var after listCursor
+	if err := resolveCursor(db, uint(listID), r.URL.Query().Get("cursor"), &after); err != nil {
+		return err
+	}
+
+	// Load only the columns that are sent
+	var columns []string
+	for _, field := range fields {
+		if field != "id" && field != "page_id" {
+			columns = append(columns, viewFields[field])
+		}
+	}
+
+	// Fetch one more article than asked for to know if there are more
+	var articles []Article
+	if err := getFilteredArticles(db, uint(listID), filter, after, columns, limit+1, &articles); err != nil {
+		return fmt.Errorf("error fetching articles: %v", err)
+	}
+
+	nextCursor := ""
+	if len(articles) > limit {
+		articles = articles[:limit]
+		last := articles[len(articles)-1]
+		after.PageID, after.ArticleID, after.Score = last.PageID, last.ID, last.Score
+		nextCursor = encodeCursor(after)
+	}
+
+	articleData := []map[string]interface{}{}
+	for _, article := range articles {
+		articleData = append(articleData, projectArticle(article, fields))
+	}
+	res := map[string]interface{}{
+		"articles":    articleData,
+		"next_cursor": nextCursor,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		return fmt.Errorf("error encoding JSON response: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func queryTestView(t *testing.T, router *mux.Router, path string) (res *httptest.ResponseRecorder, articles []map[string]interface{}, nextCursor string) {
+	t.Helper()
+	req := httptest.NewRequest("GET", path, nil)
+	res = httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	if res.Code != http.StatusOK {
+		return res, nil, ""
+	}
+
+	var body struct {
+		Articles   []map[string]interface{} `json:"articles"`
+		NextCursor string                   `json:"next_cursor"`
+	}
+	if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return res, body.Articles, body.NextCursor
+}
+
+func TestQueryList(t *testing.T) {
+	useTestDB(t)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/lists/{id}/articles", handleQueryList).Methods("GET")
+
+	// Three pages of articles by two authors
+	for i := 1; i <= 2*NumberOfArticleInOnePage+3; i++ {
+		author := "Alice"
+		if i%2 == 0 {
+			author = "Bob"
+		}
+		appendArticle(5, Article{Title: fmt.Sprintf("Article %d", i), Author: author, Content: "Long content 100%"})
+	}
+	appendArticle(6, Article{Title: "Article on another list", Author: "Alice"})
+
+	testCases := []struct {
+		name     string
+		query    string
+		expected []string
+	}{
+		{"all", "", []string{"Article 1", "Article 2", "Article 3", "Article 4", "Article 5", "Article 6", "Article 7",
+			"Article 8", "Article 9", "Article 10", "Article 11", "Article 12", "Article 13"}},
+		{"author", "author=Bob", []string{"Article 2", "Article 4", "Article 6", "Article 8", "Article 10", "Article 12"}},
+		{"title contains", "title_contains=article+1", []string{"Article 1", "Article 10", "Article 11", "Article 12", "Article 13"}},
+		{"both", "author=Alice&title_contains=1", []string{"Article 1", "Article 11", "Article 13"}},
+		{"wildcards are literal", "content_contains=_00", []string{}},
+		{"created after", "created_after=" + url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339)), []string{}},
+		{"created before", "author=Bob&created_before=" + url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339)),
+			[]string{"Article 2", "Article 4", "Article 6", "Article 8", "Article 10", "Article 12"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Read the view two articles at a time
+			var titles []string
+			path := "/lists/5/articles?limit=2&" + tc.query
+			for pages := 0; ; pages++ {
+				if pages > len(tc.expected) {
+					t.Fatal("Expected the cursors to end")
+				}
+				res, articles, nextCursor := queryTestView(t, router, path)
+				if res.Code != http.StatusOK {
+					t.Fatalf("Expected status code %d but got %d: %s", http.StatusOK, res.Code, res.Body.String())
+				}
+				for _, article := range articles {
+					titles = append(titles, article["title"].(string))
+				}
+				if nextCursor == "" {
+					break
+				}
+				path = "/lists/5/articles?limit=2&" + tc.query + "&cursor=" + nextCursor
+			}
+
+			if len(titles) != len(tc.expected) {
+				t.Fatalf("Expected %v but got %v", tc.expected, titles)
+			}
+			for i, title := range titles {
+				if title != tc.expected[i] {
+					t.Errorf("Expected article %d to be %q but got %q", i, tc.expected[i], title)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryListProjection(t *testing.T) {
+	useTestDB(t)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/lists/{id}/articles", handleQueryList).Methods("GET")
+
+	appendArticle(5, Article{Title: "Title", Author: "Author", Content: "Content"})
+
+	_, articles, _ := queryTestView(t, router, "/lists/5/articles?fields=title,author")
+	if len(articles) != 1 {
+		t.Fatalf("Expected 1 article but got %d", len(articles))
+	}
+	expected := map[string]interface{}{"title": "Title", "author": "Author"}
+	if len(articles[0]) != len(expected) || articles[0]["title"] != "Title" || articles[0]["author"] != "Author" {
+		t.Errorf("Expected %v but got %v", expected, articles[0])
+	}
+
+	// The cursor of one list cannot be used with another
+	appendArticle(5, Article{Title: "Second"})
+	_, _, cursor := queryTestView(t, router, "/lists/5/articles?limit=1")
+	res, _, _ := queryTestView(t, router, "/lists/6/articles?cursor="+cursor)
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d but got %d", http.StatusBadRequest, res.Code)
+	}
+
+	for _, path := range []string{
+		"/lists/abc/articles",
+		"/lists/5/articles?fields=title,secret",
+		"/lists/5/articles?created_after=yesterday",
+		"/lists/5/articles?limit=0",
+		"/lists/5/articles?cursor=not-a-cursor",
+	} {
+		res, _, _ := queryTestView(t, router, path)
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d for %s but got %d", http.StatusBadRequest, path, res.Code)
+		}
+	}
+}
@@ -0,0 +1,697 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// States of a webhook delivery. A delivery that keeps failing ends up dead
+// and is only sent again when it is retried explicitly.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// Headers sent with every webhook delivery.
+const (
+	WebhookIDHeader        = "X-Webhook-Id"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// webhookCursor is the name of the change log cursor of the dispatcher.
+const webhookCursor = "webhooks"
+
+var (
+	// webhookMaxAttempts is how often a delivery is tried before it is dead.
+	webhookMaxAttempts = 8
+	// webhookRetryBase is the delay before the first retry, it doubles with
+	// every further attempt up to webhookRetryMax.
+	webhookRetryBase = 10 * time.Second
+	webhookRetryMax  = time.Hour
+	// webhookWorkers is how many webhooks are sent deliveries at once.
+	webhookWorkers = 8
+	// allowPrivateWebhooks lets webhooks post to loopback, link-local and
+	// private addresses. They are refused by default so that a webhook cannot
+	// reach the services on the network of the server.
+	allowPrivateWebhooks = false
+	webhookClient        = &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{Timeout: 10 * time.Second, Control: checkWebhookAddress}).DialContext,
+		},
+	}
+)
+
+// webhookEventTypes are the change types a webhook can subscribe to.
+var webhookEventTypes = map[string]bool{
+	EventPageCreate: true,
+	EventHeadSwap:   true,
+	EventAppend:     true,
+	EventPageUpdate: true,
+	EventDelete:     true,
+	EventTrim:       true,
+}
+
+func createWebhook(db *gorm.DB, hook *Webhook) error {
+	return db.Table("webhooks").Create(hook).Error
+}
+
+func getWebhookByID(db *gorm.DB, id uint, hook *Webhook) error {
+	return db.Table("webhooks").First(hook, id).Error
+}
+
+// GetWebhooksByListID gets the Webhooks registered for the List.
+func getWebhooksByListID(db *gorm.DB, listID uint, hooks *[]Webhook) error {
+	return db.Table("webhooks").Where("list_id = ?", listID).Order("id").Find(hooks).Error
+}
+
+// DeleteWebhook deletes the Webhook and its deliveries.
+func deleteWebhook(db *gorm.DB, id uint) error {
+	if err := db.Where("webhook_id = ?", id).Delete(&WebhookDelivery{}).Error; err != nil {
+		return err
+	}
+	return db.Delete(&Webhook{}, id).Error
+}
+
+func createWebhookDelivery(db *gorm.DB, delivery *WebhookDelivery) error {
+	return db.Table("webhook_deliveries").Create(delivery).Error
+}
+
+func saveWebhookDelivery(db *gorm.DB, delivery *WebhookDelivery) error {
+	return db.Table("webhook_deliveries").Save(delivery).Error
+}
+
+func getWebhookDeliveryByID(db *gorm.DB, id uint, delivery *WebhookDelivery) error {
+	return db.Table("webhook_deliveries").First(delivery, id).Error
+}
+
+// GetDueWebhookDeliveries gets up to limit pending deliveries that are due at
+// the given time, oldest first. Deliveries queued behind an older pending one
+// of their webhook that is not due yet wait for it, so that every webhook gets
+// its deliveries in order.
+func getDueWebhookDeliveries(db *gorm.DB, now time.Time, limit int, deliveries *[]WebhookDelivery) error {
+	return db.Table("webhook_deliveries").
+		Where("status = ? AND next_attempt_at <= ?", DeliveryPending, now).
+		Where("NOT EXISTS (SELECT 1 FROM webhook_deliveries AS earlier WHERE earlier.webhook_id = webhook_deliveries.webhook_id "+
+			"AND earlier.id < webhook_deliveries.id AND earlier.status = ? AND earlier.next_attempt_at > ?)", DeliveryPending, now).
+		Order("id").Limit(limit).Find(deliveries).Error
+}
+
+// GetWebhookDeliveries gets the most recent deliveries of the Webhook. An empty
+// status matches every status.
+func getWebhookDeliveries(db *gorm.DB, webhookID uint, status string, limit int, deliveries *[]WebhookDelivery) error {
+	query := db.Table("webhook_deliveries").Where("webhook_id = ?", webhookID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	return query.Order("id DESC").Limit(limit).Find(deliveries).Error
+}
+
+func getDispatchCursor(db *gorm.DB, name string, cursor *DispatchCursor) error {
+	return db.Table("dispatch_cursors").Where("name = ?", name).First(cursor).Error
+}
+
+func saveDispatchCursor(db *gorm.DB, cursor *DispatchCursor) error {
+	return db.Table("dispatch_cursors").Save(cursor).Error
+}
+
+// webhookAccepts reports whether the webhook subscribed to the change type.
+func webhookAccepts(hook *Webhook, eventType string) bool {
+	if hook.EventTypes == "" {
+		return true
+	}
+	for _, t := range strings.Split(hook.EventTypes, ",") {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// signWebhookPayload signs the timestamp and body of a delivery with the
+// secret of the webhook. Receivers compute the same HMAC to check that the
+// delivery is authentic and has not been replayed with another timestamp.
+func signWebhookPayload(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// isPrivateWebhookIP reports whether the IP is one webhooks may only post to
+// with allowPrivateWebhooks.
+func isPrivateWebhookIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast()
+}
+
+// isPrivateWebhookHost reports whether the host of a webhook URL is private
+// without resolving it. Names are checked again once resolved, when they are
+// dialed.
+func isPrivateWebhookHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && isPrivateWebhookIP(ip)
+}
+
+// checkWebhookAddress refuses to connect webhooks to private addresses. It
+// runs on the resolved address, so a name that resolves to a private address
+// is refused too, on every redirect.
+func checkWebhookAddress(network string, address string, c syscall.RawConn) error {
+	if allowPrivateWebhooks {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || isPrivateWebhookIP(ip) {
+		return fmt.Errorf("webhook address %s is private", host)
+	}
+	return nil
+}
+
+// webhookRetryDelay returns how long to wait after the given number of failed
+// attempts.
+func webhookRetryDelay(attempts int) time.Duration {
+	delay := webhookRetryBase
+	for i := 1; i < attempts && delay < webhookRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > webhookRetryMax {
+		delay = webhookRetryMax
+	}
+	return delay
+}
+
+// enqueueWebhookDeliveries reads the changes the dispatcher has not seen yet
+// and creates a pending delivery for every webhook that subscribed to them.
+// The deliveries and the new position of the cursor are saved together, so
+// no change is enqueued twice or skipped.
+func enqueueWebhookDeliveries() error {
+	// The horizon is found outside the transaction, which would otherwise hold
+	// back writers until it commits
+	var horizon uint64
+	if err := getChangeHorizon(db, &horizon); err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		cursor := DispatchCursor{Name: webhookCursor}
+		err := getDispatchCursor(tx, webhookCursor, &cursor)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// No webhook was ever registered, so there is nothing to deliver
+			cursor.Seq = horizon
+			return saveDispatchCursor(tx, &cursor)
+		}
+		if err != nil {
+			return err
+		}
+
+		var changes []Change
+		if err := getChangesBetween(tx, cursor.Seq, horizon, 0, maxChangesLimit, &changes); err != nil {
+			return err
+		}
+		if len(changes) == 0 {
+			return nil
+		}
+
+		hooksByList := make(map[uint][]Webhook)
+		for _, change := range changes {
+			hooks, ok := hooksByList[change.ListID]
+			if !ok {
+				if err := getWebhooksByListID(tx, change.ListID, &hooks); err != nil {
+					return err
+				}
+				hooksByList[change.ListID] = hooks
+			}
+
+			var payload []byte
+			for _, hook := range hooks {
+				if change.Seq <= hook.AfterSeq || !webhookAccepts(&hook, change.Type) {
+					continue
+				}
+				if payload == nil {
+					payload, err = json.Marshal(toChangeResponse(change))
+					if err != nil {
+						return err
+					}
+				}
+				delivery := WebhookDelivery{
+					WebhookID:     hook.ID,
+					ChangeSeq:     change.Seq,
+					EventType:     change.Type,
+					Payload:       string(payload),
+					Status:        DeliveryPending,
+					NextAttemptAt: time.Now(),
+				}
+				if err := createWebhookDelivery(tx, &delivery); err != nil {
+					return err
+				}
+			}
+			cursor.Seq = change.Seq
+		}
+		return saveDispatchCursor(tx, &cursor)
+	})
+}
+
+// sendWebhookDelivery posts the delivery to the webhook and returns the
+// status code of the response.
+func sendWebhookDelivery(hook *Webhook, delivery *WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookIDHeader, strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set(WebhookEventHeader, delivery.EventType)
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, signWebhookPayload(hook.Secret, timestamp, body))
+
+	res, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+	return res.StatusCode, nil
+}
+
+// sendDueWebhookDeliveries sends the pending deliveries that are due and
+// schedules a retry for the ones that fail. Deliveries of one webhook are sent
+// in order, webhookWorkers webhooks at a time.
+func sendDueWebhookDeliveries() error {
+	var deliveries []WebhookDelivery
+	if err := getDueWebhookDeliveries(db, time.Now(), maxChangesLimit, &deliveries); err != nil {
+		return err
+	}
+
+	var hookIDs []uint
+	byHook := make(map[uint][]*WebhookDelivery)
+	for i := range deliveries {
+		hookID := deliveries[i].WebhookID
+		if _, ok := byHook[hookID]; !ok {
+			hookIDs = append(hookIDs, hookID)
+		}
+		byHook[hookID] = append(byHook[hookID], &deliveries[i])
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	queue := make(chan uint)
+	for i := 0; i < webhookWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for hookID := range queue {
+				if err := sendWebhookDeliveries(hookID, byHook[hookID]); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, hookID := range hookIDs {
+		queue <- hookID
+	}
+	close(queue)
+	wg.Wait()
+	return firstErr
+}
+
+// webhookCanRead tells if the API key that registered the webhook can still
+// read its List. It may have been revoked, or lost its grant, since.
+func webhookCanRead(hook *Webhook) (bool, error) {
+	if hook.KeyID == 0 {
+		return true, nil
+	}
+	var key APIKey
+	if err := getAPIKeyByID(db, hook.KeyID, &key); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	if key.RevokedAt != nil {
+		return false, nil
+	}
+	return apiKeyPrincipal(&key).canAccessList(db, hook.ListID, ScopeRead)
+}
+
+// sendWebhookDeliveries sends the due deliveries of the webhook in order. Once
+// one fails, the rest wait until it is delivered or dead, so a webhook that is
+// down costs one timeout a retry.
+func sendWebhookDeliveries(hookID uint, deliveries []*WebhookDelivery) error {
+	hook := &Webhook{}
+	if err := getWebhookByID(db, hookID, hook); err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		hook = nil
+	}
+	denied := false
+	if hook != nil {
+		allowed, err := webhookCanRead(hook)
+		if err != nil {
+			return err
+		}
+		denied = !allowed
+	}
+
+	for _, delivery := range deliveries {
+		delivery.Attempts++
+		failed := false
+		if hook == nil {
+			delivery.Status = DeliveryDead
+			delivery.LastError = "webhook was deleted"
+		} else if denied {
+			delivery.Status = DeliveryDead
+			delivery.LastError = fmt.Sprintf("webhook has no read access to list %d", hook.ListID)
+		} else {
+			statusCode, err := sendWebhookDelivery(hook, delivery)
+			delivery.LastStatusCode = statusCode
+			switch {
+			case err == nil:
+				delivery.Status = DeliveryDelivered
+				delivery.LastError = ""
+			case delivery.Attempts >= webhookMaxAttempts:
+				delivery.Status = DeliveryDead
+				delivery.LastError = err.Error()
+			default:
+				delivery.NextAttemptAt = time.Now().Add(webhookRetryDelay(delivery.Attempts))
+				delivery.LastError = err.Error()
+			}
+			failed = err != nil
+		}
+		if err := saveWebhookDelivery(db, delivery); err != nil {
+			return err
+		}
+		if failed {
+			return nil
+		}
+	}
+	return nil
+}
+
+// dispatchWebhooks enqueues the deliveries for new changes and sends the ones
+// that are due.
+func dispatchWebhooks() error {
+	if err := enqueueWebhookDeliveries(); err != nil {
+		return fmt.Errorf("failed to enqueue webhook deliveries: %v", err)
+	}
+	if err := sendDueWebhookDeliveries(); err != nil {
+		return fmt.Errorf("failed to send webhook deliveries: %v", err)
+	}
+	return nil
+}
+
+// dispatchWebhooksEvery dispatches webhooks at the given interval until done
+// is closed.
+func dispatchWebhooksEvery(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := dispatchWebhooks(); err != nil {
+				log.Printf("Error dispatching webhooks: %v\n", err)
+			}
+		}
+	}
+}
+
+// webhookResponse is a Webhook as sent to clients. The secret is only sent
+// back when the webhook is registered.
+type webhookResponse struct {
+	ID        uint      `json:"id"`
+	ListID    uint      `json:"list_id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func toWebhookResponse(hook *Webhook) webhookResponse {
+	events := []string{}
+	if hook.EventTypes != "" {
+		events = strings.Split(hook.EventTypes, ",")
+	}
+	return webhookResponse{
+		ID:        hook.ID,
+		ListID:    hook.ListID,
+		URL:       hook.URL,
+		Events:    events,
+		CreatedAt: hook.CreatedAt,
+	}
+}
+
+func registerWebhook(w http.ResponseWriter, r *http.Request) error {
+	listID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || listID <= 0 {
+		return fmt.Errorf("list id is not a valid integer")
+	}
+
+	var req struct {
+		URL    string   `json:"url"`
+		Secret string   `json:"secret"`
+		Events []string `json:"events"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return fmt.Errorf("invalid request body: %v", err)
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid request body: url must be an absolute http or https URL")
+	}
+	if !allowPrivateWebhooks && isPrivateWebhookHost(u.Hostname()) {
+		return fmt.Errorf("invalid request body: url must not point to a private address")
+	}
+	for _, event := range req.Events {
+		if !webhookEventTypes[event] {
+			return fmt.Errorf("invalid request body: unknown event type %q", event)
+		}
+	}
+	if req.Secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return fmt.Errorf("failed to generate secret: %v", err)
+		}
+		req.Secret = hex.EncodeToString(b)
+	}
+
+	hook := Webhook{
+		ListID:     uint(listID),
+		URL:        req.URL,
+		Secret:     req.Secret,
+		EventTypes: strings.Join(req.Events, ","),
+	}
+	if p := principalFromContext(r.Context()); p != nil && p.Key != nil {
+		hook.KeyID = p.Key.ID
+	}
+	// Deliver only the changes made from now on
+	if err := getChangeHorizon(db, &hook.AfterSeq); err != nil {
+		return fmt.Errorf("failed to register webhook: %v", err)
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := createWebhook(tx, &hook); err != nil {
+			return err
+		}
+		// Start the dispatcher here if this is the first webhook
+		var cursor DispatchCursor
+		err := getDispatchCursor(tx, webhookCursor, &cursor)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			cursor = DispatchCursor{Name: webhookCursor, Seq: hook.AfterSeq}
+			return saveDispatchCursor(tx, &cursor)
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register webhook: %v", err)
+	}
+
+	res := toWebhookResponse(&hook)
+	res.Secret = hook.Secret
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		return fmt.Errorf("error encoding JSON response: %v", err)
+	}
+	return nil
+}
+
+func listWebhooks(w http.ResponseWriter, r *http.Request) error {
+	listID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || listID <= 0 {
+		return fmt.Errorf("list id is not a valid integer")
+	}
+
+	var hooks []Webhook
+	if err := getWebhooksByListID(db, uint(listID), &hooks); err != nil {
+		return fmt.Errorf("error fetching webhooks: %v", err)
+	}
+	res := []webhookResponse{}
+	for i := range hooks {
+		res = append(res, toWebhookResponse(&hooks[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		return fmt.Errorf("error encoding JSON response: %v", err)
+	}
+	return nil
+}
+
+func removeWebhook(w http.ResponseWriter, r *http.Request) error {
+	hookID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || hookID <= 0 {
+		return fmt.Errorf("webhook id is not a valid integer")
+	}
+
+	var hook Webhook
+	if err := getWebhookByID(db, uint(hookID), &hook); err != nil {
+		return fmt.Errorf("webhook not found: %v", err)
+	}
+	if err := deleteWebhook(db, hook.ID); err != nil {
+		return fmt.Errorf("failed to delete webhook: %v", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// webhookDeliveryResponse is a WebhookDelivery as sent to clients.
+type webhookDeliveryResponse struct {
+	ID             uint            `json:"id"`
+	Seq            uint64          `json:"seq"`
+	Event          string          `json:"event"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	LastStatusCode int             `json:"last_status_code,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	Payload        json.RawMessage `json:"payload"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+func getWebhookDeliveryLog(w http.ResponseWriter, r *http.Request) error {
+	hookID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || hookID <= 0 {
+		return fmt.Errorf("webhook id is not a valid integer")
+	}
+	status := r.URL.Query().Get("status")
+	if status != "" && status != DeliveryPending && status != DeliveryDelivered && status != DeliveryDead {
+		return fmt.Errorf("status parameter is not a valid delivery status")
+	}
+	limit := defaultChangesLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			return fmt.Errorf("limit parameter is not a valid integer")
+		}
+		if limit > maxChangesLimit {
+			limit = maxChangesLimit
+		}
+	}
+
+	var hook Webhook
+	if err := getWebhookByID(db, uint(hookID), &hook); err != nil {
+		return fmt.Errorf("webhook not found: %v", err)
+	}
+	var deliveries []WebhookDelivery
+	if err := getWebhookDeliveries(db, hook.ID, status, limit, &deliveries); err != nil {
+		return fmt.Errorf("error fetching deliveries: %v", err)
+	}
+
+	res := []webhookDeliveryResponse{}
+	for _, delivery := range deliveries {
+		d := webhookDeliveryResponse{
+			ID:             delivery.ID,
+			Seq:            delivery.ChangeSeq,
+			Event:          delivery.EventType,
+			Status:         delivery.Status,
+			Attempts:       delivery.Attempts,
+			LastStatusCode: delivery.LastStatusCode,
+			LastError:      delivery.LastError,
+			Payload:        json.RawMessage(delivery.Payload),
+			CreatedAt:      delivery.CreatedAt,
+			UpdatedAt:      delivery.UpdatedAt,
+		}
+		if delivery.Status == DeliveryPending {
+			nextAttemptAt := delivery.NextAttemptAt
+			d.NextAttemptAt = &nextAttemptAt
+		}
+		res = append(res, d)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		return fmt.Errorf("error encoding JSON response: %v", err)
+	}
+	return nil
+}
+
+// retryWebhookDelivery sends a dead delivery again with a fresh set of
+// attempts.
+func retryWebhookDelivery(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	hookID, err := strconv.Atoi(vars["id"])
+	if err != nil || hookID <= 0 {
+		return fmt.Errorf("webhook id is not a valid integer")
+	}
+	deliveryID, err := strconv.Atoi(vars["delivery_id"])
+	if err != nil || deliveryID <= 0 {
+		return fmt.Errorf("delivery id is not a valid integer")
+	}
+
+	var delivery WebhookDelivery
+	if err := getWebhookDeliveryByID(db, uint(deliveryID), &delivery); err != nil || delivery.WebhookID != uint(hookID) {
+		return fmt.Errorf("delivery not found")
+	}
+	if delivery.Status != DeliveryDead {
+		return fmt.Errorf("delivery is %s, only dead deliveries can be retried", delivery.Status)
+	}
+
+	delivery.Status = DeliveryPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = time.Now()
+	if err := saveWebhookDelivery(db, &delivery); err != nil {
+		return fmt.Errorf("failed to retry delivery: %v", err)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	return nil
+}
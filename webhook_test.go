@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// webhookReceiver records the deliveries it gets and answers with the status
+// codes it is given, then with 200.
+type webhookReceiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rec *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.requests = append(rec.requests, r)
+	rec.bodies = append(rec.bodies, body)
+	if len(rec.statuses) > 0 {
+		status := rec.statuses[0]
+		rec.statuses = rec.statuses[1:]
+		w.WriteHeader(status)
+	}
+}
+
+// allowTestWebhooks lets webhooks post to the test servers, which listen on
+// loopback.
+func allowTestWebhooks(t *testing.T) {
+	saved := allowPrivateWebhooks
+	allowPrivateWebhooks = true
+	t.Cleanup(func() { allowPrivateWebhooks = saved })
+}
+
+func newWebhookTestRouter() *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/lists/{id}/webhooks", handleRegisterWebhook).Methods("POST")
+	router.HandleFunc("/lists/{id}/webhooks", handleListWebhooks).Methods("GET")
+	router.HandleFunc("/webhooks/{id}", handleRemoveWebhook).Methods("DELETE")
+	router.HandleFunc("/webhooks/{id}/deliveries", handleGetWebhookDeliveries).Methods("GET")
+	router.HandleFunc("/webhooks/{id}/deliveries/{delivery_id}/retry", handleRetryWebhookDelivery).Methods("POST")
+	return router
+}
+
+func registerTestWebhook(t *testing.T, router *mux.Router, listID uint, body string) webhookResponse {
+	t.Helper()
+	req := httptest.NewRequest("POST", fmt.Sprintf("/lists/%d/webhooks", listID), bytes.NewBufferString(body))
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d but got %d: %s", http.StatusCreated, res.Code, res.Body.String())
+	}
+	var hook webhookResponse
+	if err := json.Unmarshal(res.Body.Bytes(), &hook); err != nil {
+		t.Fatalf("Failed to decode webhook: %v", err)
+	}
+	return hook
+}
+
+func getTestDeliveries(t *testing.T, router *mux.Router, url string) []webhookDeliveryResponse {
+	t.Helper()
+	req := httptest.NewRequest("GET", url, nil)
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d but got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+	var deliveries []webhookDeliveryResponse
+	if err := json.Unmarshal(res.Body.Bytes(), &deliveries); err != nil {
+		t.Fatalf("Failed to decode deliveries: %v", err)
+	}
+	return deliveries
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	useTestDB(t)
+	allowTestWebhooks(t)
+	router := newWebhookTestRouter()
+
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	// Changes made before the webhook is registered are not delivered
+	appendArticle(7, Article{Title: "Before"})
+
+	hook := registerTestWebhook(t, router, 7,
+		fmt.Sprintf(`{"url": %q, "secret": "s3cret", "events": ["page_create"]}`, server.URL))
+	if hook.Secret != "s3cret" {
+		t.Errorf("Expected the secret to be returned, got %q", hook.Secret)
+	}
+
+	// Fill the first page and start a second one, on this and another list
+	for i := 0; i < NumberOfArticleInOnePage; i++ {
+		appendArticle(7, Article{Title: "Article"})
+		appendArticle(8, Article{Title: "Other list"})
+	}
+	if err := dispatchWebhooks(); err != nil {
+		t.Fatalf("Failed to dispatch webhooks: %v", err)
+	}
+
+	if len(receiver.requests) != 1 {
+		t.Fatalf("Expected 1 delivery but got %d", len(receiver.requests))
+	}
+	req, body := receiver.requests[0], receiver.bodies[0]
+	if got := req.Header.Get(WebhookEventHeader); got != EventPageCreate {
+		t.Errorf("Expected event %s but got %s", EventPageCreate, got)
+	}
+	expected := signWebhookPayload("s3cret", req.Header.Get(WebhookTimestampHeader), body)
+	if got := req.Header.Get(WebhookSignatureHeader); got != expected {
+		t.Errorf("Expected signature %s but got %s", expected, got)
+	}
+	var change changeResponse
+	if err := json.Unmarshal(body, &change); err != nil {
+		t.Fatalf("Failed to decode payload: %v", err)
+	}
+	if change.Type != EventPageCreate || change.ListID != 7 {
+		t.Errorf("Unexpected payload %s", body)
+	}
+
+	// Dispatching again does not deliver the change twice
+	dispatchWebhooks()
+	if len(receiver.requests) != 1 {
+		t.Errorf("Expected 1 delivery but got %d", len(receiver.requests))
+	}
+
+	deliveries := getTestDeliveries(t, router, fmt.Sprintf("/webhooks/%d/deliveries", hook.ID))
+	if len(deliveries) != 1 || deliveries[0].Status != DeliveryDelivered || deliveries[0].Attempts != 1 {
+		t.Errorf("Unexpected delivery log %+v", deliveries)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	useTestDB(t)
+	allowTestWebhooks(t)
+	router := newWebhookTestRouter()
+
+	savedBase, savedAttempts := webhookRetryBase, webhookMaxAttempts
+	webhookRetryBase, webhookMaxAttempts = 0, 3
+	defer func() { webhookRetryBase, webhookMaxAttempts = savedBase, savedAttempts }()
+
+	receiver := &webhookReceiver{statuses: []int{500, 503, 500, 500}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	hook := registerTestWebhook(t, router, 7, fmt.Sprintf(`{"url": %q, "events": ["append"]}`, server.URL))
+	if hook.Secret == "" {
+		t.Error("Expected a secret to be generated")
+	}
+	appendArticle(7, Article{Title: "Article"})
+
+	for i := 0; i < webhookMaxAttempts; i++ {
+		dispatchWebhooks()
+	}
+	deliveries := getTestDeliveries(t, router, fmt.Sprintf("/webhooks/%d/deliveries?status=dead", hook.ID))
+	if len(deliveries) != 1 {
+		t.Fatalf("Expected 1 dead delivery but got %+v", deliveries)
+	}
+	dead := deliveries[0]
+	if dead.Attempts != 3 || dead.LastStatusCode != 500 || dead.LastError == "" {
+		t.Errorf("Unexpected dead delivery %+v", dead)
+	}
+
+	// Dead deliveries are not sent again until they are retried
+	dispatchWebhooks()
+	if len(receiver.requests) != 3 {
+		t.Errorf("Expected 3 attempts but got %d", len(receiver.requests))
+	}
+
+	url := fmt.Sprintf("/webhooks/%d/deliveries/%d/retry", hook.ID, dead.ID)
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, httptest.NewRequest("POST", url, nil))
+	if res.Code != http.StatusAccepted {
+		t.Fatalf("Expected status code %d but got %d", http.StatusAccepted, res.Code)
+	}
+	dispatchWebhooks() // fails once more
+	dispatchWebhooks()
+	deliveries = getTestDeliveries(t, router, fmt.Sprintf("/webhooks/%d/deliveries", hook.ID))
+	if deliveries[0].Status != DeliveryDelivered || deliveries[0].Attempts != 2 {
+		t.Errorf("Expected the retried delivery to succeed, got %+v", deliveries[0])
+	}
+
+	res = httptest.NewRecorder()
+	router.ServeHTTP(res, httptest.NewRequest("POST", url, nil))
+	if res.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d but got %d", http.StatusConflict, res.Code)
+	}
+}
+
+func TestWebhookFailureDefersLaterDeliveries(t *testing.T) {
+	useTestDB(t)
+	allowTestWebhooks(t)
+	router := newWebhookTestRouter()
+
+	down := &webhookReceiver{statuses: []int{500}}
+	downServer := httptest.NewServer(down)
+	defer downServer.Close()
+	up := &webhookReceiver{}
+	upServer := httptest.NewServer(up)
+	defer upServer.Close()
+
+	registerTestWebhook(t, router, 7, fmt.Sprintf(`{"url": %q, "events": ["append"]}`, downServer.URL))
+	registerTestWebhook(t, router, 7, fmt.Sprintf(`{"url": %q, "events": ["append"]}`, upServer.URL))
+	appendArticle(7, Article{Title: "First"})
+	appendArticle(7, Article{Title: "Second"})
+
+	if err := dispatchWebhooks(); err != nil {
+		t.Fatalf("Failed to dispatch webhooks: %v", err)
+	}
+	if len(down.requests) != 1 {
+		t.Errorf("Expected the failing webhook to be tried once, got %d requests", len(down.requests))
+	}
+	if len(up.requests) != 2 {
+		t.Errorf("Expected the other webhook to get both deliveries, got %d requests", len(up.requests))
+	}
+
+	// Later deliveries keep waiting while the failed one waits for its retry
+	appendArticle(7, Article{Title: "Third"})
+	if err := dispatchWebhooks(); err != nil {
+		t.Fatalf("Failed to dispatch webhooks: %v", err)
+	}
+	if len(down.requests) != 1 {
+		t.Errorf("Expected the failing webhook to wait for its retry, got %d requests", len(down.requests))
+	}
+	if len(up.requests) != 3 {
+		t.Errorf("Expected the other webhook to get the new delivery, got %d requests", len(up.requests))
+	}
+
+	// Once the retry is due, every delivery goes out in order
+	if err := db.Table("webhook_deliveries").Where("status = ?", DeliveryPending).Update("next_attempt_at", time.Now()).Error; err != nil {
+		t.Fatalf("Failed to make the retry due: %v", err)
+	}
+	if err := dispatchWebhooks(); err != nil {
+		t.Fatalf("Failed to dispatch webhooks: %v", err)
+	}
+	var titles []string
+	for _, body := range down.bodies {
+		var change struct {
+			Data struct {
+				Article Article `json:"article"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(body, &change); err != nil {
+			t.Fatalf("Failed to decode delivery: %v", err)
+		}
+		titles = append(titles, change.Data.Article.Title)
+	}
+	if expected := []string{"First", "First", "Second", "Third"}; !reflect.DeepEqual(titles, expected) {
+		t.Errorf("Expected deliveries %v but got %v", expected, titles)
+	}
+}
+
+func TestWebhookPrivateAddresses(t *testing.T) {
+	useTestDB(t)
+	router := newWebhookTestRouter()
+
+	for _, url := range []string{"http://127.0.0.1/hook", "http://localhost:8080", "http://169.254.169.254/latest", "http://10.0.0.1", "http://[::1]/hook"} {
+		req := httptest.NewRequest("POST", "/lists/3/webhooks", bytes.NewBufferString(fmt.Sprintf(`{"url": %q}`, url)))
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("Expected %s to be refused with %d but got %d", url, http.StatusBadRequest, res.Code)
+		}
+	}
+
+	// Names are checked once they are resolved
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	hook := Webhook{URL: server.URL}
+	if _, err := sendWebhookDelivery(&hook, &WebhookDelivery{Payload: "{}"}); err == nil || !strings.Contains(err.Error(), "is private") {
+		t.Errorf("Expected the private address to be refused, got %v", err)
+	}
+	if len(receiver.requests) != 0 {
+		t.Errorf("Expected no request, got %d", len(receiver.requests))
+	}
+}
+
+func TestWebhookRetryDelay(t *testing.T) {
+	savedBase, savedMax := webhookRetryBase, webhookRetryMax
+	webhookRetryBase, webhookRetryMax = time.Second, time.Minute
+	defer func() { webhookRetryBase, webhookRetryMax = savedBase, savedMax }()
+
+	testCases := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{7, time.Minute},
+		{100, time.Minute},
+	}
+	for _, tc := range testCases {
+		if got := webhookRetryDelay(tc.attempts); got != tc.expected {
+			t.Errorf("Expected delay %v after %d attempts but got %v", tc.expected, tc.attempts, got)
+		}
+	}
+}
+
+func TestWebhookEndpoints(t *testing.T) {
+	useTestDB(t)
+	router := newWebhookTestRouter()
+
+	hook := registerTestWebhook(t, router, 3, `{"url": "http://example.com/hook"}`)
+
+	testCases := []struct {
+		name         string
+		method       string
+		url          string
+		body         string
+		expectedCode int
+	}{
+		{"invalid list", "POST", "/lists/abc/webhooks", `{"url": "http://example.com"}`, http.StatusBadRequest},
+		{"invalid url", "POST", "/lists/3/webhooks", `{"url": "ftp://example.com"}`, http.StatusBadRequest},
+		{"unknown event", "POST", "/lists/3/webhooks", `{"url": "http://example.com", "events": ["nope"]}`, http.StatusBadRequest},
+		{"list", "GET", "/lists/3/webhooks", "", http.StatusOK},
+		{"unknown webhook", "GET", "/webhooks/999/deliveries", "", http.StatusNotFound},
+		{"invalid status", "GET", fmt.Sprintf("/webhooks/%d/deliveries?status=lost", hook.ID), "", http.StatusBadRequest},
+		{"unknown delivery", "POST", fmt.Sprintf("/webhooks/%d/deliveries/999/retry", hook.ID), "", http.StatusNotFound},
+		{"delete", "DELETE", fmt.Sprintf("/webhooks/%d", hook.ID), "", http.StatusNoContent},
+		{"delete again", "DELETE", fmt.Sprintf("/webhooks/%d", hook.ID), "", http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.url, bytes.NewBufferString(tc.body))
+			res := httptest.NewRecorder()
+			router.ServeHTTP(res, req)
+			if res.Code != tc.expectedCode {
+				t.Errorf("Expected status code %d but got %d: %s", tc.expectedCode, res.Code, res.Body.String())
+			}
+			if tc.name == "list" && bytes.Contains(res.Body.Bytes(), []byte("secret")) {
+				t.Errorf("Expected the secret not to be listed, got %s", res.Body.String())
+			}
+		})
+	}
+}